@@ -10,6 +10,8 @@ import (
 	"github.com/CustomCloudStorage/databases"
 	"github.com/CustomCloudStorage/handlers"
 	"github.com/CustomCloudStorage/infrastructure/email"
+	"github.com/CustomCloudStorage/infrastructure/storage"
+	"github.com/CustomCloudStorage/jobs"
 	"github.com/CustomCloudStorage/middleware"
 	"github.com/CustomCloudStorage/repositories"
 	"github.com/CustomCloudStorage/services"
@@ -42,9 +44,20 @@ func main() {
 	uploadSessionRepo := repositories.NewUploadSessionRepository(postgresDB)
 	uploadPartRepo := repositories.NewUploadPartRepository(postgresDB)
 	trashRepo := repositories.NewTrashRepository(postgresDB)
+	blobRepo := repositories.NewBlobRepository(postgresDB)
+	fileVersionRepo := repositories.NewFileVersionRepository(postgresDB)
+	shareLinkRepo := repositories.NewShareLinkRepository(postgresDB)
+	accessGrantRepo := repositories.NewAccessGrantRepository(postgresDB)
 	authRepo := repositories.NewAuthRepository(postgresDB)
 	registerRepo := repositories.NewRegistrationRepository(postgresDB)
+	reservationRepo := repositories.NewStorageReservationRepository(postgresDB)
+	jobRepo := repositories.NewJobRepository(postgresDB)
+	periodicTaskRepo := repositories.NewPeriodicTaskRepository(postgresDB)
 	redis := repositories.NewRedisCache(redisDB)
+	emailOutbox, err := repositories.NewRedisOutbox(redisDB, "email_outbox")
+	if err != nil {
+		log.Fatalf("Failed to init the email outbox: %v", err)
+	}
 
 	email := email.NewSMTPMailer(cfg.SMTP)
 	var templates = template.New("")
@@ -56,24 +69,47 @@ func main() {
 		templates = template.Must(templates.New(name).Parse(string(content)))
 	}
 
-	fileService := services.NewFileService(userRepo, fileRepo, folderRepo, cfg.Service)
-	folderService := services.NewFolderService(fileRepo, folderRepo, cfg.Service)
-	uploadService := services.NewUploadService(userRepo, fileRepo, uploadSessionRepo, uploadPartRepo, cfg.Service)
-	trashService := services.NewTrashService(trashRepo, fileService)
+	blobStore, err := storage.NewBlobStore(cfg.Storage, cfg.Service.StorageDir)
+	if err != nil {
+		log.Fatalf("Failed to init the blob storage: %v", err)
+	}
+
+	queue := jobs.NewQueue(jobRepo, periodicTaskRepo, cfg.Jobs)
+
+	accessService := services.NewAccessService(accessGrantRepo, userRepo, fileRepo, folderRepo)
+	thumbnailService := services.NewThumbnailService(blobStore, queue, cfg.Service)
+	metadataService := services.NewMetadataService(fileRepo, blobStore, services.NewFFprobe(cfg.Service.MediaProbe), queue)
+	fileService := services.NewFileService(userRepo, fileRepo, folderRepo, trashRepo, blobStore, accessService, thumbnailService, cfg.Service)
+	versionService := services.NewVersionService(reservationRepo, fileRepo, fileVersionRepo, blobRepo, blobStore, accessService, queue, cfg.Service)
+	transferService := services.NewTransferService(reservationRepo, fileRepo, folderRepo, blobRepo, blobStore, accessService)
+	folderService := services.NewFolderService(fileRepo, folderRepo, blobStore, accessService, transferService)
+	uploadService := services.NewUploadService(reservationRepo, fileRepo, folderRepo, uploadSessionRepo, uploadPartRepo, blobRepo, blobStore, versionService, thumbnailService, metadataService, queue, cfg.Service)
+	trashService := services.NewTrashService(trashRepo, fileService, queue)
+	shareService := services.NewShareService(shareLinkRepo, fileRepo, folderRepo, fileService, folderService, cfg.Service)
 	authService := services.NewAuthService(authRepo, redis, cfg.Auth)
-	emailService := services.NewEmailService(redis, email, templates)
-	registerService := services.NewRegistrationService(registerRepo, userRepo, emailService, cfg.Service)
-	userService := services.NewUserService(userRepo, cfg.Service)
+	emailService := services.NewEmailService(emailOutbox, email, templates)
+	registerService := services.NewRegistrationService(registerRepo, userRepo, emailService, queue, cfg.Service)
+	userService := services.NewUserService(userRepo, reservationRepo, queue, cfg.Service)
+	fsckService := services.NewFsckService(blobRepo, fileVersionRepo, trashRepo, uploadSessionRepo, reservationRepo, blobStore, cfg.Service)
+	queue.Start()
 
 	authMiddleware := middleware.NewAuthMiddleware(authRepo, authService, cfg.Auth)
 
 	userHandler := handlers.NewUserHandler(userRepo, fileRepo, fileService, userService)
-	fileHandler := handlers.NewFileHandler(fileRepo, fileService)
+	fileHandler := handlers.NewFileHandler(fileRepo, fileService, transferService)
+	versionHandler := handlers.NewVersionHandler(versionService)
+	shareHandler := handlers.NewShareHandler(shareService)
+	accessHandler := handlers.NewAccessHandler(accessService)
+	transferHandler := handlers.NewTransferHandler(transferService)
 	folderHandler := handlers.NewFolderHandler(folderRepo, folderService)
 	uploadHandler := handlers.NewUploadHandler(uploadService)
+	tusHandler := handlers.NewTusHandler(uploadService)
 	trashHandler := handlers.NewTrashHandler(trashRepo, trashService)
 	authHandler := handlers.NewAuthHandler(authRepo, authService)
 	registerhandler := handlers.NewRegistrationHandler(registerRepo, registerService)
+	fsckHandler := handlers.NewFsckHandler(fsckService)
+	jobHandler := handlers.NewJobHandler(queue)
+	emailHandler := handlers.NewEmailHandler(emailService)
 
 	router := mux.NewRouter()
 
@@ -96,32 +132,74 @@ func main() {
 	adminRouter := router.PathPrefix("/admin").Subrouter()
 	adminRouter.Use(authMiddleware.RequireRole("admin", "superuser"))
 
+	adminRouter.HandleFunc("/users/storage", middleware.HandleError(userHandler.HandleStorageStats)).Methods("GET")
+	adminRouter.HandleFunc("/users/storage/reconcile", middleware.HandleError(userHandler.HandleReconcileStorage)).Methods("POST")
 	adminRouter.HandleFunc("/users/{id}", middleware.HandleError(userHandler.HandleGetUser)).Methods("GET")
 	adminRouter.HandleFunc("/users", middleware.HandleError(userHandler.HandleListUsers)).Methods("GET")
 	router.HandleFunc("/me/profile", middleware.HandleError(userHandler.HandleUpdateProfile)).Methods("PUT")
 	adminRouter.HandleFunc("/users/{id}/account", middleware.HandleError(userHandler.HandleUpdateAccount)).Methods("PUT")
 	router.HandleFunc("/me/credentials", middleware.HandleError(userHandler.HandleUpdateCredentials)).Methods("PUT")
 	adminRouter.HandleFunc("/users/{id}", middleware.HandleError(userHandler.HandleDeleteUser)).Methods("DELETE")
-	adminRouter.HandleFunc("/users/storage", middleware.HandleError(userHandler.HandleStorageStats)).Methods("GET")
+	adminRouter.HandleFunc("/uploads", middleware.HandleError(uploadHandler.ListStaleSessionsHandler)).Methods("GET")
+	adminRouter.HandleFunc("/uploads/{sessionID}", middleware.HandleError(uploadHandler.AdminAbortHandler)).Methods("DELETE")
+	adminRouter.HandleFunc("/fsck", middleware.HandleError(fsckHandler.HandleCheck)).Methods("GET")
+	adminRouter.HandleFunc("/fsck/repair", middleware.HandleError(fsckHandler.HandleRepair)).Methods("POST")
+	adminRouter.HandleFunc("/emails/stats", middleware.HandleError(emailHandler.HandleOutboxStats)).Methods("GET")
+	adminRouter.HandleFunc("/jobs", middleware.HandleError(jobHandler.HandleListJobs)).Methods("GET")
+	adminRouter.HandleFunc("/jobs/stats", middleware.HandleError(jobHandler.HandleJobStats)).Methods("GET")
+	adminRouter.HandleFunc("/jobs/periodic", middleware.HandleError(jobHandler.HandlePeriodicTasks)).Methods("GET")
+	adminRouter.HandleFunc("/jobs/{jobID}", middleware.HandleError(jobHandler.HandleGetJob)).Methods("GET")
+	adminRouter.HandleFunc("/jobs/{jobID}/retry", middleware.HandleError(jobHandler.HandleRetryJob)).Methods("POST")
+	adminRouter.HandleFunc("/jobs/{jobID}/cancel", middleware.HandleError(jobHandler.HandleCancelJob)).Methods("POST")
 
 	router.HandleFunc("/folders", middleware.HandleError(folderHandler.HandleCreateFolder)).Methods("POST")
 	router.HandleFunc("/folders/{folderID}", middleware.HandleError(folderHandler.HandleGetFolder)).Methods("GET")
 	router.HandleFunc("/folders/{folderID}", middleware.HandleError(folderHandler.HandleUpdateFolder)).Methods("PUT")
 	router.HandleFunc("/folders", middleware.HandleError(folderHandler.HandleListFolders)).Methods("GET")
 	router.HandleFunc("/folders/{folderID}/download", middleware.HandleError(folderHandler.DownloadFolderHandler)).Methods("GET")
+	router.HandleFunc("/folders/{folderID}/children", middleware.HandleError(folderHandler.HandleListChildren)).Methods("GET")
+	router.HandleFunc("/folders/{folderID}/move", middleware.HandleError(transferHandler.HandleMoveFolder)).Methods("POST")
+	router.HandleFunc("/folders/{folderID}/copy", middleware.HandleError(transferHandler.HandleCopyFolder)).Methods("POST")
 
+	router.HandleFunc("/uploads", middleware.HandleError(uploadHandler.ListSessionsHandler)).Methods("GET")
 	router.HandleFunc("/uploads/init", middleware.HandleError(uploadHandler.InitSessionHandler)).Methods("POST")
 	router.HandleFunc("/uploads/{sessionID}/{partNumber}", middleware.HandleError(uploadHandler.UploadPartHandler)).Methods("PUT")
 	router.HandleFunc("/uploads/{sessionID}/progress", middleware.HandleError(uploadHandler.ProgressHandler)).Methods("GET")
 	router.HandleFunc("/uploads/{sessionID}/complete", middleware.HandleError(uploadHandler.CompleteHandler)).Methods("POST")
 	router.HandleFunc("/uploads/{sessionID}", middleware.HandleError(uploadHandler.AbortHandler)).Methods("DELETE")
 
+	router.HandleFunc("/tus", middleware.HandleError(tusHandler.HandleOptions)).Methods("OPTIONS")
+	router.HandleFunc("/tus", middleware.HandleError(tusHandler.HandleCreate)).Methods("POST")
+	router.HandleFunc("/tus/{sessionID}", middleware.HandleError(tusHandler.HandleHead)).Methods("HEAD")
+	router.HandleFunc("/tus/{sessionID}", middleware.HandleError(tusHandler.HandlePatch)).Methods("PATCH")
+	router.HandleFunc("/tus/{sessionID}", middleware.HandleError(tusHandler.HandleDelete)).Methods("DELETE")
+
 	router.HandleFunc("/files/{fileID}", middleware.HandleError(fileHandler.HandleGetFile)).Methods("GET")
 	router.HandleFunc("/files", middleware.HandleError(fileHandler.HandleListFiles)).Methods("GET")
+	router.HandleFunc("/files", middleware.HandleError(uploadHandler.HandleUploadFile)).Methods("POST")
+	router.HandleFunc("/search", middleware.HandleError(fileHandler.HandleSearch)).Methods("GET")
 	router.HandleFunc("/files/{fileID}/name", middleware.HandleError(fileHandler.HandleUpdateName)).Methods("PUT")
 	router.HandleFunc("/files/{fileID}/folderID", middleware.HandleError(fileHandler.HandleUpdateFolderID)).Methods("PUT")
+	router.HandleFunc("/files/{fileID}/move", middleware.HandleError(transferHandler.HandleMoveFile)).Methods("POST")
+	router.HandleFunc("/files/{fileID}/copy", middleware.HandleError(transferHandler.HandleCopyFile)).Methods("POST")
 	router.HandleFunc("/files/{fileID}/download-url", middleware.HandleError(fileHandler.DownloadURLHandler)).Methods("GET")
-	router.HandleFunc("/files/download", middleware.HandleError(fileHandler.DownloadByTokenHandler)).Methods("GET")
+	router.HandleFunc("/files/download", middleware.HandleError(fileHandler.DownloadByTokenHandler)).Methods("GET", "HEAD")
+	router.HandleFunc("/files/{fileID}/stream", middleware.HandleError(fileHandler.StreamFileHandler)).Methods("GET", "HEAD")
+	router.HandleFunc("/files/{fileID}/preview", middleware.HandleError(fileHandler.PreviewFileHandler)).Methods("GET", "HEAD")
+	router.HandleFunc("/files/{fileID}/versions", middleware.HandleError(versionHandler.HandleListVersions)).Methods("GET")
+	router.HandleFunc("/files/{fileID}/versions/{version}/download", middleware.HandleError(versionHandler.HandleDownloadVersion)).Methods("GET", "HEAD")
+	router.HandleFunc("/files/{fileID}/versions/{version}/restore", middleware.HandleError(versionHandler.HandleRestoreVersion)).Methods("POST")
+
+	router.HandleFunc("/shares", middleware.HandleError(shareHandler.HandleCreateShare)).Methods("POST")
+	router.HandleFunc("/shares", middleware.HandleError(shareHandler.HandleListShares)).Methods("GET")
+	router.HandleFunc("/shares/{shareID}", middleware.HandleError(shareHandler.HandleRevokeShare)).Methods("DELETE")
+	router.HandleFunc("/public/shares/{token}", middleware.HandleError(shareHandler.HandlePublicBrowse)).Methods("GET")
+	router.HandleFunc("/public/shares/{token}/download", middleware.HandleError(shareHandler.HandlePublicDownload)).Methods("GET", "HEAD")
+
+	router.HandleFunc("/access", middleware.HandleError(accessHandler.HandleGrantAccess)).Methods("POST")
+	router.HandleFunc("/access", middleware.HandleError(accessHandler.HandleListGrants)).Methods("GET")
+	router.HandleFunc("/access/{grantID}", middleware.HandleError(accessHandler.HandleRevokeAccess)).Methods("DELETE")
+	router.HandleFunc("/shared-with-me", middleware.HandleError(accessHandler.HandleSharedWithMe)).Methods("GET")
 
 	router.HandleFunc("/trash/files", middleware.HandleError(trashHandler.ListFilesHandler)).Methods("GET")
 	router.HandleFunc("/trash/files/{fileID}", middleware.HandleError(trashHandler.DeleteFileHandler)).Methods("DELETE")
@@ -135,8 +213,9 @@ func main() {
 	c := cors.New(cors.Options{
 		AllowedOrigins:   []string{cfg.Cors.AllowedOrigin},
 		AllowCredentials: true,
-		AllowedMethods:   []string{"GET", "POST", "PUT", "DELETE"},
-		AllowedHeaders:   []string{"Authorization", "Content-Type", "Token"},
+		AllowedMethods:   []string{"GET", "POST", "PUT", "PATCH", "HEAD", "DELETE"},
+		AllowedHeaders:   []string{"Authorization", "Content-Type", "Token", "X-Share-Password", "Content-MD5", "X-Content-SHA256", "Tus-Resumable", "Upload-Length", "Upload-Metadata", "Upload-Offset", "Upload-Checksum", "Range", "If-Range", "If-None-Match", "If-Modified-Since"},
+		ExposedHeaders:   []string{"Location", "Tus-Resumable", "Tus-Version", "Tus-Extension", "Tus-Checksum-Algorithm", "Upload-Offset", "Upload-Length", "Accept-Ranges", "Content-Range", "Content-Disposition", "ETag"},
 		Debug:            false,
 	})
 
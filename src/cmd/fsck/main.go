@@ -0,0 +1,58 @@
+// Command fsck cross-checks the database, the blob store and the upload temp
+// dir and prints a JSON report; with -repair it fixes the problems found.
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"flag"
+	"log"
+	"os"
+
+	"github.com/CustomCloudStorage/config"
+	"github.com/CustomCloudStorage/databases"
+	"github.com/CustomCloudStorage/infrastructure/storage"
+	"github.com/CustomCloudStorage/repositories"
+	"github.com/CustomCloudStorage/services"
+)
+
+func main() {
+	repair := flag.Bool("repair", false, "fix the problems found instead of only reporting them")
+	flag.Parse()
+
+	cfg, err := config.LoadConfig()
+	if err != nil {
+		log.Fatalf("Failed to load the config: %v", err)
+	}
+	postgresDB, err := databases.GetDB(cfg.Postgres)
+	if err != nil {
+		log.Fatalf("Failed to connect to the database: %v", err)
+	}
+	blobStore, err := storage.NewBlobStore(cfg.Storage, cfg.Service.StorageDir)
+	if err != nil {
+		log.Fatalf("Failed to init the blob storage: %v", err)
+	}
+
+	fsckService := services.NewFsckService(
+		repositories.NewBlobRepository(postgresDB),
+		repositories.NewFileVersionRepository(postgresDB),
+		repositories.NewTrashRepository(postgresDB),
+		repositories.NewUploadSessionRepository(postgresDB),
+		repositories.NewStorageReservationRepository(postgresDB),
+		blobStore,
+		cfg.Service,
+	)
+	report, err := fsckService.Check(context.Background(), *repair)
+	if err != nil {
+		log.Fatalf("fsck failed: %v", err)
+	}
+
+	enc := json.NewEncoder(os.Stdout)
+	enc.SetIndent("", "  ")
+	if err := enc.Encode(report); err != nil {
+		log.Fatalf("Failed to write the report: %v", err)
+	}
+	if len(report.Errors) > 0 || (!*repair && !report.Clean()) {
+		os.Exit(1)
+	}
+}
@@ -5,6 +5,8 @@ import (
 
 	"github.com/CustomCloudStorage/databases"
 	"github.com/CustomCloudStorage/infrastructure/email"
+	"github.com/CustomCloudStorage/infrastructure/storage"
+	"github.com/CustomCloudStorage/jobs"
 	"github.com/CustomCloudStorage/services"
 	"github.com/go-playground/validator"
 	"github.com/spf13/viper"
@@ -19,6 +21,8 @@ type Config struct {
 	Auth      services.Auth            `validate:"required"`
 	SMTP      email.SMTPConfig         `validate:"required"`
 	Superuser SuperuserConfig          `mapstructure:"superuser"`
+	Storage   storage.Config
+	Jobs      jobs.Config
 }
 
 type CORSConfig struct {
@@ -4,9 +4,13 @@ go 1.23.4
 
 require (
 	github.com/disintegration/imaging v1.6.2
+	github.com/gabriel-vasile/mimetype v1.4.13
 	github.com/golang-migrate/migrate/v4 v4.18.2
+	github.com/ledongthuc/pdf v0.0.0-20220302134840-0c2507a12d80
+	github.com/rwcarlsen/goexif v0.0.0-20190401172101-9e8deecbddbd
 	github.com/spf13/viper v1.19.0
 	golang.org/x/crypto v0.32.0
+	golang.org/x/image v0.0.0-20191009234506-e7c1f5e7dbb8
 	gorm.io/gorm v1.25.10
 )
 
@@ -31,7 +35,6 @@ require (
 	github.com/mailru/easyjson v0.7.6 // indirect
 	github.com/nxadm/tail v1.4.11 // indirect
 	github.com/swaggo/swag v1.8.1 // indirect
-	golang.org/x/image v0.0.0-20191009234506-e7c1f5e7dbb8 // indirect
 	golang.org/x/net v0.34.0 // indirect
 	golang.org/x/sync v0.10.0 // indirect
 	golang.org/x/tools v0.24.0 // indirect
@@ -0,0 +1,122 @@
+package handlers
+
+import (
+	"encoding/json"
+	"net/http"
+	"strconv"
+
+	"github.com/CustomCloudStorage/middleware"
+	"github.com/CustomCloudStorage/services"
+	"github.com/CustomCloudStorage/types"
+	"github.com/CustomCloudStorage/utils"
+	"github.com/dgrijalva/jwt-go"
+	"github.com/gorilla/mux"
+)
+
+type AccessHandler interface {
+	HandleGrantAccess(w http.ResponseWriter, r *http.Request) error
+	HandleListGrants(w http.ResponseWriter, r *http.Request) error
+	HandleRevokeAccess(w http.ResponseWriter, r *http.Request) error
+	HandleSharedWithMe(w http.ResponseWriter, r *http.Request) error
+}
+
+type accessHandler struct {
+	accessService services.AccessService
+}
+
+func NewAccessHandler(accessService services.AccessService) AccessHandler {
+	return &accessHandler{
+		accessService: accessService,
+	}
+}
+
+func (h *accessHandler) HandleGrantAccess(w http.ResponseWriter, r *http.Request) error {
+	ctx := r.Context()
+	claims := ctx.Value("claims").(jwt.MapClaims)
+	userID, ok := claims["userID"].(float64)
+	if !ok {
+		return utils.ErrUnauthorized.New("invalid userID")
+	}
+
+	var req struct {
+		Email      string `json:"email"`
+		FileID     *int   `json:"file_id"`
+		FolderID   *int   `json:"folder_id"`
+		Permission string `json:"permission"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		return utils.ErrBadRequest.Wrap(err, "decode access grant payload")
+	}
+
+	grant := &types.AccessGrant{
+		FileID:     req.FileID,
+		FolderID:   req.FolderID,
+		Permission: req.Permission,
+	}
+	grant, err := h.accessService.Grant(ctx, int(userID), req.Email, grant)
+	if err != nil {
+		return err
+	}
+
+	return middleware.WriteJSONResponse(w, http.StatusCreated, map[string]interface{}{
+		"grant": grant,
+	})
+}
+
+func (h *accessHandler) HandleListGrants(w http.ResponseWriter, r *http.Request) error {
+	ctx := r.Context()
+	claims := ctx.Value("claims").(jwt.MapClaims)
+	userID, ok := claims["userID"].(float64)
+	if !ok {
+		return utils.ErrUnauthorized.New("invalid userID")
+	}
+
+	grants, err := h.accessService.ListGrants(ctx, int(userID))
+	if err != nil {
+		return err
+	}
+
+	return middleware.WriteJSONResponse(w, http.StatusOK, map[string]interface{}{
+		"grants": grants,
+	})
+}
+
+func (h *accessHandler) HandleRevokeAccess(w http.ResponseWriter, r *http.Request) error {
+	ctx := r.Context()
+	claims := ctx.Value("claims").(jwt.MapClaims)
+	userID, ok := claims["userID"].(float64)
+	if !ok {
+		return utils.ErrUnauthorized.New("invalid userID")
+	}
+
+	grantID, err := strconv.Atoi(mux.Vars(r)["grantID"])
+	if err != nil {
+		return utils.ErrBadRequest.Wrap(err, "invalid grant ID")
+	}
+
+	if err := h.accessService.Revoke(ctx, int(userID), grantID); err != nil {
+		return err
+	}
+
+	return middleware.WriteJSONResponse(w, http.StatusOK, map[string]interface{}{
+		"message": "access revoked",
+	})
+}
+
+func (h *accessHandler) HandleSharedWithMe(w http.ResponseWriter, r *http.Request) error {
+	ctx := r.Context()
+	claims := ctx.Value("claims").(jwt.MapClaims)
+	userID, ok := claims["userID"].(float64)
+	if !ok {
+		return utils.ErrUnauthorized.New("invalid userID")
+	}
+
+	grants, err := h.accessService.SharedWithMe(ctx, int(userID))
+	if err != nil {
+		return err
+	}
+
+	return middleware.WriteJSONResponse(w, http.StatusOK, map[string]interface{}{
+		"shared": grants,
+	})
+}
@@ -0,0 +1,64 @@
+package handlers
+
+import (
+	"mime"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"github.com/CustomCloudStorage/types"
+)
+
+// serveFile writes dfile with http.ServeContent, which answers HEAD, single
+// and multipart byte ranges, If-Range and the If-None-Match and
+// If-Modified-Since preconditions against the ETag and ModTime set here.
+// disposition is "inline" or "attachment".
+func serveFile(w http.ResponseWriter, r *http.Request, dfile *types.DownloadedFile, disposition string) {
+	header := w.Header()
+	header.Set("Content-Type", dfile.ContentType)
+	header.Set("Content-Disposition", mime.FormatMediaType(disposition, map[string]string{"filename": dfile.FileName}))
+	header.Set("Accept-Ranges", "bytes")
+	if header.Get("Cache-Control") == "" {
+		header.Set("Cache-Control", "private, no-cache")
+	}
+	if dfile.ETag != "" {
+		header.Set("ETag", dfile.ETag)
+	}
+	http.ServeContent(w, r, dfile.FileName, dfile.ModTime, dfile.Reader)
+}
+
+// startsDownload reports whether serving r hands out content that has not been
+// counted yet. Only a ranged GET whose If-Range matches the ETag, and so
+// continues a download of the same content, is free, and only as long as none
+// of its ranges reaches back to the first byte.
+func startsDownload(r *http.Request, dfile *types.DownloadedFile) bool {
+	if r.Method != http.MethodGet {
+		return false
+	}
+	rng := r.Header.Get("Range")
+	if rng == "" || dfile.ETag == "" || r.Header.Get("If-Range") != dfile.ETag {
+		return true
+	}
+	specs, ok := strings.CutPrefix(rng, "bytes=")
+	if !ok {
+		return true
+	}
+	for _, spec := range strings.Split(specs, ",") {
+		start, end, ok := strings.Cut(strings.TrimSpace(spec), "-")
+		if !ok {
+			return true
+		}
+		if start == "" {
+			n, err := strconv.ParseInt(end, 10, 64)
+			if err != nil || n >= dfile.FileSize {
+				return true
+			}
+			continue
+		}
+		n, err := strconv.ParseInt(start, 10, 64)
+		if err != nil || n == 0 {
+			return true
+		}
+	}
+	return false
+}
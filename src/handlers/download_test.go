@@ -0,0 +1,49 @@
+package handlers
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/CustomCloudStorage/types"
+)
+
+func TestStartsDownload(t *testing.T) {
+	dfile := &types.DownloadedFile{FileSize: 1000, ETag: `"abc"`}
+
+	tests := []struct {
+		name    string
+		method  string
+		rng     string
+		ifRange string
+		want    bool
+	}{
+		{"whole file", http.MethodGet, "", "", true},
+		{"head", http.MethodHead, "", "", false},
+		{"first range", http.MethodGet, "bytes=0-99", `"abc"`, true},
+		{"later range without If-Range", http.MethodGet, "bytes=1-", "", true},
+		{"later range with other If-Range", http.MethodGet, "bytes=500-", `"other"`, true},
+		{"later range with date If-Range", http.MethodGet, "bytes=500-", "Mon, 02 Jan 2006 15:04:05 GMT", true},
+		{"resumed download", http.MethodGet, "bytes=500-", `"abc"`, false},
+		{"several later ranges", http.MethodGet, "bytes=100-199, 500-", `"abc"`, false},
+		{"range list reaching back to byte 0", http.MethodGet, "bytes=1-,0-0", `"abc"`, true},
+		{"short suffix", http.MethodGet, "bytes=-100", `"abc"`, false},
+		{"suffix covering the file", http.MethodGet, "bytes=-1000", `"abc"`, true},
+		{"other unit", http.MethodGet, "items=5-", `"abc"`, true},
+		{"malformed", http.MethodGet, "bytes=abc", `"abc"`, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			r := httptest.NewRequest(tt.method, "/download", nil)
+			if tt.rng != "" {
+				r.Header.Set("Range", tt.rng)
+			}
+			if tt.ifRange != "" {
+				r.Header.Set("If-Range", tt.ifRange)
+			}
+			if got := startsDownload(r, dfile); got != tt.want {
+				t.Fatalf("startsDownload = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,32 @@
+package handlers
+
+import (
+	"net/http"
+
+	"github.com/CustomCloudStorage/middleware"
+	"github.com/CustomCloudStorage/services"
+)
+
+type EmailHandler interface {
+	HandleOutboxStats(w http.ResponseWriter, r *http.Request) error
+}
+
+type emailHandler struct {
+	emailService services.EmailService
+}
+
+func NewEmailHandler(emailService services.EmailService) EmailHandler {
+	return &emailHandler{
+		emailService: emailService,
+	}
+}
+
+// HandleOutboxStats counts the emails waiting to be sent, those that failed
+// for good and those sent so far.
+func (h *emailHandler) HandleOutboxStats(w http.ResponseWriter, r *http.Request) error {
+	stats, err := h.emailService.Stats(r.Context())
+	if err != nil {
+		return err
+	}
+	return middleware.WriteJSONResponse(w, http.StatusOK, stats)
+}
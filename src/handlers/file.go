@@ -2,15 +2,15 @@ package handlers
 
 import (
 	"encoding/json"
-	"fmt"
 	"io"
 	"net/http"
 	"strconv"
-	"time"
+	"strings"
 
 	"github.com/CustomCloudStorage/middleware"
 	"github.com/CustomCloudStorage/repositories"
 	"github.com/CustomCloudStorage/services"
+	"github.com/CustomCloudStorage/types"
 	"github.com/CustomCloudStorage/utils"
 	"github.com/dgrijalva/jwt-go"
 	"github.com/gorilla/mux"
@@ -19,6 +19,7 @@ import (
 type FileHandler interface {
 	HandleGetFile(w http.ResponseWriter, r *http.Request) error
 	HandleListFiles(w http.ResponseWriter, r *http.Request) error
+	HandleSearch(w http.ResponseWriter, r *http.Request) error
 	HandleUpdateName(w http.ResponseWriter, r *http.Request) error
 	HandleUpdateFolderID(w http.ResponseWriter, r *http.Request) error
 	DownloadURLHandler(w http.ResponseWriter, r *http.Request) error
@@ -28,14 +29,16 @@ type FileHandler interface {
 }
 
 type fileHandler struct {
-	fileRepository repositories.FileRepository
-	fileService    services.FileService
+	fileRepository  repositories.FileRepository
+	fileService     services.FileService
+	transferService services.TransferService
 }
 
-func NewFileHandler(fileRepository repositories.FileRepository, fileService services.FileService) FileHandler {
+func NewFileHandler(fileRepository repositories.FileRepository, fileService services.FileService, transferService services.TransferService) FileHandler {
 	return &fileHandler{
-		fileRepository: fileRepository,
-		fileService:    fileService,
+		fileRepository:  fileRepository,
+		fileService:     fileService,
+		transferService: transferService,
 	}
 }
 
@@ -71,16 +74,130 @@ func (h *fileHandler) HandleListFiles(w http.ResponseWriter, r *http.Request) er
 		return utils.ErrUnauthorized.New("invalid userID")
 	}
 
-	files, err := h.fileRepository.ListByUserID(ctx, int(userID))
+	page, err := parsePageQuery(r, "id")
+	if err != nil {
+		return err
+	}
+	contentType, err := contentTypeQuery(r, "content_type")
+	if err != nil {
+		return err
+	}
+
+	files, next, err := h.fileRepository.ListByUserID(ctx, int(userID), contentType, page)
 	if err != nil {
 		return err
 	}
 
 	return middleware.WriteJSONResponse(w, http.StatusOK, map[string]interface{}{
-		"files": files,
+		"files":       files,
+		"next_cursor": next,
 	})
 }
 
+func (h *fileHandler) HandleSearch(w http.ResponseWriter, r *http.Request) error {
+	ctx := r.Context()
+	claims := ctx.Value("claims").(jwt.MapClaims)
+	userID, ok := claims["userID"].(float64)
+	if !ok {
+		return utils.ErrUnauthorized.New("invalid userID")
+	}
+
+	query, err := parseFileSearch(r)
+	if err != nil {
+		return err
+	}
+
+	result, err := h.fileRepository.Search(ctx, int(userID), query)
+	if err != nil {
+		return err
+	}
+
+	return middleware.WriteJSONResponse(w, http.StatusOK, result)
+}
+
+func parseFileSearch(r *http.Request) (*types.FileSearch, error) {
+	q := r.URL.Query()
+	query := &types.FileSearch{
+		Name:      strings.TrimSpace(q.Get("name")),
+		Extension: strings.TrimSpace(q.Get("extension")),
+		Camera:    strings.TrimSpace(q.Get("camera")),
+		Title:     strings.TrimSpace(q.Get("title")),
+		Sort:      q.Get("sort"),
+		Order:     q.Get("order"),
+	}
+	if query.Extension != "" && !strings.HasPrefix(query.Extension, ".") {
+		query.Extension = "." + query.Extension
+	}
+
+	switch query.Sort {
+	case "":
+		query.Sort = "name"
+	case "name", "size", "created_at", "updated_at", "taken_at":
+	default:
+		return nil, utils.ErrBadRequest.New("invalid sort %q", query.Sort)
+	}
+	switch strings.ToLower(query.Order) {
+	case "", "asc", "desc":
+	default:
+		return nil, utils.ErrBadRequest.New("invalid order %q", query.Order)
+	}
+
+	var err error
+	if query.ContentType, err = contentTypeQuery(r, "content_type"); err != nil {
+		return nil, err
+	}
+	if query.MinSize, err = optionalInt64Query(r, "min_size"); err != nil {
+		return nil, err
+	}
+	if query.MaxSize, err = optionalInt64Query(r, "max_size"); err != nil {
+		return nil, err
+	}
+	if query.CreatedAfter, err = optionalTimeQuery(r, "created_after"); err != nil {
+		return nil, err
+	}
+	if query.CreatedBefore, err = optionalTimeQuery(r, "created_before"); err != nil {
+		return nil, err
+	}
+	if query.UpdatedAfter, err = optionalTimeQuery(r, "updated_after"); err != nil {
+		return nil, err
+	}
+	if query.UpdatedBefore, err = optionalTimeQuery(r, "updated_before"); err != nil {
+		return nil, err
+	}
+	if query.TakenAfter, err = optionalTimeQuery(r, "taken_after"); err != nil {
+		return nil, err
+	}
+	if query.TakenBefore, err = optionalTimeQuery(r, "taken_before"); err != nil {
+		return nil, err
+	}
+	if query.HasLocation, err = boolQuery(r, "has_location"); err != nil {
+		return nil, err
+	}
+	if query.FolderID, err = optionalIntQuery(r, "folder_id"); err != nil {
+		return nil, err
+	}
+	if query.IncludeDeleted, err = boolQuery(r, "include_deleted"); err != nil {
+		return nil, err
+	}
+	if query.Limit, err = intQuery(r, "limit", defaultPageLimit); err != nil {
+		return nil, err
+	}
+	if query.Offset, err = intQuery(r, "offset", 0); err != nil {
+		return nil, err
+	}
+
+	if query.Limit < 1 || query.Limit > maxPageLimit {
+		return nil, utils.ErrBadRequest.New("limit must be between 1 and %d", maxPageLimit)
+	}
+	if query.Offset < 0 {
+		return nil, utils.ErrBadRequest.New("offset must not be negative")
+	}
+	if query.MinSize != nil && query.MaxSize != nil && *query.MinSize > *query.MaxSize {
+		return nil, utils.ErrBadRequest.New("min_size must not exceed max_size")
+	}
+	return query, nil
+}
+
 func (h *fileHandler) HandleUpdateName(w http.ResponseWriter, r *http.Request) error {
 	ctx := r.Context()
 	claims := ctx.Value("claims").(jwt.MapClaims)
@@ -94,16 +211,25 @@ func (h *fileHandler) HandleUpdateName(w http.ResponseWriter, r *http.Request) e
 		return utils.ErrBadRequest.Wrap(err, "invalid file ID")
 	}
 
-	var payload struct{ Name string }
+	var payload struct {
+		Name       string
+		OnConflict string `json:"on_conflict"`
+	}
 	if err := json.NewDecoder(r.Body).Decode(&payload); err != nil {
 		return utils.ErrBadRequest.Wrap(err, "invalid JSON payload")
 	}
+	policy, err := parseConflictPolicy(payload.OnConflict, types.ConflictFail)
+	if err != nil {
+		return err
+	}
 
-	if err := h.fileRepository.UpdateName(ctx, fileID, int(userID), payload.Name); err != nil {
+	file, err := h.fileService.RenameFile(ctx, int(userID), fileID, payload.Name, policy)
+	if err != nil {
 		return err
 	}
 
 	return middleware.WriteJSONResponse(w, http.StatusOK, map[string]interface{}{
+		"file":    file,
 		"message": "file name updated successfully",
 	})
 }
@@ -122,12 +248,12 @@ func (h *fileHandler) HandleUpdateFolderID(w http.ResponseWriter, r *http.Reques
 		return utils.ErrBadRequest.Wrap(err, "invalid file ID")
 	}
 
-	var payload struct{ FolderID int }
+	var payload struct{ FolderID *int }
 	if err := json.NewDecoder(r.Body).Decode(&payload); err != nil {
 		return utils.ErrBadRequest.Wrap(err, "invalid JSON payload")
 	}
 
-	if err := h.fileRepository.UpdateFolder(ctx, fileID, int(userID), payload.FolderID); err != nil {
+	if _, err := h.transferService.MoveFile(ctx, int(userID), fileID, payload.FolderID, types.ConflictFail); err != nil {
 		return err
 	}
 
@@ -159,8 +285,10 @@ func (h *fileHandler) DownloadURLHandler(w http.ResponseWriter, r *http.Request)
 	})
 }
 
+// DownloadByTokenHandler serves the file behind a signed download URL. It needs
+// no session, so the token is all that authorizes the request.
 func (h *fileHandler) DownloadByTokenHandler(w http.ResponseWriter, r *http.Request) error {
-	token := r.URL.Query().Get("download_url")
+	token := r.URL.Query().Get("token")
 	userID, fileID, err := h.fileService.ValidateDownloadToken(token)
 	if err != nil {
 		return utils.ErrBadRequest.Wrap(err, "invalid download token")
@@ -172,9 +300,7 @@ func (h *fileHandler) DownloadByTokenHandler(w http.ResponseWriter, r *http.Requ
 	}
 	defer dfile.Reader.(io.Closer).Close()
 
-	w.Header().Set("Content-Type", dfile.ContentType)
-	w.Header().Set("Content-Disposition", fmt.Sprintf(`attachment; filename="%s"`, dfile.FileName))
-	w.Header().Set("Content-Length", strconv.FormatInt(dfile.FileSize, 10))
+	serveFile(w, r, dfile, "attachment")
 	return nil
 }
 
@@ -197,23 +323,13 @@ func (h *fileHandler) StreamFileHandler(w http.ResponseWriter, r *http.Request)
 	}
 	defer dfile.Reader.(io.Closer).Close()
 
-	ifModifiedSince := r.Header.Get("If-Modified-Since")
-	if t, err := time.Parse(http.TimeFormat, ifModifiedSince); err == nil {
-		if !dfile.ModTime.After(t) {
-			w.WriteHeader(http.StatusNotModified)
-			return nil
-		}
-	}
-
-	w.Header().Set("Content-Type", dfile.ContentType)
-	w.Header().Set("Content-Disposition", fmt.Sprintf(`inline; filename="%s"`, dfile.FileName))
-	w.Header().Set("Last-Modified", dfile.ModTime.UTC().Format(http.TimeFormat))
-	w.Header().Set("Accept-Ranges", "bytes")
-
-	http.ServeContent(w, r, dfile.FileName, dfile.ModTime, dfile.Reader)
+	serveFile(w, r, dfile, "inline")
 	return nil
 }
 
+// PreviewFileHandler serves a thumbnail of an image file. The "size" query
+// parameter picks one of services.ThumbnailSizes (default 200); "format" is
+// "webp" or "jpeg", otherwise WebP is served to clients that accept it.
 func (h *fileHandler) PreviewFileHandler(w http.ResponseWriter, r *http.Request) error {
 	ctx := r.Context()
 	claims := ctx.Value("claims").(jwt.MapClaims)
@@ -225,27 +341,26 @@ func (h *fileHandler) PreviewFileHandler(w http.ResponseWriter, r *http.Request)
 	if err != nil {
 		return utils.ErrBadRequest.Wrap(err, "invalid file ID")
 	}
-
-	modTime, err := h.fileService.PreviewFile(ctx, int(userID), fileID, io.Discard)
+	size, err := intQuery(r, "size", services.DefaultThumbnailSize)
 	if err != nil {
 		return err
 	}
 
-	ifModifiedSince := r.Header.Get("If-Modified-Since")
-	if t, err := time.Parse(http.TimeFormat, ifModifiedSince); err == nil {
-		if !modTime.After(t) {
-			w.WriteHeader(http.StatusNotModified)
-			return nil
-		}
+	formats := []string{services.ThumbnailJPEG}
+	if format := r.URL.Query().Get("format"); format != "" {
+		formats = []string{format}
+	} else if strings.Contains(r.Header.Get("Accept"), "image/webp") {
+		formats = []string{services.ThumbnailWebP, services.ThumbnailJPEG}
 	}
 
-	w.Header().Set("Content-Type", "image/jpeg")
-	w.Header().Set("Cache-Control", "public, max-age=86400")
-	w.Header().Set("Last-Modified", modTime.UTC().Format(http.TimeFormat))
-
-	_, err = h.fileService.PreviewFile(ctx, int(userID), fileID, w)
+	preview, err := h.fileService.PreviewFile(ctx, int(userID), fileID, size, formats)
 	if err != nil {
-		return utils.ErrInternal.Wrap(err, "generate preview")
+		return err
 	}
+	defer preview.Reader.(io.Closer).Close()
+
+	w.Header().Set("Cache-Control", "private, max-age=86400")
+	w.Header().Set("Vary", "Accept")
+	serveFile(w, r, preview, "inline")
 	return nil
 }
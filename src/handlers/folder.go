@@ -22,6 +22,7 @@ type FolderHandler interface {
 	HandleUpdateFolder(w http.ResponseWriter, r *http.Request) error
 	HandleListFolders(w http.ResponseWriter, r *http.Request) error
 	DownloadFolderHandler(w http.ResponseWriter, r *http.Request) error
+	HandleListChildren(w http.ResponseWriter, r *http.Request) error
 }
 
 type folderHandler struct {
@@ -36,6 +37,11 @@ func NewFolderHandler(folderRepository repositories.FolderRepository, folderServ
 	}
 }
 
+type folderRequest struct {
+	types.Folder
+	OnConflict string `json:"on_conflict"`
+}
+
 func (h *folderHandler) HandleCreateFolder(w http.ResponseWriter, r *http.Request) error {
 	ctx := r.Context()
 
@@ -45,19 +51,23 @@ func (h *folderHandler) HandleCreateFolder(w http.ResponseWriter, r *http.Reques
 		return utils.ErrUnauthorized.New("invalid userID")
 	}
 
-	var folder types.Folder
-	if err := json.NewDecoder(r.Body).Decode(&folder); err != nil {
+	var req folderRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
 		return utils.ErrBadRequest.Wrap(err, "decode folder JSON")
 	}
+	policy, err := parseConflictPolicy(req.OnConflict, types.ConflictFail)
+	if err != nil {
+		return err
+	}
 
-	folder.UserID = int(userID)
-
-	if err := h.folderRepository.Create(ctx, &folder); err != nil {
+	folder := req.Folder
+	if err := h.folderService.CreateFolder(ctx, int(userID), &folder, policy); err != nil {
 		return err
 	}
 
 	return middleware.WriteJSONResponse(w, http.StatusCreated, map[string]interface{}{
 		"folder_id": folder.ID,
+		"name":      folder.Name,
 		"message":   "folder created successfully",
 	})
 }
@@ -100,27 +110,22 @@ func (h *folderHandler) HandleUpdateFolder(w http.ResponseWriter, r *http.Reques
 		return utils.ErrBadRequest.Wrap(err, "invalid folder ID")
 	}
 
-	var req types.Folder
+	var req folderRequest
 	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
 		return utils.ErrBadRequest.Wrap(err, "decode folder JSON")
 	}
-
-	current, err := h.folderRepository.GetByID(ctx, folderID, int(userID))
+	policy, err := parseConflictPolicy(req.OnConflict, types.ConflictFail)
 	if err != nil {
 		return err
 	}
-	if !req.UpdatedAt.Equal(current.UpdatedAt) {
-		return utils.ErrConflict.New("the folder was modified by another process")
-	}
-
-	current.Name = req.Name
-	current.ParentID = req.ParentID
 
-	if err := h.folderRepository.Update(ctx, current); err != nil {
+	folder, err := h.folderService.UpdateFolder(ctx, int(userID), folderID, &req.Folder, policy)
+	if err != nil {
 		return err
 	}
 
 	return middleware.WriteJSONResponse(w, http.StatusOK, map[string]interface{}{
+		"folder":  folder,
 		"message": "folder updated successfully",
 	})
 }
@@ -133,13 +138,19 @@ func (h *folderHandler) HandleListFolders(w http.ResponseWriter, r *http.Request
 		return utils.ErrUnauthorized.New("invalid userID")
 	}
 
-	folders, err := h.folderRepository.ListByUserID(ctx, int(userID))
+	page, err := parsePageQuery(r, "id")
+	if err != nil {
+		return err
+	}
+
+	folders, next, err := h.folderRepository.ListByUserID(ctx, int(userID), page)
 	if err != nil {
 		return err
 	}
 
 	return middleware.WriteJSONResponse(w, http.StatusOK, map[string]interface{}{
-		"folders": folders,
+		"folders":     folders,
+		"next_cursor": next,
 	})
 }
 
@@ -165,9 +176,38 @@ func (h *folderHandler) DownloadFolderHandler(w http.ResponseWriter, r *http.Req
 
 	w.Header().Set("Content-Type", "application/zip")
 	w.Header().Set("Content-Disposition", fmt.Sprintf(`attachment; filename="%s"`, archiveName))
+	// The archive is built while it streams, so it cannot be served in ranges.
+	w.Header().Set("Accept-Ranges", "none")
 
 	if _, err := io.Copy(w, reader); err != nil {
 		return utils.ErrInternal.Wrap(err, "stream zip archive")
 	}
 	return nil
 }
+
+func (h *folderHandler) HandleListChildren(w http.ResponseWriter, r *http.Request) error {
+	ctx := r.Context()
+	params := mux.Vars(r)
+
+	claims := ctx.Value("claims").(jwt.MapClaims)
+	userID, ok := claims["userID"].(float64)
+	if !ok {
+		return utils.ErrUnauthorized.New("invalid userID")
+	}
+
+	var folderID *int
+	if params["folderID"] != "root" {
+		id, err := strconv.Atoi(params["folderID"])
+		if err != nil {
+			return utils.ErrBadRequest.Wrap(err, "invalid folder ID")
+		}
+		folderID = &id
+	}
+
+	children, err := h.folderService.ListChildren(ctx, int(userID), folderID)
+	if err != nil {
+		return err
+	}
+
+	return middleware.WriteJSONResponse(w, http.StatusOK, children)
+}
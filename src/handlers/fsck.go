@@ -0,0 +1,40 @@
+package handlers
+
+import (
+	"net/http"
+
+	"github.com/CustomCloudStorage/middleware"
+	"github.com/CustomCloudStorage/services"
+)
+
+type FsckHandler interface {
+	HandleCheck(w http.ResponseWriter, r *http.Request) error
+	HandleRepair(w http.ResponseWriter, r *http.Request) error
+}
+
+type fsckHandler struct {
+	fsckService services.FsckService
+}
+
+func NewFsckHandler(fsckService services.FsckService) FsckHandler {
+	return &fsckHandler{
+		fsckService: fsckService,
+	}
+}
+
+// HandleCheck reports inconsistencies without changing anything.
+func (h *fsckHandler) HandleCheck(w http.ResponseWriter, r *http.Request) error {
+	report, err := h.fsckService.Check(r.Context(), false)
+	if err != nil {
+		return err
+	}
+	return middleware.WriteJSONResponse(w, http.StatusOK, report)
+}
+
+func (h *fsckHandler) HandleRepair(w http.ResponseWriter, r *http.Request) error {
+	report, err := h.fsckService.Check(r.Context(), true)
+	if err != nil {
+		return err
+	}
+	return middleware.WriteJSONResponse(w, http.StatusOK, report)
+}
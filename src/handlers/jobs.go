@@ -0,0 +1,129 @@
+package handlers
+
+import (
+	"net/http"
+	"strconv"
+
+	"github.com/CustomCloudStorage/jobs"
+	"github.com/CustomCloudStorage/middleware"
+	"github.com/CustomCloudStorage/types"
+	"github.com/CustomCloudStorage/utils"
+	"github.com/gorilla/mux"
+)
+
+type JobHandler interface {
+	HandleListJobs(w http.ResponseWriter, r *http.Request) error
+	HandleGetJob(w http.ResponseWriter, r *http.Request) error
+	HandleJobStats(w http.ResponseWriter, r *http.Request) error
+	HandlePeriodicTasks(w http.ResponseWriter, r *http.Request) error
+	HandleRetryJob(w http.ResponseWriter, r *http.Request) error
+	HandleCancelJob(w http.ResponseWriter, r *http.Request) error
+}
+
+type jobHandler struct {
+	queue *jobs.Queue
+}
+
+func NewJobHandler(queue *jobs.Queue) JobHandler {
+	return &jobHandler{
+		queue: queue,
+	}
+}
+
+// HandleListJobs lists jobs, optionally of one status and kind. Dead jobs
+// (?status=dead) are the dead-letter queue.
+func (h *jobHandler) HandleListJobs(w http.ResponseWriter, r *http.Request) error {
+	ctx := r.Context()
+
+	status := r.URL.Query().Get("status")
+	switch status {
+	case "", types.JobQueued, types.JobRunning, types.JobSucceeded, types.JobDead, types.JobCancelled:
+	default:
+		return utils.ErrBadRequest.New("invalid status %q", status)
+	}
+	page, err := parsePageQuery(r, "id")
+	if err != nil {
+		return err
+	}
+
+	list, next, err := h.queue.List(ctx, status, r.URL.Query().Get("kind"), page)
+	if err != nil {
+		return err
+	}
+	return middleware.WriteJSONResponse(w, http.StatusOK, map[string]interface{}{
+		"jobs":        list,
+		"next_cursor": next,
+	})
+}
+
+func (h *jobHandler) HandleGetJob(w http.ResponseWriter, r *http.Request) error {
+	id, err := jobID(r)
+	if err != nil {
+		return err
+	}
+	job, err := h.queue.Get(r.Context(), id)
+	if err != nil {
+		return err
+	}
+	return middleware.WriteJSONResponse(w, http.StatusOK, map[string]interface{}{
+		"job": job,
+	})
+}
+
+// HandleJobStats counts jobs by kind and status.
+func (h *jobHandler) HandleJobStats(w http.ResponseWriter, r *http.Request) error {
+	counts, err := h.queue.Count(r.Context())
+	if err != nil {
+		return err
+	}
+	return middleware.WriteJSONResponse(w, http.StatusOK, map[string]interface{}{
+		"counts": counts,
+	})
+}
+
+// HandlePeriodicTasks reports the latest run of each periodic maintenance
+// task: when it ran, on which replica and how it went.
+func (h *jobHandler) HandlePeriodicTasks(w http.ResponseWriter, r *http.Request) error {
+	tasks, err := h.queue.Periodic(r.Context())
+	if err != nil {
+		return err
+	}
+	return middleware.WriteJSONResponse(w, http.StatusOK, map[string]interface{}{
+		"tasks": tasks,
+	})
+}
+
+func (h *jobHandler) HandleRetryJob(w http.ResponseWriter, r *http.Request) error {
+	id, err := jobID(r)
+	if err != nil {
+		return err
+	}
+	if err := h.queue.Retry(r.Context(), id); err != nil {
+		return err
+	}
+	return middleware.WriteJSONResponse(w, http.StatusOK, map[string]interface{}{
+		"message": "job queued for retry",
+	})
+}
+
+func (h *jobHandler) HandleCancelJob(w http.ResponseWriter, r *http.Request) error {
+	id, err := jobID(r)
+	if err != nil {
+		return err
+	}
+	if err := h.queue.Cancel(r.Context(), id); err != nil {
+		return err
+	}
+	return middleware.WriteJSONResponse(w, http.StatusOK, map[string]interface{}{
+		"message": "job cancelled",
+	})
+}
+
+func jobID(r *http.Request) (int, error) {
+	raw := mux.Vars(r)["jobID"]
+	id, err := strconv.Atoi(raw)
+	if err != nil {
+		return 0, utils.ErrBadRequest.Wrap(err, "invalid job ID %q", raw)
+	}
+	return id, nil
+}
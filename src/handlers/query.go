@@ -0,0 +1,127 @@
+package handlers
+
+import (
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/CustomCloudStorage/types"
+	"github.com/CustomCloudStorage/utils"
+)
+
+const (
+	defaultPageLimit = 50
+	maxPageLimit     = 200
+)
+
+func optionalIntQuery(r *http.Request, name string) (*int, error) {
+	raw := r.URL.Query().Get(name)
+	if raw == "" {
+		return nil, nil
+	}
+	v, err := strconv.Atoi(raw)
+	if err != nil {
+		return nil, utils.ErrBadRequest.Wrap(err, "invalid %s", name)
+	}
+	return &v, nil
+}
+
+func optionalInt64Query(r *http.Request, name string) (*int64, error) {
+	raw := r.URL.Query().Get(name)
+	if raw == "" {
+		return nil, nil
+	}
+	v, err := strconv.ParseInt(raw, 10, 64)
+	if err != nil {
+		return nil, utils.ErrBadRequest.Wrap(err, "invalid %s", name)
+	}
+	return &v, nil
+}
+
+func optionalTimeQuery(r *http.Request, name string) (*time.Time, error) {
+	raw := r.URL.Query().Get(name)
+	if raw == "" {
+		return nil, nil
+	}
+	v, err := time.Parse(time.RFC3339, raw)
+	if err != nil {
+		return nil, utils.ErrBadRequest.Wrap(err, "invalid %s, expected RFC3339", name)
+	}
+	return &v, nil
+}
+
+func boolQuery(r *http.Request, name string) (bool, error) {
+	raw := r.URL.Query().Get(name)
+	if raw == "" {
+		return false, nil
+	}
+	v, err := strconv.ParseBool(raw)
+	if err != nil {
+		return false, utils.ErrBadRequest.Wrap(err, "invalid %s", name)
+	}
+	return v, nil
+}
+
+// contentTypeQuery reads a content type filter: a media type such as
+// "image/png" or a top-level type such as "image/*".
+func contentTypeQuery(r *http.Request, name string) (string, error) {
+	raw := strings.TrimSpace(r.URL.Query().Get(name))
+	if raw == "" {
+		return "", nil
+	}
+	top, sub, ok := strings.Cut(raw, "/")
+	if !ok || top == "" || sub == "" || top == "*" || strings.ContainsAny(raw, " ;,") {
+		return "", utils.ErrBadRequest.New("invalid %s %q, expected type/subtype or type/*", name, raw)
+	}
+	return raw, nil
+}
+
+func intQuery(r *http.Request, name string, def int) (int, error) {
+	v, err := optionalIntQuery(r, name)
+	if err != nil {
+		return 0, err
+	}
+	if v == nil {
+		return def, nil
+	}
+	return *v, nil
+}
+
+func parsePageQuery(r *http.Request, defaultSort string) (*types.PageQuery, error) {
+	q := r.URL.Query()
+	page := &types.PageQuery{
+		Cursor: q.Get("cursor"),
+		Sort:   q.Get("sort"),
+		Order:  q.Get("order"),
+	}
+	if page.Sort == "" {
+		page.Sort = defaultSort
+	}
+	switch strings.ToLower(page.Order) {
+	case "", "asc", "desc":
+	default:
+		return nil, utils.ErrBadRequest.New("invalid order %q", page.Order)
+	}
+
+	limit, err := intQuery(r, "limit", defaultPageLimit)
+	if err != nil {
+		return nil, err
+	}
+	if limit < 1 || limit > maxPageLimit {
+		return nil, utils.ErrBadRequest.New("limit must be between 1 and %d", maxPageLimit)
+	}
+	page.Limit = limit
+	return page, nil
+}
+
+func parseConflictPolicy(raw string, def types.ConflictPolicy) (types.ConflictPolicy, error) {
+	switch policy := types.ConflictPolicy(strings.ToLower(raw)); policy {
+	case "":
+		return def, nil
+	case types.ConflictFail, types.ConflictRename, types.ConflictOverwrite:
+		return policy, nil
+	default:
+		return "", utils.ErrBadRequest.New("invalid on_conflict %q", raw)
+	}
+}
@@ -0,0 +1,188 @@
+package handlers
+
+import (
+	"encoding/json"
+	"io"
+	"mime"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/CustomCloudStorage/middleware"
+	"github.com/CustomCloudStorage/services"
+	"github.com/CustomCloudStorage/types"
+	"github.com/CustomCloudStorage/utils"
+	"github.com/dgrijalva/jwt-go"
+	"github.com/gorilla/mux"
+)
+
+const sharePasswordHeader = "X-Share-Password"
+
+type ShareHandler interface {
+	HandleCreateShare(w http.ResponseWriter, r *http.Request) error
+	HandleListShares(w http.ResponseWriter, r *http.Request) error
+	HandleRevokeShare(w http.ResponseWriter, r *http.Request) error
+	HandlePublicBrowse(w http.ResponseWriter, r *http.Request) error
+	HandlePublicDownload(w http.ResponseWriter, r *http.Request) error
+}
+
+type shareHandler struct {
+	shareService services.ShareService
+}
+
+func NewShareHandler(shareService services.ShareService) ShareHandler {
+	return &shareHandler{
+		shareService: shareService,
+	}
+}
+
+func (h *shareHandler) HandleCreateShare(w http.ResponseWriter, r *http.Request) error {
+	ctx := r.Context()
+	claims := ctx.Value("claims").(jwt.MapClaims)
+	userID, ok := claims["userID"].(float64)
+	if !ok {
+		return utils.ErrUnauthorized.New("invalid userID")
+	}
+
+	var req struct {
+		FileID       *int       `json:"file_id"`
+		FolderID     *int       `json:"folder_id"`
+		Password     string     `json:"password"`
+		ExpiresAt    *time.Time `json:"expires_at"`
+		MaxDownloads *int       `json:"max_downloads"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		return utils.ErrBadRequest.Wrap(err, "decode share payload")
+	}
+
+	link := &types.ShareLink{
+		FileID:       req.FileID,
+		FolderID:     req.FolderID,
+		ExpiresAt:    req.ExpiresAt,
+		MaxDownloads: req.MaxDownloads,
+	}
+	link, url, err := h.shareService.CreateShare(ctx, int(userID), link, req.Password)
+	if err != nil {
+		return err
+	}
+
+	return middleware.WriteJSONResponse(w, http.StatusCreated, map[string]interface{}{
+		"share": link,
+		"url":   url,
+	})
+}
+
+func (h *shareHandler) HandleListShares(w http.ResponseWriter, r *http.Request) error {
+	ctx := r.Context()
+	claims := ctx.Value("claims").(jwt.MapClaims)
+	userID, ok := claims["userID"].(float64)
+	if !ok {
+		return utils.ErrUnauthorized.New("invalid userID")
+	}
+
+	links, err := h.shareService.ListShares(ctx, int(userID))
+	if err != nil {
+		return err
+	}
+
+	return middleware.WriteJSONResponse(w, http.StatusOK, map[string]interface{}{
+		"shares": links,
+	})
+}
+
+func (h *shareHandler) HandleRevokeShare(w http.ResponseWriter, r *http.Request) error {
+	ctx := r.Context()
+	claims := ctx.Value("claims").(jwt.MapClaims)
+	userID, ok := claims["userID"].(float64)
+	if !ok {
+		return utils.ErrUnauthorized.New("invalid userID")
+	}
+
+	shareID, err := strconv.Atoi(mux.Vars(r)["shareID"])
+	if err != nil {
+		return utils.ErrBadRequest.Wrap(err, "invalid share ID")
+	}
+
+	if err := h.shareService.RevokeShare(ctx, int(userID), shareID); err != nil {
+		return err
+	}
+
+	return middleware.WriteJSONResponse(w, http.StatusOK, map[string]interface{}{
+		"message": "share link revoked",
+	})
+}
+
+func (h *shareHandler) HandlePublicBrowse(w http.ResponseWriter, r *http.Request) error {
+	ctx := r.Context()
+
+	share, err := h.shareService.OpenShare(ctx, mux.Vars(r)["token"], sharePassword(r))
+	if err != nil {
+		return err
+	}
+	folderID, err := optionalIntQuery(r, "folder_id")
+	if err != nil {
+		return err
+	}
+
+	contents, err := h.shareService.BrowseShare(ctx, share, folderID)
+	if err != nil {
+		return err
+	}
+
+	return middleware.WriteJSONResponse(w, http.StatusOK, contents)
+}
+
+func (h *shareHandler) HandlePublicDownload(w http.ResponseWriter, r *http.Request) error {
+	ctx := r.Context()
+
+	share, err := h.shareService.OpenShare(ctx, mux.Vars(r)["token"], sharePassword(r))
+	if err != nil {
+		return err
+	}
+	fileID, err := optionalIntQuery(r, "file_id")
+	if err != nil {
+		return err
+	}
+	folderID, err := optionalIntQuery(r, "folder_id")
+	if err != nil {
+		return err
+	}
+
+	if share.FileID != nil || fileID != nil {
+		dfile, err := h.shareService.DownloadSharedFile(ctx, share, fileID, func(dfile *types.DownloadedFile) bool {
+			return startsDownload(r, dfile)
+		})
+		if err != nil {
+			return err
+		}
+		defer dfile.Reader.(io.Closer).Close()
+
+		serveFile(w, r, dfile, "attachment")
+		return nil
+	}
+
+	head := r.Method == http.MethodHead
+	reader, archiveName, err := h.shareService.DownloadSharedFolder(ctx, share, folderID, head)
+	if err != nil {
+		return err
+	}
+
+	w.Header().Set("Content-Type", "application/zip")
+	w.Header().Set("Content-Disposition", mime.FormatMediaType("attachment", map[string]string{"filename": archiveName}))
+	// The archive is built while it streams, so it cannot be served in ranges.
+	w.Header().Set("Accept-Ranges", "none")
+	if head {
+		w.WriteHeader(http.StatusOK)
+		return nil
+	}
+	defer reader.Close()
+
+	if _, err := io.Copy(w, reader); err != nil {
+		return utils.ErrInternal.Wrap(err, "stream zip archive")
+	}
+	return nil
+}
+
+func sharePassword(r *http.Request) string {
+	return r.Header.Get(sharePasswordHeader)
+}
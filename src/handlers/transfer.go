@@ -0,0 +1,160 @@
+package handlers
+
+import (
+	"encoding/json"
+	"net/http"
+	"strconv"
+
+	"github.com/CustomCloudStorage/middleware"
+	"github.com/CustomCloudStorage/services"
+	"github.com/CustomCloudStorage/types"
+	"github.com/CustomCloudStorage/utils"
+	"github.com/dgrijalva/jwt-go"
+	"github.com/gorilla/mux"
+)
+
+type TransferHandler interface {
+	HandleMoveFile(w http.ResponseWriter, r *http.Request) error
+	HandleCopyFile(w http.ResponseWriter, r *http.Request) error
+	HandleMoveFolder(w http.ResponseWriter, r *http.Request) error
+	HandleCopyFolder(w http.ResponseWriter, r *http.Request) error
+}
+
+type transferHandler struct {
+	transferService services.TransferService
+}
+
+func NewTransferHandler(transferService services.TransferService) TransferHandler {
+	return &transferHandler{
+		transferService: transferService,
+	}
+}
+
+type transferRequest struct {
+	FolderID   *int   `json:"folder_id"`
+	OnConflict string `json:"on_conflict"`
+}
+
+func decodeTransferRequest(r *http.Request) (*int, types.ConflictPolicy, error) {
+	var req transferRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		return nil, "", utils.ErrBadRequest.Wrap(err, "decode transfer payload")
+	}
+	policy, err := parseConflictPolicy(req.OnConflict, types.ConflictFail)
+	if err != nil {
+		return nil, "", err
+	}
+	return req.FolderID, policy, nil
+}
+
+func (h *transferHandler) HandleMoveFile(w http.ResponseWriter, r *http.Request) error {
+	ctx := r.Context()
+	claims := ctx.Value("claims").(jwt.MapClaims)
+	userID, ok := claims["userID"].(float64)
+	if !ok {
+		return utils.ErrUnauthorized.New("invalid userID")
+	}
+
+	fileID, err := strconv.Atoi(mux.Vars(r)["fileID"])
+	if err != nil {
+		return utils.ErrBadRequest.Wrap(err, "invalid file ID")
+	}
+	folderID, policy, err := decodeTransferRequest(r)
+	if err != nil {
+		return err
+	}
+
+	file, err := h.transferService.MoveFile(ctx, int(userID), fileID, folderID, policy)
+	if err != nil {
+		return err
+	}
+
+	return middleware.WriteJSONResponse(w, http.StatusOK, map[string]interface{}{
+		"file":    file,
+		"message": "file moved successfully",
+	})
+}
+
+func (h *transferHandler) HandleCopyFile(w http.ResponseWriter, r *http.Request) error {
+	ctx := r.Context()
+	claims := ctx.Value("claims").(jwt.MapClaims)
+	userID, ok := claims["userID"].(float64)
+	if !ok {
+		return utils.ErrUnauthorized.New("invalid userID")
+	}
+
+	fileID, err := strconv.Atoi(mux.Vars(r)["fileID"])
+	if err != nil {
+		return utils.ErrBadRequest.Wrap(err, "invalid file ID")
+	}
+	folderID, policy, err := decodeTransferRequest(r)
+	if err != nil {
+		return err
+	}
+
+	file, err := h.transferService.CopyFile(ctx, int(userID), fileID, folderID, policy)
+	if err != nil {
+		return err
+	}
+
+	return middleware.WriteJSONResponse(w, http.StatusCreated, map[string]interface{}{
+		"file":    file,
+		"message": "file copied successfully",
+	})
+}
+
+func (h *transferHandler) HandleMoveFolder(w http.ResponseWriter, r *http.Request) error {
+	ctx := r.Context()
+	claims := ctx.Value("claims").(jwt.MapClaims)
+	userID, ok := claims["userID"].(float64)
+	if !ok {
+		return utils.ErrUnauthorized.New("invalid userID")
+	}
+
+	folderID, err := strconv.Atoi(mux.Vars(r)["folderID"])
+	if err != nil {
+		return utils.ErrBadRequest.Wrap(err, "invalid folder ID")
+	}
+	parentID, policy, err := decodeTransferRequest(r)
+	if err != nil {
+		return err
+	}
+
+	folder, err := h.transferService.MoveFolder(ctx, int(userID), folderID, parentID, policy)
+	if err != nil {
+		return err
+	}
+
+	return middleware.WriteJSONResponse(w, http.StatusOK, map[string]interface{}{
+		"folder":  folder,
+		"message": "folder moved successfully",
+	})
+}
+
+func (h *transferHandler) HandleCopyFolder(w http.ResponseWriter, r *http.Request) error {
+	ctx := r.Context()
+	claims := ctx.Value("claims").(jwt.MapClaims)
+	userID, ok := claims["userID"].(float64)
+	if !ok {
+		return utils.ErrUnauthorized.New("invalid userID")
+	}
+
+	folderID, err := strconv.Atoi(mux.Vars(r)["folderID"])
+	if err != nil {
+		return utils.ErrBadRequest.Wrap(err, "invalid folder ID")
+	}
+	parentID, policy, err := decodeTransferRequest(r)
+	if err != nil {
+		return err
+	}
+
+	folder, err := h.transferService.CopyFolder(ctx, int(userID), folderID, parentID, policy)
+	if err != nil {
+		return err
+	}
+
+	return middleware.WriteJSONResponse(w, http.StatusCreated, map[string]interface{}{
+		"folder":  folder,
+		"message": "folder copied successfully",
+	})
+}
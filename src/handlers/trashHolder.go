@@ -46,13 +46,19 @@ func (h *trashHandler) ListFilesHandler(w http.ResponseWriter, r *http.Request)
 		return utils.ErrUnauthorized.New("invalid userID")
 	}
 
-	files, err := h.trashRepository.ListTrashedFiles(ctx, int(userID))
+	page, err := parsePageQuery(r, "deleted_at")
+	if err != nil {
+		return err
+	}
+
+	files, next, err := h.trashRepository.ListTrashedFiles(ctx, int(userID), page)
 	if err != nil {
 		return err
 	}
 
 	return middleware.WriteJSONResponse(w, http.StatusOK, map[string]interface{}{
-		"files": files,
+		"files":       files,
+		"next_cursor": next,
 	})
 }
 
@@ -134,13 +140,19 @@ func (h *trashHandler) ListFoldersHandler(w http.ResponseWriter, r *http.Request
 		return utils.ErrUnauthorized.New("invalid userID")
 	}
 
-	folders, err := h.trashRepository.ListTrashedFolders(ctx, int(userID))
+	page, err := parsePageQuery(r, "deleted_at")
+	if err != nil {
+		return err
+	}
+
+	folders, next, err := h.trashRepository.ListTrashedFolders(ctx, int(userID), page)
 	if err != nil {
 		return err
 	}
 
 	return middleware.WriteJSONResponse(w, http.StatusOK, map[string]interface{}{
-		"folders": folders,
+		"folders":     folders,
+		"next_cursor": next,
 	})
 }
 
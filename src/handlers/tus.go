@@ -0,0 +1,284 @@
+package handlers
+
+import (
+	"encoding/base64"
+	"net/http"
+	"path"
+	"path/filepath"
+	"strconv"
+	"strings"
+
+	"github.com/CustomCloudStorage/middleware"
+	"github.com/CustomCloudStorage/services"
+	"github.com/CustomCloudStorage/types"
+	"github.com/CustomCloudStorage/utils"
+	"github.com/dgrijalva/jwt-go"
+	"github.com/google/uuid"
+	"github.com/gorilla/mux"
+	"github.com/joomcode/errorx"
+)
+
+const (
+	tusVersion    = "1.0.0"
+	tusExtensions = "creation,termination,checksum"
+	tusOctets     = "application/offset+octet-stream"
+
+	// statusChecksumMismatch is defined by the tus checksum extension.
+	statusChecksumMismatch = 460
+)
+
+// TusHandler implements the tus 1.0 resumable upload protocol on top of the
+// upload service.
+type TusHandler interface {
+	HandleOptions(w http.ResponseWriter, r *http.Request) error
+	HandleCreate(w http.ResponseWriter, r *http.Request) error
+	HandleHead(w http.ResponseWriter, r *http.Request) error
+	HandlePatch(w http.ResponseWriter, r *http.Request) error
+	HandleDelete(w http.ResponseWriter, r *http.Request) error
+}
+
+type tusHandler struct {
+	uploadService services.UploadService
+}
+
+func NewTusHandler(uploadService services.UploadService) TusHandler {
+	return &tusHandler{
+		uploadService: uploadService,
+	}
+}
+
+func (h *tusHandler) HandleOptions(w http.ResponseWriter, r *http.Request) error {
+	w.Header().Set("Tus-Resumable", tusVersion)
+	w.Header().Set("Tus-Version", tusVersion)
+	w.Header().Set("Tus-Extension", tusExtensions)
+	w.Header().Set("Tus-Checksum-Algorithm", strings.Join(services.ChecksumAlgorithms, ","))
+	w.WriteHeader(http.StatusNoContent)
+	return nil
+}
+
+func (h *tusHandler) HandleCreate(w http.ResponseWriter, r *http.Request) error {
+	ctx := r.Context()
+	if !checkTusVersion(w, r) {
+		return nil
+	}
+
+	claims := ctx.Value("claims").(jwt.MapClaims)
+	userID, ok := claims["userID"].(float64)
+	if !ok {
+		return utils.ErrUnauthorized.New("invalid userID")
+	}
+
+	length, err := strconv.ParseInt(r.Header.Get("Upload-Length"), 10, 64)
+	if err != nil || length < 0 {
+		return utils.ErrBadRequest.New("invalid Upload-Length header")
+	}
+	meta, err := parseTusMetadata(r.Header.Get("Upload-Metadata"))
+	if err != nil {
+		return err
+	}
+
+	fullName := meta["filename"]
+	if fullName == "" {
+		fullName = meta["name"]
+	}
+	fullName = filepath.Base(fullName)
+	if fullName == "" || fullName == "." || fullName == string(filepath.Separator) {
+		return utils.ErrBadRequest.New("Upload-Metadata must contain a filename")
+	}
+	var folderID *int
+	if raw := meta["folder_id"]; raw != "" {
+		id, err := strconv.Atoi(raw)
+		if err != nil {
+			return utils.ErrBadRequest.Wrap(err, "invalid folder_id metadata")
+		}
+		folderID = &id
+	}
+
+	extension := filepath.Ext(fullName)
+	session := types.UploadSession{
+		FolderID:       folderID,
+		Name:           strings.TrimSuffix(fullName, extension),
+		Extension:      extension,
+		TotalSize:      length,
+		Protocol:       types.UploadProtocolTus,
+		ExpectedSHA256: meta["sha256"],
+	}
+	if err := h.uploadService.InitSession(ctx, int(userID), &session); err != nil {
+		return err
+	}
+	if session.TotalSize == 0 {
+		if err := h.uploadService.Complete(ctx, int(userID), session.ID, types.ConflictOverwrite); err != nil {
+			return err
+		}
+	}
+
+	w.Header().Set("Location", path.Join(r.URL.Path, session.ID.String()))
+	w.WriteHeader(http.StatusCreated)
+	return nil
+}
+
+func (h *tusHandler) HandleHead(w http.ResponseWriter, r *http.Request) error {
+	ctx := r.Context()
+	if !checkTusVersion(w, r) {
+		return nil
+	}
+
+	claims := ctx.Value("claims").(jwt.MapClaims)
+	userID, ok := claims["userID"].(float64)
+	if !ok {
+		return utils.ErrUnauthorized.New("invalid userID")
+	}
+
+	session, err := h.tusSession(r, int(userID))
+	if err != nil {
+		return err
+	}
+	progress, err := h.uploadService.GetProgress(ctx, int(userID), session.ID)
+	if err != nil {
+		return err
+	}
+
+	w.Header().Set("Upload-Offset", strconv.FormatInt(progress.Uploaded, 10))
+	w.Header().Set("Upload-Length", strconv.FormatInt(session.TotalSize, 10))
+	w.Header().Set("Cache-Control", "no-store")
+	w.WriteHeader(http.StatusOK)
+	return nil
+}
+
+func (h *tusHandler) HandlePatch(w http.ResponseWriter, r *http.Request) error {
+	ctx := r.Context()
+	if !checkTusVersion(w, r) {
+		return nil
+	}
+
+	claims := ctx.Value("claims").(jwt.MapClaims)
+	userID, ok := claims["userID"].(float64)
+	if !ok {
+		return utils.ErrUnauthorized.New("invalid userID")
+	}
+	if r.Header.Get("Content-Type") != tusOctets {
+		w.WriteHeader(http.StatusUnsupportedMediaType)
+		return nil
+	}
+
+	session, err := h.tusSession(r, int(userID))
+	if err != nil {
+		return err
+	}
+	offset, err := strconv.ParseInt(r.Header.Get("Upload-Offset"), 10, 64)
+	if err != nil || offset < 0 {
+		return utils.ErrBadRequest.New("invalid Upload-Offset header")
+	}
+	checksums, err := parseTusChecksum(r.Header.Get("Upload-Checksum"))
+	if err != nil {
+		return err
+	}
+
+	offset, err = h.uploadService.AppendPart(ctx, int(userID), session.ID, offset, r.Body, checksums)
+	if errorx.IsOfType(err, utils.ErrChecksumMismatch) {
+		return middleware.WriteJSONResponse(w, statusChecksumMismatch, map[string]interface{}{
+			"error": "Checksum mismatch",
+		})
+	}
+	if err != nil {
+		return err
+	}
+	if offset == session.TotalSize {
+		if err := h.uploadService.Complete(ctx, int(userID), session.ID, types.ConflictOverwrite); err != nil {
+			return err
+		}
+	}
+
+	w.Header().Set("Upload-Offset", strconv.FormatInt(offset, 10))
+	w.WriteHeader(http.StatusNoContent)
+	return nil
+}
+
+func (h *tusHandler) HandleDelete(w http.ResponseWriter, r *http.Request) error {
+	ctx := r.Context()
+	if !checkTusVersion(w, r) {
+		return nil
+	}
+
+	claims := ctx.Value("claims").(jwt.MapClaims)
+	userID, ok := claims["userID"].(float64)
+	if !ok {
+		return utils.ErrUnauthorized.New("invalid userID")
+	}
+
+	session, err := h.tusSession(r, int(userID))
+	if err != nil {
+		return err
+	}
+	if err := h.uploadService.Abort(ctx, int(userID), session.ID); err != nil {
+		return err
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+	return nil
+}
+
+func (h *tusHandler) tusSession(r *http.Request, userID int) (*types.UploadSession, error) {
+	sessionID, err := uuid.Parse(mux.Vars(r)["sessionID"])
+	if err != nil {
+		return nil, utils.ErrBadRequest.Wrap(err, "invalid session ID")
+	}
+	session, err := h.uploadService.GetSession(r.Context(), userID, sessionID)
+	if err != nil {
+		return nil, err
+	}
+	if session.Protocol != types.UploadProtocolTus {
+		return nil, utils.ErrNotFound.New("tus upload %s not found", sessionID)
+	}
+	return session, nil
+}
+
+func checkTusVersion(w http.ResponseWriter, r *http.Request) bool {
+	w.Header().Set("Tus-Resumable", tusVersion)
+	if r.Header.Get("Tus-Resumable") != tusVersion {
+		w.Header().Set("Tus-Version", tusVersion)
+		w.WriteHeader(http.StatusPreconditionFailed)
+		return false
+	}
+	return true
+}
+
+func parseTusMetadata(raw string) (map[string]string, error) {
+	meta := make(map[string]string)
+	for _, pair := range strings.Split(raw, ",") {
+		fields := strings.Fields(pair)
+		switch len(fields) {
+		case 0:
+			continue
+		case 1:
+			meta[fields[0]] = ""
+		case 2:
+			value, err := base64.StdEncoding.DecodeString(fields[1])
+			if err != nil {
+				return nil, utils.ErrBadRequest.Wrap(err, "invalid Upload-Metadata value for %q", fields[0])
+			}
+			meta[fields[0]] = string(value)
+		default:
+			return nil, utils.ErrBadRequest.New("invalid Upload-Metadata pair %q", pair)
+		}
+	}
+	return meta, nil
+}
+
+func parseTusChecksum(raw string) ([]types.Checksum, error) {
+	if raw == "" {
+		return nil, nil
+	}
+	fields := strings.Fields(raw)
+	if len(fields) != 2 {
+		return nil, utils.ErrBadRequest.New("invalid Upload-Checksum header")
+	}
+	sum, err := base64.StdEncoding.DecodeString(fields[1])
+	if err != nil {
+		return nil, utils.ErrBadRequest.Wrap(err, "invalid Upload-Checksum digest")
+	}
+	return []types.Checksum{{
+		Algorithm: fields[0],
+		Sum:       sum,
+	}}, nil
+}
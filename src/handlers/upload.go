@@ -1,15 +1,25 @@
 package handlers
 
 import (
+	"crypto/md5"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
 	"encoding/json"
-	"fmt"
+	"io"
+	"mime"
+	"mime/multipart"
 	"net/http"
+	"path/filepath"
 	"strconv"
+	"strings"
+	"time"
 
 	"github.com/CustomCloudStorage/middleware"
 	"github.com/CustomCloudStorage/services"
 	"github.com/CustomCloudStorage/types"
 	"github.com/CustomCloudStorage/utils"
+	"github.com/dgrijalva/jwt-go"
 	"github.com/google/uuid"
 	"github.com/gorilla/mux"
 )
@@ -20,8 +30,14 @@ type UploadHandler interface {
 	ProgressHandler(w http.ResponseWriter, r *http.Request) error
 	CompleteHandler(w http.ResponseWriter, r *http.Request) error
 	AbortHandler(w http.ResponseWriter, r *http.Request) error
+	HandleUploadFile(w http.ResponseWriter, r *http.Request) error
+	ListSessionsHandler(w http.ResponseWriter, r *http.Request) error
+	ListStaleSessionsHandler(w http.ResponseWriter, r *http.Request) error
+	AdminAbortHandler(w http.ResponseWriter, r *http.Request) error
 }
 
+const defaultStaleUploadAge = 24 * time.Hour
+
 type uploadHandler struct {
 	uploadService services.UploadService
 }
@@ -35,13 +51,18 @@ func NewUploadHandler(uploadService services.UploadService) UploadHandler {
 func (h *uploadHandler) InitSessionHandler(w http.ResponseWriter, r *http.Request) error {
 	ctx := r.Context()
 
-	fmt.Println("InitSessionHandler")
+	claims := ctx.Value("claims").(jwt.MapClaims)
+	userID, ok := claims["userID"].(float64)
+	if !ok {
+		return utils.ErrUnauthorized.New("invalid userID")
+	}
+
 	var session types.UploadSession
 	if err := json.NewDecoder(r.Body).Decode(&session); err != nil {
 		return utils.ErrBadRequest.Wrap(err, "decode upload session payload")
 	}
 
-	if err := h.uploadService.InitSession(ctx, &session); err != nil {
+	if err := h.uploadService.InitSession(ctx, int(userID), &session); err != nil {
 		return err
 	}
 
@@ -55,6 +76,12 @@ func (h *uploadHandler) InitSessionHandler(w http.ResponseWriter, r *http.Reques
 func (h *uploadHandler) UploadPartHandler(w http.ResponseWriter, r *http.Request) error {
 	ctx := r.Context()
 
+	claims := ctx.Value("claims").(jwt.MapClaims)
+	userID, ok := claims["userID"].(float64)
+	if !ok {
+		return utils.ErrUnauthorized.New("invalid userID")
+	}
+
 	params := mux.Vars(r)
 	sessionID, err := uuid.Parse(params["sessionID"])
 	if err != nil {
@@ -65,7 +92,12 @@ func (h *uploadHandler) UploadPartHandler(w http.ResponseWriter, r *http.Request
 		return utils.ErrBadRequest.Wrap(err, "invalid part number")
 	}
 
-	if err := h.uploadService.UploadPart(ctx, sessionID, partNum, r.Body); err != nil {
+	checksums, err := parseContentChecksums(r)
+	if err != nil {
+		return err
+	}
+
+	if err := h.uploadService.UploadPart(ctx, int(userID), sessionID, partNum, r.Body, checksums); err != nil {
 		return err
 	}
 
@@ -79,49 +111,68 @@ func (h *uploadHandler) UploadPartHandler(w http.ResponseWriter, r *http.Request
 func (h *uploadHandler) ProgressHandler(w http.ResponseWriter, r *http.Request) error {
 	ctx := r.Context()
 
+	claims := ctx.Value("claims").(jwt.MapClaims)
+	userID, ok := claims["userID"].(float64)
+	if !ok {
+		return utils.ErrUnauthorized.New("invalid userID")
+	}
+
 	sessionID, err := uuid.Parse(mux.Vars(r)["sessionID"])
 	if err != nil {
 		return utils.ErrBadRequest.Wrap(err, "invalid session ID")
 	}
 
-	uploaded, total, err := h.uploadService.GetProgress(ctx, sessionID)
+	progress, err := h.uploadService.GetProgress(ctx, int(userID), sessionID)
 	if err != nil {
 		return err
 	}
 
-	fmt.Println("total: ", uploaded, ", total: ", total)
-
-	middleware.WriteJSONResponse(w, http.StatusOK, map[string]interface{}{
-		"uploaded": uploaded,
-		"total":    total,
-	})
-	return nil
+	return middleware.WriteJSONResponse(w, http.StatusOK, progress)
 }
 
 func (h *uploadHandler) CompleteHandler(w http.ResponseWriter, r *http.Request) error {
 	ctx := r.Context()
 
+	claims := ctx.Value("claims").(jwt.MapClaims)
+	userID, ok := claims["userID"].(float64)
+	if !ok {
+		return utils.ErrUnauthorized.New("invalid userID")
+	}
+
 	sessionID, err := uuid.Parse(mux.Vars(r)["sessionID"])
 	if err != nil {
 		return utils.ErrBadRequest.Wrap(err, "invalid session ID")
 	}
-	fileMeta, err := h.uploadService.Complete(ctx, sessionID)
+	policy, err := parseConflictPolicy(r.URL.Query().Get("on_conflict"), types.ConflictOverwrite)
 	if err != nil {
 		return err
 	}
+	if err := h.uploadService.Complete(ctx, int(userID), sessionID, policy); err != nil {
+		return err
+	}
 
-	return middleware.WriteJSONResponse(w, http.StatusOK, fileMeta)
+	return middleware.WriteJSONResponse(w, http.StatusAccepted, map[string]interface{}{
+		"session_id": sessionID.String(),
+		"status":     types.UploadSessionFinalizing,
+		"message":    "upload is being finalized, poll its progress for the file",
+	})
 }
 
 func (h *uploadHandler) AbortHandler(w http.ResponseWriter, r *http.Request) error {
 	ctx := r.Context()
 
+	claims := ctx.Value("claims").(jwt.MapClaims)
+	userID, ok := claims["userID"].(float64)
+	if !ok {
+		return utils.ErrUnauthorized.New("invalid userID")
+	}
+
 	sessionID, err := uuid.Parse(mux.Vars(r)["sessionID"])
 	if err != nil {
 		return utils.ErrBadRequest.Wrap(err, "invalid session ID")
 	}
 
-	if err := h.uploadService.Abort(ctx, sessionID); err != nil {
+	if err := h.uploadService.Abort(ctx, int(userID), sessionID); err != nil {
 		return err
 	}
 
@@ -129,3 +180,162 @@ func (h *uploadHandler) AbortHandler(w http.ResponseWriter, r *http.Request) err
 		"message": "upload session aborted",
 	})
 }
+
+func (h *uploadHandler) ListSessionsHandler(w http.ResponseWriter, r *http.Request) error {
+	ctx := r.Context()
+
+	claims := ctx.Value("claims").(jwt.MapClaims)
+	userID, ok := claims["userID"].(float64)
+	if !ok {
+		return utils.ErrUnauthorized.New("invalid userID")
+	}
+
+	uploads, err := h.uploadService.ListSessions(ctx, int(userID))
+	if err != nil {
+		return err
+	}
+
+	return middleware.WriteJSONResponse(w, http.StatusOK, map[string]interface{}{
+		"uploads": uploads,
+	})
+}
+
+// ListStaleSessionsHandler lists every user's sessions older than the
+// "older_than" duration (default 24h).
+func (h *uploadHandler) ListStaleSessionsHandler(w http.ResponseWriter, r *http.Request) error {
+	ctx := r.Context()
+
+	olderThan := defaultStaleUploadAge
+	if raw := r.URL.Query().Get("older_than"); raw != "" {
+		d, err := time.ParseDuration(raw)
+		if err != nil || d < 0 {
+			return utils.ErrBadRequest.New("invalid older_than %q", raw)
+		}
+		olderThan = d
+	}
+
+	uploads, err := h.uploadService.ListStaleSessions(ctx, olderThan)
+	if err != nil {
+		return err
+	}
+
+	return middleware.WriteJSONResponse(w, http.StatusOK, map[string]interface{}{
+		"uploads": uploads,
+	})
+}
+
+func (h *uploadHandler) AdminAbortHandler(w http.ResponseWriter, r *http.Request) error {
+	ctx := r.Context()
+
+	sessionID, err := uuid.Parse(mux.Vars(r)["sessionID"])
+	if err != nil {
+		return utils.ErrBadRequest.Wrap(err, "invalid session ID")
+	}
+
+	if err := h.uploadService.AbortAny(ctx, sessionID); err != nil {
+		return err
+	}
+
+	return middleware.WriteJSONResponse(w, http.StatusOK, map[string]interface{}{
+		"message": "upload session aborted",
+	})
+}
+
+// HandleUploadFile accepts a whole file in one request, either as the "file"
+// field of a multipart/form-data body or as the raw request body.
+func (h *uploadHandler) HandleUploadFile(w http.ResponseWriter, r *http.Request) error {
+	ctx := r.Context()
+
+	claims := ctx.Value("claims").(jwt.MapClaims)
+	userID, ok := claims["userID"].(float64)
+	if !ok {
+		return utils.ErrUnauthorized.New("invalid userID")
+	}
+
+	query := r.URL.Query()
+	folderID, err := optionalIntQuery(r, "folder_id")
+	if err != nil {
+		return err
+	}
+	policy, err := parseConflictPolicy(query.Get("on_conflict"), types.ConflictOverwrite)
+	if err != nil {
+		return err
+	}
+
+	checksums, err := parseContentChecksums(r)
+	if err != nil {
+		return err
+	}
+
+	fullName := query.Get("name")
+	var body io.Reader = r.Body
+	size := r.ContentLength
+
+	mediaType, _, _ := mime.ParseMediaType(r.Header.Get("Content-Type"))
+	if mediaType == "multipart/form-data" {
+		part, err := multipartFile(r)
+		if err != nil {
+			return err
+		}
+		defer part.Close()
+		if fullName == "" {
+			fullName = filepath.Base(part.FileName())
+		}
+		body = part
+		size = -1
+	}
+	if fullName == "" || fullName == "." || fullName == string(filepath.Separator) {
+		return utils.ErrBadRequest.New("file name is required")
+	}
+
+	extension := filepath.Ext(fullName)
+	name := strings.TrimSuffix(fullName, extension)
+
+	fileMeta, err := h.uploadService.UploadFile(ctx, int(userID), folderID, name, extension, size, body, checksums, policy)
+	if err != nil {
+		return err
+	}
+
+	return middleware.WriteJSONResponse(w, http.StatusCreated, map[string]interface{}{
+		"file": fileMeta,
+	})
+}
+
+func parseContentChecksums(r *http.Request) ([]types.Checksum, error) {
+	var checksums []types.Checksum
+	if raw := r.Header.Get("Content-MD5"); raw != "" {
+		sum, err := base64.StdEncoding.DecodeString(raw)
+		if err != nil || len(sum) != md5.Size {
+			return nil, utils.ErrBadRequest.New("invalid Content-MD5 header")
+		}
+		checksums = append(checksums, types.Checksum{Algorithm: "md5", Sum: sum})
+	}
+	if raw := r.Header.Get("X-Content-SHA256"); raw != "" {
+		sum, err := hex.DecodeString(raw)
+		if err != nil || len(sum) != sha256.Size {
+			return nil, utils.ErrBadRequest.New("invalid X-Content-SHA256 header")
+		}
+		checksums = append(checksums, types.Checksum{Algorithm: "sha256", Sum: sum})
+	}
+	return checksums, nil
+}
+
+func multipartFile(r *http.Request) (*multipart.Part, error) {
+	reader, err := r.MultipartReader()
+	if err != nil {
+		return nil, utils.ErrBadRequest.Wrap(err, "read multipart body")
+	}
+	for {
+		part, err := reader.NextPart()
+		if err == io.EOF {
+			return nil, utils.ErrBadRequest.New("multipart body has no \"file\" field")
+		}
+		if err != nil {
+			return nil, utils.ErrBadRequest.Wrap(err, "read multipart body")
+		}
+		if part.FormName() == "file" {
+			return part, nil
+		}
+		part.Close()
+	}
+}
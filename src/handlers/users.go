@@ -22,6 +22,7 @@ type UserHandler interface {
 	HandleUpdateCredentials(w http.ResponseWriter, r *http.Request) error
 	HandleDeleteUser(w http.ResponseWriter, r *http.Request) error
 	HandleStorageStats(w http.ResponseWriter, r *http.Request) error
+	HandleReconcileStorage(w http.ResponseWriter, r *http.Request) error
 }
 
 type userHandler struct {
@@ -63,14 +64,20 @@ func (h *userHandler) HandleGetUser(w http.ResponseWriter, r *http.Request) erro
 func (h *userHandler) HandleListUsers(w http.ResponseWriter, r *http.Request) error {
 	ctx := r.Context()
 
-	users, err := h.userRepository.List(ctx)
+	page, err := parsePageQuery(r, "id")
+	if err != nil {
+		return err
+	}
+
+	users, next, err := h.userRepository.List(ctx, page)
 	if err != nil {
 		return err
 	}
 
 	publicUsers := types.NewPublicUsers(users)
 	return middleware.WriteJSONResponse(w, http.StatusOK, map[string]interface{}{
-		"users": publicUsers,
+		"users":       publicUsers,
+		"next_cursor": next,
 	})
 }
 
@@ -182,14 +189,21 @@ func (h *userHandler) HandleDeleteUser(w http.ResponseWriter, r *http.Request) e
 		return utils.ErrBadRequest.Wrap(err, "invalid user ID")
 	}
 
-	files, err := h.fileRepository.ListByUserID(ctx, id)
-	if err != nil {
-		return err
-	}
-	for _, f := range files {
-		if err := h.fileService.DeleteFile(ctx, f.ID, id); err != nil {
+	page := &types.PageQuery{Limit: maxPageLimit, Sort: "id"}
+	for {
+		files, next, err := h.fileRepository.ListByUserID(ctx, id, "", page)
+		if err != nil {
 			return err
 		}
+		for _, f := range files {
+			if err := h.fileService.DeleteFile(ctx, f.ID, id); err != nil {
+				return err
+			}
+		}
+		if next == "" {
+			break
+		}
+		page.Cursor = next
 	}
 
 	if err := h.userRepository.Delete(ctx, id); err != nil {
@@ -219,3 +233,19 @@ func (h *userHandler) HandleStorageStats(w http.ResponseWriter, r *http.Request)
 	}
 	return middleware.WriteJSONResponse(w, http.StatusOK, out)
 }
+
+// HandleReconcileStorage recomputes every account's used storage right away
+// instead of waiting for the periodic job.
+func (h *userHandler) HandleReconcileStorage(w http.ResponseWriter, r *http.Request) error {
+	corrected, err := h.userService.ReconcileStorage(r.Context())
+	if err != nil {
+		return err
+	}
+	if corrected == nil {
+		corrected = []types.StorageUsage{}
+	}
+
+	return middleware.WriteJSONResponse(w, http.StatusOK, map[string]interface{}{
+		"corrected": corrected,
+	})
+}
@@ -0,0 +1,109 @@
+package handlers
+
+import (
+	"io"
+	"net/http"
+	"strconv"
+
+	"github.com/CustomCloudStorage/middleware"
+	"github.com/CustomCloudStorage/services"
+	"github.com/CustomCloudStorage/utils"
+	"github.com/dgrijalva/jwt-go"
+	"github.com/gorilla/mux"
+)
+
+type VersionHandler interface {
+	HandleListVersions(w http.ResponseWriter, r *http.Request) error
+	HandleDownloadVersion(w http.ResponseWriter, r *http.Request) error
+	HandleRestoreVersion(w http.ResponseWriter, r *http.Request) error
+}
+
+type versionHandler struct {
+	versionService services.VersionService
+}
+
+func NewVersionHandler(versionService services.VersionService) VersionHandler {
+	return &versionHandler{
+		versionService: versionService,
+	}
+}
+
+func (h *versionHandler) HandleListVersions(w http.ResponseWriter, r *http.Request) error {
+	ctx := r.Context()
+	claims := ctx.Value("claims").(jwt.MapClaims)
+	userID, ok := claims["userID"].(float64)
+	if !ok {
+		return utils.ErrUnauthorized.New("invalid userID")
+	}
+
+	fileID, err := strconv.Atoi(mux.Vars(r)["fileID"])
+	if err != nil {
+		return utils.ErrBadRequest.Wrap(err, "invalid file ID")
+	}
+
+	versions, err := h.versionService.ListVersions(ctx, int(userID), fileID)
+	if err != nil {
+		return err
+	}
+
+	return middleware.WriteJSONResponse(w, http.StatusOK, map[string]interface{}{
+		"versions": versions,
+	})
+}
+
+func (h *versionHandler) HandleDownloadVersion(w http.ResponseWriter, r *http.Request) error {
+	ctx := r.Context()
+	claims := ctx.Value("claims").(jwt.MapClaims)
+	userID, ok := claims["userID"].(float64)
+	if !ok {
+		return utils.ErrUnauthorized.New("invalid userID")
+	}
+
+	params := mux.Vars(r)
+	fileID, err := strconv.Atoi(params["fileID"])
+	if err != nil {
+		return utils.ErrBadRequest.Wrap(err, "invalid file ID")
+	}
+	version, err := strconv.Atoi(params["version"])
+	if err != nil {
+		return utils.ErrBadRequest.Wrap(err, "invalid version")
+	}
+
+	dfile, err := h.versionService.DownloadVersion(ctx, int(userID), fileID, version)
+	if err != nil {
+		return err
+	}
+	defer dfile.Reader.(io.Closer).Close()
+
+	serveFile(w, r, dfile, "attachment")
+	return nil
+}
+
+func (h *versionHandler) HandleRestoreVersion(w http.ResponseWriter, r *http.Request) error {
+	ctx := r.Context()
+	claims := ctx.Value("claims").(jwt.MapClaims)
+	userID, ok := claims["userID"].(float64)
+	if !ok {
+		return utils.ErrUnauthorized.New("invalid userID")
+	}
+
+	params := mux.Vars(r)
+	fileID, err := strconv.Atoi(params["fileID"])
+	if err != nil {
+		return utils.ErrBadRequest.Wrap(err, "invalid file ID")
+	}
+	version, err := strconv.Atoi(params["version"])
+	if err != nil {
+		return utils.ErrBadRequest.Wrap(err, "invalid version")
+	}
+
+	file, err := h.versionService.RestoreVersion(ctx, int(userID), fileID, version)
+	if err != nil {
+		return err
+	}
+
+	return middleware.WriteJSONResponse(w, http.StatusOK, map[string]interface{}{
+		"file":    file,
+		"message": "file version restored",
+	})
+}
@@ -0,0 +1,164 @@
+package storage
+
+import (
+	"context"
+	"io"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/CustomCloudStorage/utils"
+)
+
+const localTempPrefix = ".put-"
+
+type localStore struct {
+	dir string
+}
+
+func NewLocalStore(dir string) BlobStore {
+	return &localStore{
+		dir: dir,
+	}
+}
+
+func (s *localStore) path(key string) string {
+	return filepath.Join(s.dir, filepath.FromSlash(key))
+}
+
+func (s *localStore) Put(ctx context.Context, key string, r io.Reader, size int64) error {
+	finalPath := s.path(key)
+	dir := filepath.Dir(finalPath)
+	if err := os.MkdirAll(dir, 0o755); err != nil {
+		return utils.DetermineFSError(err, "mkdir blob dir "+dir)
+	}
+
+	tmp, err := os.CreateTemp(dir, localTempPrefix+"*")
+	if err != nil {
+		return utils.DetermineFSError(err, "create temp blob in "+dir)
+	}
+	tmpPath := tmp.Name()
+	defer os.Remove(tmpPath)
+
+	n, err := io.Copy(tmp, r)
+	if err != nil {
+		tmp.Close()
+		return utils.ErrInternal.Wrap(err, "write blob %s", key)
+	}
+	if err := tmp.Close(); err != nil {
+		return utils.DetermineFSError(err, "close temp blob "+tmpPath)
+	}
+	if size >= 0 && n != size {
+		return utils.ErrBadRequest.New("blob %s size mismatch: expected %d, got %d", key, size, n)
+	}
+
+	if err := os.Rename(tmpPath, finalPath); err != nil {
+		return utils.DetermineFSError(err, "rename blob "+finalPath)
+	}
+	return nil
+}
+
+// ImportFile hard-links path into the store. When the link cannot be made,
+// for instance because the file lives on another filesystem, the contents are
+// copied instead.
+func (s *localStore) ImportFile(ctx context.Context, key, path string) error {
+	finalPath := s.path(key)
+	dir := filepath.Dir(finalPath)
+	if err := os.MkdirAll(dir, 0o755); err != nil {
+		return utils.DetermineFSError(err, "mkdir blob dir "+dir)
+	}
+	err := os.Link(path, finalPath)
+	if err == nil || os.IsExist(err) {
+		return nil
+	}
+
+	f, err := os.Open(path)
+	if err != nil {
+		return utils.DetermineFSError(err, "open import source "+path)
+	}
+	defer f.Close()
+	return s.Put(ctx, key, f, -1)
+}
+
+func (s *localStore) Get(ctx context.Context, key string) (io.ReadSeekCloser, error) {
+	f, err := os.Open(s.path(key))
+	if err != nil {
+		return nil, utils.DetermineFSError(err, "open blob "+key)
+	}
+	return f, nil
+}
+
+func (s *localStore) GetRange(ctx context.Context, key string, offset, length int64) (io.ReadCloser, error) {
+	f, err := os.Open(s.path(key))
+	if err != nil {
+		return nil, utils.DetermineFSError(err, "open blob "+key)
+	}
+	if _, err := f.Seek(offset, io.SeekStart); err != nil {
+		f.Close()
+		return nil, utils.DetermineFSError(err, "seek blob "+key)
+	}
+	if length < 0 {
+		return f, nil
+	}
+	return struct {
+		io.Reader
+		io.Closer
+	}{io.LimitReader(f, length), f}, nil
+}
+
+func (s *localStore) Stat(ctx context.Context, key string) (*BlobInfo, error) {
+	info, err := os.Stat(s.path(key))
+	if err != nil {
+		return nil, utils.DetermineFSError(err, "stat blob "+key)
+	}
+	return &BlobInfo{
+		Key:     key,
+		Size:    info.Size(),
+		ModTime: info.ModTime(),
+	}, nil
+}
+
+func (s *localStore) Delete(ctx context.Context, key string) error {
+	if err := os.Remove(s.path(key)); err != nil {
+		return utils.DetermineFSError(err, "remove blob "+key)
+	}
+	return nil
+}
+
+func (s *localStore) List(ctx context.Context, prefix string) ([]BlobInfo, error) {
+	var out []BlobInfo
+	err := filepath.WalkDir(s.dir, func(path string, d fs.DirEntry, err error) error {
+		if err != nil {
+			if os.IsNotExist(err) && path == s.dir {
+				return filepath.SkipDir
+			}
+			return err
+		}
+		if d.IsDir() || strings.HasPrefix(d.Name(), localTempPrefix) {
+			return nil
+		}
+		rel, err := filepath.Rel(s.dir, path)
+		if err != nil {
+			return err
+		}
+		key := filepath.ToSlash(rel)
+		if !strings.HasPrefix(key, prefix) {
+			return nil
+		}
+		info, err := d.Info()
+		if err != nil {
+			return err
+		}
+		out = append(out, BlobInfo{
+			Key:     key,
+			Size:    info.Size(),
+			ModTime: info.ModTime(),
+		})
+		return nil
+	})
+	if err != nil {
+		return nil, utils.DetermineFSError(err, "list blobs in "+s.dir)
+	}
+	return out, nil
+}
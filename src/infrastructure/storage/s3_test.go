@@ -0,0 +1,290 @@
+package storage
+
+import (
+	"bytes"
+	"context"
+	"encoding/xml"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/CustomCloudStorage/utils"
+	"github.com/joomcode/errorx"
+)
+
+const fakeS3PageSize = 2
+
+// fakeS3 is an in-memory S3 bucket that speaks just enough of the API for
+// s3Store: object PUT, GET with ranges, HEAD, DELETE and ListObjectsV2.
+type fakeS3 struct {
+	bucket  string
+	mu      sync.Mutex
+	objects map[string][]byte
+}
+
+func newFakeS3(t *testing.T) (*fakeS3, BlobStore) {
+	f := &fakeS3{bucket: "test-bucket", objects: map[string][]byte{}}
+	srv := httptest.NewServer(f)
+	t.Cleanup(srv.Close)
+
+	store, err := NewS3Store(S3Config{
+		Endpoint:  srv.URL,
+		Region:    "us-east-1",
+		Bucket:    f.bucket,
+		AccessKey: "access",
+		SecretKey: "secret",
+	})
+	if err != nil {
+		t.Fatalf("NewS3Store: %v", err)
+	}
+	return f, store
+}
+
+func (f *fakeS3) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	auth := r.Header.Get("Authorization")
+	if !strings.HasPrefix(auth, s3Algorithm+" Credential=access/") || r.Header.Get("x-amz-date") == "" {
+		http.Error(w, "missing signature", http.StatusForbidden)
+		return
+	}
+
+	path := strings.TrimPrefix(r.URL.Path, "/"+f.bucket)
+	if path == "" {
+		f.list(w, r)
+		return
+	}
+	key := strings.TrimPrefix(path, "/")
+
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	switch r.Method {
+	case http.MethodPut:
+		data, err := io.ReadAll(r.Body)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		f.objects[key] = data
+	case http.MethodHead, http.MethodGet:
+		data, ok := f.objects[key]
+		if !ok {
+			http.NotFound(w, r)
+			return
+		}
+		w.Header().Set("Last-Modified", time.Now().UTC().Format(http.TimeFormat))
+		if r.Method == http.MethodHead {
+			w.Header().Set("Content-Length", strconv.Itoa(len(data)))
+			return
+		}
+		start, end := 0, len(data)-1
+		if rng := r.Header.Get("Range"); rng != "" {
+			bounds := strings.SplitN(strings.TrimPrefix(rng, "bytes="), "-", 2)
+			start, _ = strconv.Atoi(bounds[0])
+			if bounds[1] != "" {
+				end, _ = strconv.Atoi(bounds[1])
+			}
+			w.Header().Set("Content-Range", fmt.Sprintf("bytes %d-%d/%d", start, end, len(data)))
+			w.WriteHeader(http.StatusPartialContent)
+		}
+		w.Write(data[start : end+1])
+	case http.MethodDelete:
+		delete(f.objects, key)
+		w.WriteHeader(http.StatusNoContent)
+	default:
+		w.WriteHeader(http.StatusMethodNotAllowed)
+	}
+}
+
+// list returns fakeS3PageSize keys per page; the continuation token is the
+// index of the next key.
+func (f *fakeS3) list(w http.ResponseWriter, r *http.Request) {
+	q := r.URL.Query()
+	if q.Get("list-type") != "2" {
+		http.Error(w, "only ListObjectsV2 is supported", http.StatusBadRequest)
+		return
+	}
+
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	var keys []string
+	for k := range f.objects {
+		if strings.HasPrefix(k, q.Get("prefix")) {
+			keys = append(keys, k)
+		}
+	}
+	sort.Strings(keys)
+
+	from, _ := strconv.Atoi(q.Get("continuation-token"))
+	to := min(from+fakeS3PageSize, len(keys))
+
+	type content struct {
+		Key          string
+		Size         int
+		LastModified string
+	}
+	result := struct {
+		XMLName               xml.Name `xml:"ListBucketResult"`
+		IsTruncated           bool
+		NextContinuationToken string `xml:",omitempty"`
+		Contents              []content
+	}{IsTruncated: to < len(keys)}
+	if result.IsTruncated {
+		result.NextContinuationToken = strconv.Itoa(to)
+	}
+	for _, k := range keys[from:to] {
+		result.Contents = append(result.Contents, content{
+			Key:          k,
+			Size:         len(f.objects[k]),
+			LastModified: time.Now().UTC().Format(time.RFC3339),
+		})
+	}
+	xml.NewEncoder(w).Encode(result)
+}
+
+func TestS3PutGet(t *testing.T) {
+	f, store := newFakeS3(t)
+	ctx := context.Background()
+
+	data := []byte("hello, object storage")
+	if err := store.Put(ctx, "dir/a b+c.txt", bytes.NewReader(data), int64(len(data))); err != nil {
+		t.Fatalf("Put: %v", err)
+	}
+	if got := f.objects["dir/a b+c.txt"]; !bytes.Equal(got, data) {
+		t.Fatalf("stored %q, want %q", got, data)
+	}
+
+	r, err := store.Get(ctx, "dir/a b+c.txt")
+	if err != nil {
+		t.Fatalf("Get: %v", err)
+	}
+	defer r.Close()
+	got, err := io.ReadAll(r)
+	if err != nil {
+		t.Fatalf("read: %v", err)
+	}
+	if !bytes.Equal(got, data) {
+		t.Fatalf("Get returned %q, want %q", got, data)
+	}
+
+	info, err := store.Stat(ctx, "dir/a b+c.txt")
+	if err != nil {
+		t.Fatalf("Stat: %v", err)
+	}
+	if info.Size != int64(len(data)) {
+		t.Fatalf("Stat size %d, want %d", info.Size, len(data))
+	}
+}
+
+func TestS3GetRangeAndSeek(t *testing.T) {
+	_, store := newFakeS3(t)
+	ctx := context.Background()
+
+	data := []byte("0123456789")
+	if err := store.Put(ctx, "digits", bytes.NewReader(data), int64(len(data))); err != nil {
+		t.Fatalf("Put: %v", err)
+	}
+
+	rc, err := store.GetRange(ctx, "digits", 2, 3)
+	if err != nil {
+		t.Fatalf("GetRange: %v", err)
+	}
+	got, _ := io.ReadAll(rc)
+	rc.Close()
+	if string(got) != "234" {
+		t.Fatalf("GetRange(2, 3) = %q, want %q", got, "234")
+	}
+
+	r, err := store.Get(ctx, "digits")
+	if err != nil {
+		t.Fatalf("Get: %v", err)
+	}
+	defer r.Close()
+
+	buf := make([]byte, 2)
+	if _, err := io.ReadFull(r, buf); err != nil || string(buf) != "01" {
+		t.Fatalf("first read = %q, %v", buf, err)
+	}
+	if pos, err := r.Seek(5, io.SeekStart); err != nil || pos != 5 {
+		t.Fatalf("Seek(5, start) = %d, %v", pos, err)
+	}
+	if _, err := io.ReadFull(r, buf); err != nil || string(buf) != "56" {
+		t.Fatalf("read after seek = %q, %v", buf, err)
+	}
+	if pos, err := r.Seek(-3, io.SeekEnd); err != nil || pos != 7 {
+		t.Fatalf("Seek(-3, end) = %d, %v", pos, err)
+	}
+	rest, err := io.ReadAll(r)
+	if err != nil || string(rest) != "789" {
+		t.Fatalf("read to end = %q, %v", rest, err)
+	}
+	if _, err := r.Seek(-1, io.SeekStart); err == nil {
+		t.Fatal("Seek to a negative offset succeeded")
+	}
+}
+
+func TestS3ListPages(t *testing.T) {
+	_, store := newFakeS3(t)
+	ctx := context.Background()
+
+	want := []string{"p/1", "p/2", "p/3", "p/4", "p/5"}
+	for _, key := range append([]string{"other"}, want...) {
+		if err := store.Put(ctx, key, strings.NewReader(key), int64(len(key))); err != nil {
+			t.Fatalf("Put %s: %v", key, err)
+		}
+	}
+
+	blobs, err := store.List(ctx, "p/")
+	if err != nil {
+		t.Fatalf("List: %v", err)
+	}
+	var got []string
+	for _, b := range blobs {
+		got = append(got, b.Key)
+		if b.Size != int64(len(b.Key)) {
+			t.Errorf("%s: size %d, want %d", b.Key, b.Size, len(b.Key))
+		}
+	}
+	if strings.Join(got, ",") != strings.Join(want, ",") {
+		t.Fatalf("List = %v, want %v", got, want)
+	}
+}
+
+func TestS3DeleteMissing(t *testing.T) {
+	_, store := newFakeS3(t)
+	ctx := context.Background()
+
+	if err := store.Put(ctx, "gone", strings.NewReader("x"), 1); err != nil {
+		t.Fatalf("Put: %v", err)
+	}
+	if err := store.Delete(ctx, "gone"); err != nil {
+		t.Fatalf("Delete: %v", err)
+	}
+	if err := store.Delete(ctx, "gone"); !errorx.IsOfType(err, utils.ErrNotFound) {
+		t.Fatalf("second Delete = %v, want not found", err)
+	}
+	if _, err := store.Get(ctx, "gone"); !errorx.IsOfType(err, utils.ErrNotFound) {
+		t.Fatalf("Get after Delete = %v, want not found", err)
+	}
+}
+
+func TestS3Sign(t *testing.T) {
+	store := &s3Store{cfg: S3Config{Region: "us-east-1", AccessKey: "AKIDEXAMPLE", SecretKey: "wJalrXUtnFEMI/K7MDENG+bPxRfiCYEXAMPLEKEY"}}
+	u, _ := url.Parse("https://s3.example.com/bucket/a%20b.txt?list-type=2&prefix=p%2F")
+	req := &http.Request{Method: http.MethodGet, URL: u, Header: http.Header{}}
+
+	store.sign(req, s3EmptyPayload, time.Date(2024, 1, 2, 3, 4, 5, 0, time.UTC))
+
+	want := "AWS4-HMAC-SHA256 Credential=AKIDEXAMPLE/20240102/us-east-1/s3/aws4_request, " +
+		"SignedHeaders=host;x-amz-content-sha256;x-amz-date, " +
+		"Signature=fb0ce7713dc47f0b3851428ddc7437330e37a640db6efcfb918c7710fff3eaf5"
+	if got := req.Header.Get("Authorization"); got != want {
+		t.Fatalf("Authorization =\n%s\nwant\n%s", got, want)
+	}
+}
@@ -0,0 +1,50 @@
+package storage
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"time"
+)
+
+type BlobStore interface {
+	Put(ctx context.Context, key string, r io.Reader, size int64) error
+	Get(ctx context.Context, key string) (io.ReadSeekCloser, error)
+	GetRange(ctx context.Context, key string, offset, length int64) (io.ReadCloser, error)
+	Stat(ctx context.Context, key string) (*BlobInfo, error)
+	Delete(ctx context.Context, key string) error
+	List(ctx context.Context, prefix string) ([]BlobInfo, error)
+}
+
+// FileImporter is implemented by stores that can adopt a local file as a
+// blob without copying its contents. The file at path is left in place.
+type FileImporter interface {
+	ImportFile(ctx context.Context, key, path string) error
+}
+
+type BlobInfo struct {
+	Key     string
+	Size    int64
+	ModTime time.Time
+}
+
+type Config struct {
+	Backend string
+	S3      S3Config
+}
+
+const (
+	BackendLocal = "local"
+	BackendS3    = "s3"
+)
+
+func NewBlobStore(cfg Config, localDir string) (BlobStore, error) {
+	switch cfg.Backend {
+	case "", BackendLocal:
+		return NewLocalStore(localDir), nil
+	case BackendS3:
+		return NewS3Store(cfg.S3)
+	default:
+		return nil, fmt.Errorf("unknown storage backend %q", cfg.Backend)
+	}
+}
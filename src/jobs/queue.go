@@ -0,0 +1,387 @@
+// Package jobs runs background work out of the jobs table, retrying failed
+// jobs with exponential backoff until they run out of attempts.
+package jobs
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math/rand"
+	"os"
+	"runtime/debug"
+	"time"
+
+	"github.com/CustomCloudStorage/repositories"
+	"github.com/CustomCloudStorage/types"
+	"github.com/CustomCloudStorage/utils"
+)
+
+const (
+	defaultWorkers      = 4
+	defaultMaxAttempts  = 5
+	defaultTimeout      = 10 * time.Minute
+	pollInterval        = 5 * time.Second
+	lease               = 2 * time.Minute
+	maintenanceInterval = time.Minute
+	finishedRetention   = 7 * 24 * time.Hour
+	backoffBase         = 30 * time.Second
+	backoffMax          = time.Hour
+)
+
+type Config struct {
+	Workers int
+}
+
+// Options tune how a kind of job is run. Zero values take the defaults.
+type Options struct {
+	MaxAttempts int
+	Timeout     time.Duration
+}
+
+// Kind names a kind of job whose payload is a T, encoded as JSON.
+type Kind[T any] struct {
+	Name string
+	Options
+}
+
+type handler struct {
+	options Options
+	run     func(ctx context.Context, payload []byte) error
+}
+
+type periodic struct {
+	name     string
+	interval time.Duration
+}
+
+type Queue struct {
+	repository repositories.JobRepository
+	tasks      repositories.PeriodicTaskRepository
+	replica    string
+	workers    int
+	handlers   map[string]handler
+	kinds      []string
+	periodic   []periodic
+	wake       chan struct{}
+}
+
+func NewQueue(jobRepo repositories.JobRepository, taskRepo repositories.PeriodicTaskRepository, cfg Config) *Queue {
+	workers := cfg.Workers
+	if workers <= 0 {
+		workers = defaultWorkers
+	}
+	host, err := os.Hostname()
+	if err != nil {
+		host = "unknown"
+	}
+	return &Queue{
+		repository: jobRepo,
+		tasks:      taskRepo,
+		replica:    fmt.Sprintf("%s:%d", host, os.Getpid()),
+		workers:    workers,
+		handlers:   make(map[string]handler),
+		wake:       make(chan struct{}, 1),
+	}
+}
+
+// Register sets the function that runs jobs of the kind. Kinds are registered
+// while the services are built, before Start.
+func Register[T any](q *Queue, kind Kind[T], fn func(ctx context.Context, payload T) error) {
+	options := kind.Options
+	if options.MaxAttempts <= 0 {
+		options.MaxAttempts = defaultMaxAttempts
+	}
+	if options.Timeout <= 0 {
+		options.Timeout = defaultTimeout
+	}
+	if _, ok := q.handlers[kind.Name]; !ok {
+		q.kinds = append(q.kinds, kind.Name)
+	}
+	q.handlers[kind.Name] = handler{
+		options: options,
+		run: func(ctx context.Context, raw []byte) error {
+			var payload T
+			if err := json.Unmarshal(raw, &payload); err != nil {
+				return Permanent(fmt.Errorf("decode payload: %w", err))
+			}
+			return fn(ctx, payload)
+		},
+	}
+}
+
+// Enqueue adds a job of the kind that runs as soon as a worker is free.
+func Enqueue[T any](ctx context.Context, q *Queue, kind Kind[T], payload T) error {
+	_, err := q.enqueue(ctx, kind.Name, payload, nil, time.Now())
+	return err
+}
+
+// Live reports whether a job of the kind whose payload contains the fields of
+// match is queued or running.
+func Live[T any](ctx context.Context, q *Queue, kind Kind[T], match interface{}) (bool, error) {
+	raw, err := json.Marshal(match)
+	if err != nil {
+		return false, utils.ErrInternal.Wrap(err, "encode %s payload", kind.Name)
+	}
+	return q.repository.HasLive(ctx, kind.Name, raw)
+}
+
+// Every runs fn once per interval on whichever replica gets to it first.
+func (q *Queue) Every(name string, interval time.Duration, fn func(ctx context.Context) error) {
+	Register(q, Kind[struct{}]{Name: name, Options: Options{Timeout: interval}}, func(ctx context.Context, _ struct{}) error {
+		return q.runPeriodic(ctx, name, fn)
+	})
+	q.periodic = append(q.periodic, periodic{name: name, interval: interval})
+}
+
+func (q *Queue) enqueue(ctx context.Context, kind string, payload interface{}, uniqueKey *string, runAt time.Time) (bool, error) {
+	h, ok := q.handlers[kind]
+	if !ok {
+		return false, utils.ErrInternal.New("unknown job kind %q", kind)
+	}
+	raw, err := json.Marshal(payload)
+	if err != nil {
+		return false, utils.ErrInternal.Wrap(err, "encode %s payload", kind)
+	}
+	job := &types.Job{
+		Kind:        kind,
+		Payload:     raw,
+		Status:      types.JobQueued,
+		MaxAttempts: h.options.MaxAttempts,
+		UniqueKey:   uniqueKey,
+		RunAt:       runAt,
+	}
+	inserted, err := q.repository.Enqueue(ctx, job)
+	if err != nil {
+		return false, err
+	}
+	if inserted {
+		q.notify()
+	}
+	return inserted, nil
+}
+
+func (q *Queue) notify() {
+	select {
+	case q.wake <- struct{}{}:
+	default:
+	}
+}
+
+// Start launches the workers, the schedulers of periodic tasks and the
+// maintenance loop.
+func (q *Queue) Start() {
+	if len(q.kinds) == 0 {
+		return
+	}
+	for i := 0; i < q.workers; i++ {
+		go q.work()
+	}
+	for _, p := range q.periodic {
+		if err := q.tasks.Register(context.Background(), p.name, p.interval); err != nil {
+			fmt.Printf("jobs: register %s: %v\n", p.name, err)
+		}
+		go q.schedule(p)
+	}
+	go q.maintain()
+}
+
+func (q *Queue) List(ctx context.Context, status, kind string, page *types.PageQuery) ([]types.Job, string, error) {
+	return q.repository.List(ctx, status, kind, page)
+}
+
+func (q *Queue) Get(ctx context.Context, id int) (*types.Job, error) {
+	return q.repository.GetByID(ctx, id)
+}
+
+func (q *Queue) Count(ctx context.Context) ([]types.JobCount, error) {
+	return q.repository.Count(ctx)
+}
+
+// Periodic lists the periodic tasks with their latest runs and when they are
+// next due.
+func (q *Queue) Periodic(ctx context.Context) ([]types.PeriodicTask, error) {
+	tasks, err := q.tasks.List(ctx)
+	if err != nil {
+		return nil, err
+	}
+	now := time.Now()
+	for i := range tasks {
+		interval := time.Duration(tasks[i].IntervalSeconds) * time.Second
+		tasks[i].NextRunAt = now.Truncate(interval).Add(interval)
+	}
+	return tasks, nil
+}
+
+// Retry puts a dead or cancelled job back in the queue.
+func (q *Queue) Retry(ctx context.Context, id int) error {
+	if err := q.repository.Retry(ctx, id); err != nil {
+		return err
+	}
+	q.notify()
+	return nil
+}
+
+// Cancel keeps a queued job from running.
+func (q *Queue) Cancel(ctx context.Context, id int) error {
+	return q.repository.Cancel(ctx, id)
+}
+
+func (q *Queue) work() {
+	for {
+		job, err := q.repository.Claim(context.Background(), q.kinds, lease)
+		if err != nil {
+			fmt.Printf("jobs: claim: %v\n", err)
+		}
+		if job == nil {
+			select {
+			case <-q.wake:
+			case <-time.After(pollInterval):
+			}
+			continue
+		}
+		q.run(job)
+	}
+}
+
+func (q *Queue) run(job *types.Job) {
+	h := q.handlers[job.Kind]
+	ctx, cancel := context.WithTimeout(context.Background(), h.options.Timeout)
+	stop := q.heartbeat(job.ID)
+	err := h.call(ctx, job.Payload)
+	stop()
+	cancel()
+
+	ctx = context.Background()
+	if err == nil {
+		if err := q.repository.Complete(ctx, job.ID); err != nil {
+			fmt.Printf("jobs: %s %d: complete: %v\n", job.Kind, job.ID, err)
+		}
+		return
+	}
+
+	var retryAt *time.Time
+	var permanent *permanentError
+	if !errors.As(err, &permanent) && job.Attempts < job.MaxAttempts {
+		at := time.Now().Add(backoff(job.Attempts))
+		retryAt = &at
+	}
+	if retryAt != nil {
+		fmt.Printf("jobs: %s %d: attempt %d failed, retrying at %s: %v\n", job.Kind, job.ID, job.Attempts, retryAt.Format(time.RFC3339), err)
+	} else {
+		fmt.Printf("jobs: %s %d: dead after %d attempts: %v\n", job.Kind, job.ID, job.Attempts, err)
+	}
+	if err := q.repository.Fail(ctx, job.ID, err.Error(), retryAt); err != nil {
+		fmt.Printf("jobs: %s %d: record failure: %v\n", job.Kind, job.ID, err)
+	}
+}
+
+func (h handler) call(ctx context.Context, payload []byte) error {
+	return safely(func() error { return h.run(ctx, payload) })
+}
+
+// safely turns a panic into an error so that one bad job cannot take the
+// server down.
+func safely(fn func() error) (err error) {
+	defer func() {
+		if r := recover(); r != nil {
+			err = fmt.Errorf("panic: %v\n%s", r, debug.Stack())
+		}
+	}()
+	return fn()
+}
+
+// When another replica holds the lease the task is already running there.
+func (q *Queue) runPeriodic(ctx context.Context, name string, fn func(ctx context.Context) error) error {
+	ran, err := q.tasks.WithLease(ctx, name, func() error {
+		if err := q.tasks.Started(ctx, name, q.replica); err != nil {
+			fmt.Printf("jobs: %s: record start: %v\n", name, err)
+		}
+		started := time.Now()
+		err := safely(func() error { return fn(ctx) })
+		status, message := types.TaskSucceeded, ""
+		if err != nil {
+			status, message = types.TaskFailed, err.Error()
+		}
+		if err := q.tasks.Finished(context.Background(), name, status, message, time.Since(started)); err != nil {
+			fmt.Printf("jobs: %s: record outcome: %v\n", name, err)
+		}
+		return err
+	})
+	if err == nil && !ran {
+		fmt.Printf("jobs: %s: running on another replica, skipped\n", name)
+	}
+	return err
+}
+
+func (q *Queue) heartbeat(id int) func() {
+	done := make(chan struct{})
+	go func() {
+		ticker := time.NewTicker(lease / 4)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-done:
+				return
+			case <-ticker.C:
+				if err := q.repository.Extend(context.Background(), id, lease); err != nil {
+					fmt.Printf("jobs: %d: extend lease: %v\n", id, err)
+				}
+			}
+		}
+	}()
+	return func() { close(done) }
+}
+
+// The jitter keeps jobs that failed together from retrying together.
+func backoff(attempt int) time.Duration {
+	d := backoffMax
+	if attempt < 20 {
+		d = min(backoffBase<<(attempt-1), backoffMax)
+	}
+	return d + time.Duration(rand.Int63n(int64(d)/5+1))
+}
+
+func (q *Queue) schedule(p periodic) {
+	next := time.Now().Truncate(p.interval)
+	for {
+		time.Sleep(time.Until(next))
+		key := fmt.Sprintf("%s@%d", p.name, next.Unix())
+		if _, err := q.enqueue(context.Background(), p.name, struct{}{}, &key, next); err != nil {
+			fmt.Printf("jobs: schedule %s: %v\n", p.name, err)
+		}
+		next = next.Add(p.interval)
+	}
+}
+
+func (q *Queue) maintain() {
+	ticker := time.NewTicker(maintenanceInterval)
+	defer ticker.Stop()
+	for range ticker.C {
+		ctx := context.Background()
+		if n, err := q.repository.RequeueExpired(ctx); err != nil {
+			fmt.Printf("jobs: requeue expired: %v\n", err)
+		} else if n > 0 {
+			fmt.Printf("jobs: %d jobs with an expired lease requeued\n", n)
+			q.notify()
+		}
+		if _, err := q.repository.DeleteFinished(ctx, time.Now().Add(-finishedRetention)); err != nil {
+			fmt.Printf("jobs: delete finished: %v\n", err)
+		}
+	}
+}
+
+type permanentError struct {
+	err error
+}
+
+func (e *permanentError) Error() string { return e.err.Error() }
+func (e *permanentError) Unwrap() error { return e.err }
+
+// Permanent marks an error that retrying cannot fix: the job is dead at once.
+func Permanent(err error) error {
+	if err == nil {
+		return nil
+	}
+	return &permanentError{err: err}
+}
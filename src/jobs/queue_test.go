@@ -0,0 +1,28 @@
+package jobs
+
+import (
+	"testing"
+	"time"
+)
+
+func TestBackoff(t *testing.T) {
+	tests := []struct {
+		attempt int
+		base    time.Duration
+	}{
+		{1, 30 * time.Second},
+		{2, time.Minute},
+		{3, 2 * time.Minute},
+		{7, 32 * time.Minute},
+		{8, time.Hour},
+		{50, time.Hour},
+	}
+	for _, tt := range tests {
+		for range 100 {
+			got := backoff(tt.attempt)
+			if got < tt.base || got > tt.base+tt.base/5 {
+				t.Fatalf("backoff(%d) = %s, want between %s and %s", tt.attempt, got, tt.base, tt.base+tt.base/5)
+			}
+		}
+	}
+}
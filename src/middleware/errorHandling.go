@@ -20,6 +20,12 @@ func HandleError(handler HandlerWithErrorFunc) http.HandlerFunc {
 				message string
 			)
 			switch {
+			case errorx.IsOfType(err, utils.ErrChecksumMismatch):
+				status = http.StatusBadRequest
+				message = "Checksum mismatch"
+			case errorx.IsOfType(err, utils.ErrIncompleteUpload):
+				status = http.StatusBadRequest
+				message = "Upload incomplete"
 			case errorx.IsOfType(err, utils.ErrBadRequest):
 				status = http.StatusBadRequest
 				message = "Bad request"
@@ -40,9 +46,13 @@ func HandleError(handler HandlerWithErrorFunc) http.HandlerFunc {
 				message = "Internal server error"
 			}
 
-			WriteJSONResponse(w, status, map[string]interface{}{
+			payload := map[string]interface{}{
 				"error": message,
-			})
+			}
+			if details, ok := errorx.ExtractProperty(err, utils.PropertyDetails); ok {
+				payload["details"] = details
+			}
+			WriteJSONResponse(w, status, payload)
 		}
 	}
 }
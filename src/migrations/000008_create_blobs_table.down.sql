@@ -0,0 +1 @@
+DROP TABLE IF EXISTS blobs;
\ No newline at end of file
@@ -0,0 +1,12 @@
+CREATE TABLE IF NOT EXISTS blobs (
+    hash       TEXT PRIMARY KEY,
+    size       BIGINT NOT NULL,
+    ref_count  INT NOT NULL DEFAULT 0,
+    created_at TIMESTAMPTZ NOT NULL DEFAULT now()
+);
+
+INSERT INTO blobs (hash, size, ref_count)
+SELECT physical_name, MAX(size), COUNT(*)
+    FROM files
+    GROUP BY physical_name
+ON CONFLICT (hash) DO NOTHING;
@@ -0,0 +1,16 @@
+ALTER TABLE files
+    ADD COLUMN version INT NOT NULL DEFAULT 1;
+
+CREATE TABLE IF NOT EXISTS file_versions (
+    id            SERIAL PRIMARY KEY,
+    file_id       INT NOT NULL REFERENCES files(id) ON DELETE CASCADE,
+    version       INT NOT NULL,
+    size          BIGINT NOT NULL,
+    physical_name TEXT NOT NULL,
+    created_at    TIMESTAMPTZ NOT NULL DEFAULT now(),
+    UNIQUE (file_id, version)
+);
+
+INSERT INTO file_versions (file_id, version, size, physical_name, created_at)
+SELECT id, 1, size, physical_name, COALESCE(updated_at, now())
+    FROM files;
@@ -0,0 +1,16 @@
+CREATE TABLE IF NOT EXISTS share_links (
+    id             SERIAL PRIMARY KEY,
+    token          TEXT NOT NULL UNIQUE,
+    user_id        INT NOT NULL REFERENCES users(id) ON DELETE CASCADE,
+    file_id        INT REFERENCES files(id) ON DELETE CASCADE,
+    folder_id      INT REFERENCES folders(id) ON DELETE CASCADE,
+    password_hash  TEXT NOT NULL DEFAULT '',
+    expires_at     TIMESTAMPTZ,
+    max_downloads  INT,
+    download_count INT NOT NULL DEFAULT 0,
+    revoked_at     TIMESTAMPTZ,
+    created_at     TIMESTAMPTZ NOT NULL DEFAULT now(),
+    CONSTRAINT chk_share_links_target CHECK ((file_id IS NULL) <> (folder_id IS NULL))
+);
+
+CREATE INDEX IF NOT EXISTS idx_share_links_user ON share_links(user_id);
@@ -0,0 +1 @@
+DROP TABLE IF EXISTS access_grants;
\ No newline at end of file
@@ -0,0 +1,15 @@
+CREATE TABLE IF NOT EXISTS access_grants (
+    id         SERIAL PRIMARY KEY,
+    owner_id   INT NOT NULL REFERENCES users(id) ON DELETE CASCADE,
+    grantee_id INT NOT NULL REFERENCES users(id) ON DELETE CASCADE,
+    file_id    INT REFERENCES files(id) ON DELETE CASCADE,
+    folder_id  INT REFERENCES folders(id) ON DELETE CASCADE,
+    permission TEXT NOT NULL,
+    created_at TIMESTAMPTZ NOT NULL DEFAULT now(),
+    CONSTRAINT chk_access_grants_target CHECK ((file_id IS NULL) <> (folder_id IS NULL)),
+    CONSTRAINT chk_access_grants_permission CHECK (permission IN ('viewer', 'editor'))
+);
+
+CREATE UNIQUE INDEX IF NOT EXISTS uq_access_grants_file ON access_grants(grantee_id, file_id) WHERE file_id IS NOT NULL;
+CREATE UNIQUE INDEX IF NOT EXISTS uq_access_grants_folder ON access_grants(grantee_id, folder_id) WHERE folder_id IS NOT NULL;
+CREATE INDEX IF NOT EXISTS idx_access_grants_owner ON access_grants(owner_id);
@@ -0,0 +1,6 @@
+DROP INDEX IF EXISTS idx_folders_parent;
+DROP INDEX IF EXISTS idx_files_user_updated;
+DROP INDEX IF EXISTS idx_files_user_created;
+DROP INDEX IF EXISTS idx_files_user_size;
+DROP INDEX IF EXISTS idx_files_user_extension;
+DROP INDEX IF EXISTS idx_files_name_trgm;
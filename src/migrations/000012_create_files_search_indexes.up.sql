@@ -0,0 +1,8 @@
+CREATE EXTENSION IF NOT EXISTS pg_trgm;
+
+CREATE INDEX IF NOT EXISTS idx_files_name_trgm ON files USING gin (name gin_trgm_ops);
+CREATE INDEX IF NOT EXISTS idx_files_user_extension ON files(user_id, lower(extension));
+CREATE INDEX IF NOT EXISTS idx_files_user_size ON files(user_id, size);
+CREATE INDEX IF NOT EXISTS idx_files_user_created ON files(user_id, created_at);
+CREATE INDEX IF NOT EXISTS idx_files_user_updated ON files(user_id, updated_at);
+CREATE INDEX IF NOT EXISTS idx_folders_parent ON folders(parent_id);
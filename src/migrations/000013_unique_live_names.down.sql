@@ -0,0 +1,2 @@
+DROP INDEX IF EXISTS uq_folders_live_name;
+DROP INDEX IF EXISTS uq_files_live_name;
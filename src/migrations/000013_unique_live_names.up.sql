@@ -0,0 +1,37 @@
+BEGIN;
+
+WITH ranked AS (
+    SELECT id, ROW_NUMBER() OVER (
+        PARTITION BY user_id, COALESCE(folder_id, 0), name, extension
+        ORDER BY id
+    ) AS rn
+	FROM files
+	WHERE deleted_at IS NULL
+)
+UPDATE files
+	SET name = files.name || ' (' || files.id || ')'
+	FROM ranked
+	WHERE files.id = ranked.id AND ranked.rn > 1;
+
+WITH ranked AS (
+    SELECT id, ROW_NUMBER() OVER (
+        PARTITION BY user_id, COALESCE(parent_id, 0), name
+        ORDER BY id
+    ) AS rn
+	FROM folders
+	WHERE deleted_at IS NULL
+)
+UPDATE folders
+	SET name = folders.name || ' (' || folders.id || ')'
+	FROM ranked
+	WHERE folders.id = ranked.id AND ranked.rn > 1;
+
+CREATE UNIQUE INDEX IF NOT EXISTS uq_files_live_name
+    ON files(user_id, COALESCE(folder_id, 0), name, extension)
+    WHERE deleted_at IS NULL;
+
+CREATE UNIQUE INDEX IF NOT EXISTS uq_folders_live_name
+    ON folders(user_id, COALESCE(parent_id, 0), name)
+    WHERE deleted_at IS NULL;
+
+COMMIT;
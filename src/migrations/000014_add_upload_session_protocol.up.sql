@@ -0,0 +1,3 @@
+ALTER TABLE upload_sessions
+    ADD COLUMN IF NOT EXISTS protocol TEXT NOT NULL DEFAULT 'parts'
+        CHECK (protocol IN ('parts', 'tus'));
@@ -0,0 +1,3 @@
+ALTER TABLE upload_sessions DROP COLUMN IF EXISTS expected_sha256;
+ALTER TABLE file_versions DROP COLUMN IF EXISTS sha256;
+ALTER TABLE files DROP COLUMN IF EXISTS sha256;
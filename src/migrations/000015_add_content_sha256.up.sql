@@ -0,0 +1,17 @@
+BEGIN;
+
+ALTER TABLE files
+    ADD COLUMN IF NOT EXISTS sha256 TEXT NOT NULL DEFAULT '';
+
+ALTER TABLE file_versions
+    ADD COLUMN IF NOT EXISTS sha256 TEXT NOT NULL DEFAULT '';
+
+ALTER TABLE upload_sessions
+    ADD COLUMN IF NOT EXISTS expected_sha256 TEXT NOT NULL DEFAULT '';
+
+-- Content-addressed blobs are named by their SHA-256; older physical names
+-- are left without a digest.
+UPDATE files SET sha256 = physical_name WHERE physical_name ~ '^[0-9a-f]{64}$';
+UPDATE file_versions SET sha256 = physical_name WHERE physical_name ~ '^[0-9a-f]{64}$';
+
+COMMIT;
@@ -0,0 +1,5 @@
+ALTER TABLE upload_sessions
+    DROP COLUMN IF EXISTS error,
+    DROP COLUMN IF EXISTS file_id,
+    DROP COLUMN IF EXISTS status,
+    DROP COLUMN IF EXISTS part_size;
@@ -0,0 +1,6 @@
+ALTER TABLE upload_sessions
+    ADD COLUMN IF NOT EXISTS part_size BIGINT NOT NULL DEFAULT 0,
+    ADD COLUMN IF NOT EXISTS status TEXT NOT NULL DEFAULT 'open'
+        CHECK (status IN ('open', 'finalizing', 'completed', 'failed')),
+    ADD COLUMN IF NOT EXISTS file_id INT REFERENCES files(id) ON DELETE SET NULL,
+    ADD COLUMN IF NOT EXISTS error TEXT NOT NULL DEFAULT '';
@@ -0,0 +1,8 @@
+UPDATE accounts
+    SET used_storage = accounts.used_storage + r.size
+    FROM (
+        SELECT user_id, SUM(size) AS size FROM storage_reservations GROUP BY user_id
+    ) r
+    WHERE accounts.user_id = r.user_id;
+
+DROP TABLE IF EXISTS storage_reservations;
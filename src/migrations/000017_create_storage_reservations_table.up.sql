@@ -0,0 +1,23 @@
+CREATE TABLE IF NOT EXISTS storage_reservations (
+    id                UUID PRIMARY KEY,
+    user_id           INT NOT NULL REFERENCES users(id) ON DELETE CASCADE,
+    upload_session_id UUID UNIQUE REFERENCES upload_sessions(id) ON DELETE CASCADE,
+    size              BIGINT NOT NULL CHECK (size >= 0),
+    created_at        TIMESTAMPTZ NOT NULL DEFAULT now()
+);
+
+CREATE INDEX IF NOT EXISTS idx_storage_reservations_user_id ON storage_reservations(user_id);
+
+-- Pending sessions were charged to used_storage up front; move those charges
+-- into the ledger.
+INSERT INTO storage_reservations (id, user_id, upload_session_id, size, created_at)
+SELECT id, user_id, id, total_size, created_at
+    FROM upload_sessions
+    WHERE status <> 'completed';
+
+UPDATE accounts
+    SET used_storage = GREATEST(accounts.used_storage - r.size, 0)
+    FROM (
+        SELECT user_id, SUM(size) AS size FROM storage_reservations GROUP BY user_id
+    ) r
+    WHERE accounts.user_id = r.user_id;
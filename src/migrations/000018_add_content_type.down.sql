@@ -0,0 +1,11 @@
+BEGIN;
+
+DROP INDEX IF EXISTS idx_files_user_content_type;
+
+ALTER TABLE file_versions
+    DROP COLUMN IF EXISTS content_type;
+
+ALTER TABLE files
+    DROP COLUMN IF EXISTS content_type;
+
+COMMIT;
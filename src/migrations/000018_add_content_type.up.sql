@@ -0,0 +1,47 @@
+BEGIN;
+
+ALTER TABLE files
+    ADD COLUMN IF NOT EXISTS content_type TEXT NOT NULL DEFAULT 'application/octet-stream';
+
+ALTER TABLE file_versions
+    ADD COLUMN IF NOT EXISTS content_type TEXT NOT NULL DEFAULT 'application/octet-stream';
+
+-- Existing content is not sniffed again; it is typed by its extension, the way
+-- downloads used to do it. New uploads are detected from their bytes.
+CREATE TEMPORARY TABLE extension_types (extension TEXT PRIMARY KEY, content_type TEXT NOT NULL) ON COMMIT DROP;
+INSERT INTO extension_types VALUES
+    ('.jpg', 'image/jpeg'),
+    ('.jpeg', 'image/jpeg'),
+    ('.png', 'image/png'),
+    ('.gif', 'image/gif'),
+    ('.webp', 'image/webp'),
+    ('.svg', 'image/svg+xml'),
+    ('.mp4', 'video/mp4'),
+    ('.webm', 'video/webm'),
+    ('.mov', 'video/quicktime'),
+    ('.mp3', 'audio/mpeg'),
+    ('.wav', 'audio/wav'),
+    ('.ogg', 'audio/ogg'),
+    ('.pdf', 'application/pdf'),
+    ('.zip', 'application/zip'),
+    ('.json', 'application/json'),
+    ('.txt', 'text/plain; charset=utf-8'),
+    ('.csv', 'text/csv; charset=utf-8'),
+    ('.html', 'text/html; charset=utf-8'),
+    ('.docx', 'application/vnd.openxmlformats-officedocument.wordprocessingml.document'),
+    ('.xlsx', 'application/vnd.openxmlformats-officedocument.spreadsheetml.sheet'),
+    ('.pptx', 'application/vnd.openxmlformats-officedocument.presentationml.presentation');
+
+UPDATE files f
+    SET content_type = t.content_type
+    FROM extension_types t
+    WHERE t.extension = lower(f.extension);
+
+UPDATE file_versions v
+    SET content_type = f.content_type
+    FROM files f
+    WHERE f.id = v.file_id;
+
+CREATE INDEX IF NOT EXISTS idx_files_user_content_type ON files(user_id, content_type text_pattern_ops);
+
+COMMIT;
@@ -0,0 +1,6 @@
+DROP INDEX IF EXISTS idx_files_metadata;
+DROP INDEX IF EXISTS idx_files_user_taken_at;
+DROP INDEX IF EXISTS idx_files_metadata_pending;
+
+ALTER TABLE files
+    DROP COLUMN IF EXISTS file_metadata;
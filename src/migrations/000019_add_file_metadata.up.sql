@@ -0,0 +1,8 @@
+ALTER TABLE files
+    ADD COLUMN IF NOT EXISTS file_metadata JSONB;
+
+-- Files still waiting for the metadata extractor.
+CREATE INDEX IF NOT EXISTS idx_files_metadata_pending ON files(id) WHERE file_metadata IS NULL;
+-- Capture dates are stored as UTC RFC 3339 strings, which sort as text.
+CREATE INDEX IF NOT EXISTS idx_files_user_taken_at ON files(user_id, (file_metadata->>'taken_at'));
+CREATE INDEX IF NOT EXISTS idx_files_metadata ON files USING gin (file_metadata jsonb_path_ops);
@@ -0,0 +1 @@
+DROP TABLE IF EXISTS periodic_tasks;
@@ -0,0 +1,14 @@
+-- One row per periodic maintenance task, describing its latest run.
+CREATE TABLE IF NOT EXISTS periodic_tasks (
+    name             TEXT PRIMARY KEY,
+    interval_seconds BIGINT NOT NULL CHECK (interval_seconds > 0),
+    last_status      TEXT NOT NULL DEFAULT ''
+        CHECK (last_status IN ('', 'running', 'succeeded', 'failed')),
+    last_error       TEXT NOT NULL DEFAULT '',
+    last_replica     TEXT NOT NULL DEFAULT '',
+    last_started_at  TIMESTAMPTZ,
+    last_finished_at TIMESTAMPTZ,
+    last_duration_ms BIGINT NOT NULL DEFAULT 0,
+    runs             BIGINT NOT NULL DEFAULT 0,
+    failures         BIGINT NOT NULL DEFAULT 0
+);
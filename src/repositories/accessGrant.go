@@ -0,0 +1,130 @@
+package repositories
+
+import (
+	"context"
+
+	"github.com/CustomCloudStorage/types"
+	"github.com/CustomCloudStorage/utils"
+	"gorm.io/gorm"
+)
+
+const grantedFolderIDs = `(
+WITH RECURSIVE granted AS (
+    SELECT folder_id AS id FROM access_grants WHERE grantee_id = @user AND folder_id IS NOT NULL
+	UNION
+    SELECT f.id FROM folders f JOIN granted g ON f.parent_id = g.id
+)
+SELECT id FROM granted)`
+
+const grantedFileIDs = `(
+SELECT file_id FROM access_grants WHERE grantee_id = @user AND file_id IS NOT NULL)`
+
+type AccessGrantRepository interface {
+	Create(ctx context.Context, grant *types.AccessGrant) error
+	Delete(ctx context.Context, id, ownerID int) error
+	ListByOwner(ctx context.Context, ownerID int) ([]types.AccessGrant, error)
+	ListByGrantee(ctx context.Context, granteeID int) ([]types.AccessGrant, error)
+	PermissionForFile(ctx context.Context, userID int, file *types.File) (string, error)
+	PermissionForFolder(ctx context.Context, userID int, folder *types.Folder) (string, error)
+}
+
+type accessGrantRepository struct {
+	db *gorm.DB
+}
+
+func NewAccessGrantRepository(db *gorm.DB) AccessGrantRepository {
+	return &accessGrantRepository{
+		db: db,
+	}
+}
+
+func (r *accessGrantRepository) Create(ctx context.Context, grant *types.AccessGrant) error {
+	if err := r.db.WithContext(ctx).
+		Omit("File", "Folder").
+		Create(grant).Error; err != nil {
+		return utils.DetermineSQLError(err, "create access grant")
+	}
+	return nil
+}
+
+func (r *accessGrantRepository) Delete(ctx context.Context, id, ownerID int) error {
+	result := r.db.WithContext(ctx).
+		Where("id = ? AND owner_id = ?", id, ownerID).
+		Delete(&types.AccessGrant{})
+	if err := result.Error; err != nil {
+		return utils.DetermineSQLError(err, "delete access grant")
+	}
+	if result.RowsAffected == 0 {
+		return utils.ErrNotFound.New("access grant %d not found", id)
+	}
+	return nil
+}
+
+func (r *accessGrantRepository) ListByOwner(ctx context.Context, ownerID int) ([]types.AccessGrant, error) {
+	var grants []types.AccessGrant
+	if err := r.db.WithContext(ctx).
+		Preload("File").
+		Preload("Folder").
+		Where("owner_id = ?", ownerID).
+		Order("created_at DESC").
+		Find(&grants).Error; err != nil {
+		return nil, utils.DetermineSQLError(err, "list access grants")
+	}
+	return grants, nil
+}
+
+func (r *accessGrantRepository) ListByGrantee(ctx context.Context, granteeID int) ([]types.AccessGrant, error) {
+	var grants []types.AccessGrant
+	if err := r.db.WithContext(ctx).
+		Preload("File", "deleted_at IS NULL").
+		Preload("Folder", "deleted_at IS NULL").
+		Where("grantee_id = ?", granteeID).
+		Order("created_at DESC").
+		Find(&grants).Error; err != nil {
+		return nil, utils.DetermineSQLError(err, "list shared with me")
+	}
+	return grants, nil
+}
+
+func (r *accessGrantRepository) PermissionForFile(ctx context.Context, userID int, file *types.File) (string, error) {
+	if file.UserID == userID {
+		return types.PermissionOwner, nil
+	}
+	return r.permission(ctx, userID, &file.ID, file.FolderID)
+}
+
+func (r *accessGrantRepository) PermissionForFolder(ctx context.Context, userID int, folder *types.Folder) (string, error) {
+	if folder.UserID == userID {
+		return types.PermissionOwner, nil
+	}
+	return r.permission(ctx, userID, nil, &folder.ID)
+}
+
+func (r *accessGrantRepository) permission(ctx context.Context, userID int, fileID, folderID *int) (string, error) {
+	const sql = `
+WITH RECURSIVE ancestors AS (
+    SELECT id, parent_id FROM folders WHERE id = @folder
+	UNION
+    SELECT p.id, p.parent_id FROM folders p JOIN ancestors a ON p.id = a.parent_id
+)
+SELECT permission
+	FROM access_grants
+	WHERE grantee_id = @user
+	AND (file_id = @file OR folder_id IN (SELECT id FROM ancestors));
+`
+	var permissions []string
+	if err := r.db.WithContext(ctx).
+		Raw(sql, map[string]interface{}{"user": userID, "file": fileID, "folder": folderID}).
+		Scan(&permissions).Error; err != nil {
+		return "", utils.DetermineSQLError(err, "resolve access permission")
+	}
+
+	best := ""
+	for _, p := range permissions {
+		if p == types.PermissionEditor {
+			return p, nil
+		}
+		best = p
+	}
+	return best, nil
+}
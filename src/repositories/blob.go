@@ -0,0 +1,127 @@
+package repositories
+
+import (
+	"context"
+	"errors"
+	"time"
+
+	"github.com/CustomCloudStorage/types"
+	"github.com/CustomCloudStorage/utils"
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+var errBlobNotStored = errors.New("blob content not stored yet")
+
+type BlobRepository interface {
+	Acquire(ctx context.Context, hash string, size int64, onCreate func() error) error
+	Release(ctx context.Context, hash string, onLast func() error) error
+	ListUnreferenced(ctx context.Context, before time.Time) ([]types.Blob, error)
+	Forget(ctx context.Context, hash string) error
+}
+
+type blobRepository struct {
+	db *gorm.DB
+}
+
+func NewBlobRepository(db *gorm.DB) BlobRepository {
+	return &blobRepository{
+		db: db,
+	}
+}
+
+// Acquire takes a reference on the blob. When the blob is new, onCreate
+// stores its content before the row is inserted.
+func (r *blobRepository) Acquire(ctx context.Context, hash string, size int64, onCreate func() error) error {
+	const sql = `
+INSERT INTO blobs (hash, size, ref_count)
+	VALUES (@hash, @size, 1)
+ON CONFLICT (hash) DO UPDATE
+	SET ref_count = blobs.ref_count + 1
+RETURNING (xmax = 0) AS created;
+`
+	stored := onCreate == nil
+	for {
+		err := r.db.WithContext(ctx).Transaction(func(tx *gorm.DB) error {
+			var created bool
+			if err := tx.
+				Raw(sql, map[string]interface{}{"hash": hash, "size": size}).
+				Scan(&created).Error; err != nil {
+				return utils.DetermineSQLError(err, "acquire blob")
+			}
+			if created && !stored {
+				return errBlobNotStored
+			}
+			return nil
+		})
+		if err != errBlobNotStored {
+			return err
+		}
+		if err := onCreate(); err != nil {
+			return err
+		}
+		stored = true
+	}
+}
+
+func (r *blobRepository) Release(ctx context.Context, hash string, onLast func() error) error {
+	return r.db.WithContext(ctx).Transaction(func(tx *gorm.DB) error {
+		return releaseBlobRef(tx, hash, onLast)
+	})
+}
+
+func releaseBlobRef(tx *gorm.DB, hash string, onLast func() error) error {
+	var blob types.Blob
+	if err := tx.
+		Clauses(clause.Locking{Strength: "UPDATE"}).
+		First(&blob, "hash = ?", hash).Error; err != nil {
+		return utils.DetermineSQLError(err, "locking blob for release")
+	}
+
+	if blob.RefCount > 1 {
+		if err := tx.Model(&types.Blob{}).
+			Where("hash = ?", hash).
+			UpdateColumn("ref_count", gorm.Expr("ref_count - 1")).
+			Error; err != nil {
+			return utils.DetermineSQLError(err, "decrement blob ref_count")
+		}
+		return nil
+	}
+
+	if onLast != nil {
+		if err := onLast(); err != nil {
+			return err
+		}
+	}
+	if err := tx.
+		Where("hash = ?", hash).
+		Delete(&types.Blob{}).Error; err != nil {
+		return utils.DetermineSQLError(err, "delete blob")
+	}
+	return nil
+}
+
+// ListUnreferenced returns blob rows created before the given time that no
+// file version points at. Younger rows may belong to an upload that has
+// acquired its blob but not yet recorded the file.
+func (r *blobRepository) ListUnreferenced(ctx context.Context, before time.Time) ([]types.Blob, error) {
+	var out []types.Blob
+	if err := r.db.WithContext(ctx).
+		Where("created_at < ? AND NOT EXISTS (SELECT 1 FROM file_versions v WHERE v.physical_name = blobs.hash)", before).
+		Order("hash").
+		Find(&out).Error; err != nil {
+		return nil, utils.DetermineSQLError(err, "list unreferenced blobs")
+	}
+	return out, nil
+}
+
+// Forget deletes the blob row whatever its reference count. It is meant for
+// repairs, when the stored content is gone or no longer referenced.
+func (r *blobRepository) Forget(ctx context.Context, hash string) error {
+	if err := r.db.WithContext(ctx).
+		Where("hash = ?", hash).
+		Delete(&types.Blob{}).Error; err != nil {
+		return utils.DetermineSQLError(err, "forget blob")
+	}
+	return nil
+}
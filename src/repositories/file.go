@@ -2,21 +2,51 @@ package repositories
 
 import (
 	"context"
+	"strings"
+	"time"
 
 	"github.com/CustomCloudStorage/types"
 	"github.com/CustomCloudStorage/utils"
+	"github.com/google/uuid"
 	"gorm.io/gorm"
 )
 
 type FileRepository interface {
-	Create(ctx context.Context, file *types.File) error
+	Create(ctx context.Context, file *types.File, reservation *uuid.UUID, replace *int) error
 	GetByID(ctx context.Context, id int, userID int) (*types.File, error)
-	ListByUserID(ctx context.Context, userID int) ([]types.File, error)
-	UpdateName(ctx context.Context, id int, userID int, name string) error
-	UpdateFolder(ctx context.Context, id int, userID int, folderID int) error
+	GetByName(ctx context.Context, userID int, folderID *int, name, extension string) (*types.File, error)
+	ListByUserID(ctx context.Context, userID int, contentType string, page *types.PageQuery) ([]types.File, string, error)
+	ListByFolder(ctx context.Context, userID int, folderID *int) ([]types.File, error)
+	UpdateName(ctx context.Context, id int, userID int, name string, replace *int) error
+	Move(ctx context.Context, id int, userID int, folderID *int, name string, replace *int) error
 	ListFilesRecursive(ctx context.Context, userID, folderID int) ([]*types.FileWithPath, error)
+	Search(ctx context.Context, userID int, query *types.FileSearch) (*types.FileSearchResult, error)
+	SetMetadata(ctx context.Context, id int, physicalName string, metadata *types.FileMetadata) error
+	ListWithoutMetadata(ctx context.Context, contentTypes []string, afterID, limit int) ([]types.File, error)
 }
 
+var fileSortKeys = map[string]sortKey[types.File]{
+	"id":         {"id", "integer", func(f *types.File) string { return cursorInt(int64(f.ID)) }},
+	"name":       {"name", "text", func(f *types.File) string { return f.Name }},
+	"size":       {"size", "bigint", func(f *types.File) string { return cursorInt(f.Size) }},
+	"created_at": {"created_at", "timestamptz", func(f *types.File) string { return cursorTime(f.CreatedAt) }},
+	"updated_at": {"updated_at", "timestamptz", func(f *types.File) string { return cursorTime(f.UpdatedAt) }},
+}
+
+var fileSearchSorts = map[string]string{
+	"name":       "name",
+	"size":       "size",
+	"created_at": "created_at",
+	"updated_at": "updated_at",
+	"taken_at":   "file_metadata->>'taken_at'",
+}
+
+// metadataTimeFormat is how capture dates are kept in file_metadata: UTC and
+// whole seconds, so that they compare correctly as text.
+const metadataTimeFormat = "2006-01-02T15:04:05Z"
+
+var likeEscaper = strings.NewReplacer(`\`, `\\`, `%`, `\%`, `_`, `\_`)
+
 type fileRepository struct {
 	db *gorm.DB
 }
@@ -27,23 +57,78 @@ func NewFileRepository(db *gorm.DB) FileRepository {
 	}
 }
 
-func (r *fileRepository) Create(ctx context.Context, file *types.File) error {
-	if err := r.db.WithContext(ctx).Create(file).Error; err != nil {
+// Create records a new file. A non-nil reservation is settled for the file's
+// size in the same transaction. A non-nil replace is the live file whose name
+// is taken over; it goes to the trash in the same transaction too.
+func (r *fileRepository) Create(ctx context.Context, file *types.File, reservation *uuid.UUID, replace *int) error {
+	return r.db.WithContext(ctx).Transaction(func(tx *gorm.DB) error {
+		if err := trashReplacedFile(tx, file.UserID, replace); err != nil {
+			return err
+		}
+		if err := createFile(tx, file); err != nil {
+			return err
+		}
+		if reservation != nil {
+			return settleReservation(tx, *reservation, file.Size)
+		}
+		return nil
+	})
+}
+
+func trashReplacedFile(tx *gorm.DB, userID int, replace *int) error {
+	if replace == nil {
+		return nil
+	}
+	return softDeleteFile(tx, userID, *replace, time.Now())
+}
+
+func createFile(tx *gorm.DB, file *types.File) error {
+	file.Version = 1
+	if err := tx.Create(file).Error; err != nil {
 		return utils.DetermineSQLError(err, "create file")
 	}
+	version := &types.FileVersion{
+		FileID:       file.ID,
+		Version:      file.Version,
+		Size:         file.Size,
+		PhysicalName: file.PhysicalName,
+		SHA256:       file.SHA256,
+		ContentType:  file.ContentType,
+	}
+	if err := tx.Create(version).Error; err != nil {
+		return utils.DetermineSQLError(err, "create file version")
+	}
 	return nil
 }
 
 func (r *fileRepository) GetByID(ctx context.Context, id int, userID int) (*types.File, error) {
 	var file types.File
 	if err := r.db.WithContext(ctx).
-		Where("id = ? AND user_id = ?", id, userID).
+		Where("id = @id AND deleted_at IS NULL AND (user_id = @user OR id IN "+grantedFileIDs+" OR folder_id IN "+grantedFolderIDs+")",
+			map[string]interface{}{"id": id, "user": userID},
+		).
 		First(&file).Error; err != nil {
 		return nil, utils.DetermineSQLError(err, "get file")
 	}
 	return &file, nil
 }
 
+func (r *fileRepository) GetByName(ctx context.Context, userID int, folderID *int, name, extension string) (*types.File, error) {
+	query := r.db.WithContext(ctx).
+		Where("user_id = ? AND name = ? AND extension = ? AND deleted_at IS NULL", userID, name, extension)
+	if folderID == nil {
+		query = query.Where("folder_id IS NULL")
+	} else {
+		query = query.Where("folder_id = ?", *folderID)
+	}
+
+	var file types.File
+	if err := query.First(&file).Error; err != nil {
+		return nil, utils.DetermineSQLError(err, "get file by name")
+	}
+	return &file, nil
+}
+
 func (r *fileRepository) Update(ctx context.Context, file *types.File) error {
 	if err := r.db.Save(file).Error; err != nil {
 		return utils.DetermineSQLError(err, "update file")
@@ -51,56 +136,83 @@ func (r *fileRepository) Update(ctx context.Context, file *types.File) error {
 	return nil
 }
 
-func (r *fileRepository) ListByUserID(ctx context.Context, userID int) ([]types.File, error) {
+// ListByUserID pages through the user's files, optionally only those matching
+// contentType (see whereContentType).
+func (r *fileRepository) ListByUserID(ctx context.Context, userID int, contentType string, page *types.PageQuery) ([]types.File, string, error) {
+	db := r.db.WithContext(ctx).
+		Where("user_id = ? AND deleted_at IS NULL", userID)
+	if contentType != "" {
+		db = whereContentType(db, contentType)
+	}
+	return findPage(db, page, "id", fileSortKeys, func(f *types.File) int { return f.ID }, "list files")
+}
+
+func (r *fileRepository) ListByFolder(ctx context.Context, userID int, folderID *int) ([]types.File, error) {
+	query := r.db.WithContext(ctx).
+		Where("user_id = ? AND deleted_at IS NULL", userID)
+	if folderID == nil {
+		query = query.Where("folder_id IS NULL")
+	} else {
+		query = query.Where("folder_id = ?", *folderID)
+	}
+
 	var files []types.File
-	if err := r.db.WithContext(ctx).
-		Where("user_id = ?", userID).
-		Find(&files).Error; err != nil {
-		return nil, utils.DetermineSQLError(err, "list files")
+	if err := query.Order("name").Find(&files).Error; err != nil {
+		return nil, utils.DetermineSQLError(err, "list folder files")
 	}
 	return files, nil
 }
 
-func (r *fileRepository) UpdateName(ctx context.Context, id int, userID int, name string) error {
-	if err := r.db.WithContext(ctx).
-		Model(&types.File{}).
-		Where("id = ? AND user_id = ?", id, userID).
-		Update("name", name).Error; err != nil {
-		return utils.DetermineSQLError(err, "update file name")
-	}
-	return nil
+func (r *fileRepository) UpdateName(ctx context.Context, id int, userID int, name string, replace *int) error {
+	return r.db.WithContext(ctx).Transaction(func(tx *gorm.DB) error {
+		if err := trashReplacedFile(tx, userID, replace); err != nil {
+			return err
+		}
+		if err := tx.
+			Model(&types.File{}).
+			Where("id = ? AND user_id = ?", id, userID).
+			Update("name", name).Error; err != nil {
+			return utils.DetermineSQLError(err, "update file name")
+		}
+		return nil
+	})
 }
 
-func (r *fileRepository) UpdateFolder(ctx context.Context, id int, userID int, folderID int) error {
-	if err := r.db.WithContext(ctx).
-		Model(&types.File{}).
-		Where("id = & AND user_id = ?", id, userID).
-		Update("folder_id", folderID).Error; err != nil {
-		return utils.DetermineSQLError(err, "update file folder")
-	}
-	return nil
+func (r *fileRepository) Move(ctx context.Context, id int, userID int, folderID *int, name string, replace *int) error {
+	return r.db.WithContext(ctx).Transaction(func(tx *gorm.DB) error {
+		if err := trashReplacedFile(tx, userID, replace); err != nil {
+			return err
+		}
+		if err := tx.
+			Model(&types.File{}).
+			Where("id = ? AND user_id = ?", id, userID).
+			Updates(map[string]interface{}{"folder_id": folderID, "name": name}).Error; err != nil {
+			return utils.DetermineSQLError(err, "move file")
+		}
+		return nil
+	})
 }
 
 func (r *fileRepository) ListFilesRecursive(ctx context.Context, userID, folderID int) ([]*types.FileWithPath, error) {
 	const sql = `
 WITH RECURSIVE folders_cte AS (
-    SELECT id, name, parent_id, name AS path
+    SELECT id, user_id, name, parent_id, name AS path
 	FROM folders
-	WHERE user_id = @user AND id = @root
+	WHERE id = @root AND deleted_at IS NULL AND (user_id = @user OR id IN ` + grantedFolderIDs + `)
 
 UNION ALL
 
-    SELECT f.id, f.name, f.parent_id, folders_cte.path || '/' || f.name
+    SELECT f.id, f.user_id, f.name, f.parent_id, folders_cte.path || '/' || f.name
 	FROM folders f
 	JOIN folders_cte ON f.parent_id = folders_cte.id
-	WHERE f.user_id = @user
+	WHERE f.user_id = folders_cte.user_id AND f.deleted_at IS NULL
 )
 SELECT
     fi.physical_name,
     folders_cte.path || '/' || fi.name || fi.extension AS relative_path
 	FROM folders_cte
 	JOIN files fi ON fi.folder_id = folders_cte.id
-	WHERE fi.user_id = @user;
+	WHERE fi.user_id = folders_cte.user_id AND fi.deleted_at IS NULL;
 `
 	var out []*types.FileWithPath
 	if err := r.db.WithContext(ctx).
@@ -112,3 +224,143 @@ SELECT
 	}
 	return out, nil
 }
+
+func (r *fileRepository) Search(ctx context.Context, userID int, query *types.FileSearch) (*types.FileSearchResult, error) {
+	const subtree = `(
+WITH RECURSIVE subtree AS (
+    SELECT id FROM folders WHERE user_id = @user AND id = @root
+	UNION
+    SELECT f.id FROM folders f JOIN subtree s ON f.parent_id = s.id WHERE f.user_id = @user
+)
+SELECT id FROM subtree)`
+
+	db := r.db.WithContext(ctx).
+		Model(&types.File{}).
+		Where("user_id = ?", userID)
+	if !query.IncludeDeleted {
+		db = db.Where("deleted_at IS NULL")
+	}
+	if query.Name != "" {
+		db = db.Where("name ILIKE ?", "%"+likeEscaper.Replace(query.Name)+"%")
+	}
+	if query.Extension != "" {
+		db = db.Where("lower(extension) = lower(?)", query.Extension)
+	}
+	if query.ContentType != "" {
+		db = whereContentType(db, query.ContentType)
+	}
+	if query.MinSize != nil {
+		db = db.Where("size >= ?", *query.MinSize)
+	}
+	if query.MaxSize != nil {
+		db = db.Where("size <= ?", *query.MaxSize)
+	}
+	if query.CreatedAfter != nil {
+		db = db.Where("created_at >= ?", *query.CreatedAfter)
+	}
+	if query.CreatedBefore != nil {
+		db = db.Where("created_at < ?", *query.CreatedBefore)
+	}
+	if query.UpdatedAfter != nil {
+		db = db.Where("updated_at >= ?", *query.UpdatedAfter)
+	}
+	if query.UpdatedBefore != nil {
+		db = db.Where("updated_at < ?", *query.UpdatedBefore)
+	}
+	if query.TakenAfter != nil {
+		db = db.Where("file_metadata->>'taken_at' >= ?", query.TakenAfter.UTC().Format(metadataTimeFormat))
+	}
+	if query.TakenBefore != nil {
+		db = db.Where("file_metadata->>'taken_at' < ?", query.TakenBefore.UTC().Format(metadataTimeFormat))
+	}
+	if query.Camera != "" {
+		db = db.Where("concat_ws(' ', file_metadata->>'camera_make', file_metadata->>'camera_model') ILIKE ?", "%"+likeEscaper.Replace(query.Camera)+"%")
+	}
+	if query.Title != "" {
+		db = db.Where("file_metadata->>'title' ILIKE ?", "%"+likeEscaper.Replace(query.Title)+"%")
+	}
+	if query.HasLocation {
+		db = db.Where("file_metadata->'location' IS NOT NULL")
+	}
+	if query.FolderID != nil {
+		db = db.Where("folder_id IN "+subtree, map[string]interface{}{"user": userID, "root": *query.FolderID})
+	}
+
+	var total int64
+	if err := db.Session(&gorm.Session{}).Count(&total).Error; err != nil {
+		return nil, utils.DetermineSQLError(err, "count search results")
+	}
+
+	column, ok := fileSearchSorts[query.Sort]
+	if !ok {
+		column = "name"
+	}
+	order := "ASC"
+	if strings.EqualFold(query.Order, "desc") {
+		order = "DESC"
+	}
+
+	files := []types.File{}
+	if err := db.Session(&gorm.Session{}).
+		Order(column + " " + order).
+		Order("id " + order).
+		Limit(query.Limit).
+		Offset(query.Offset).
+		Find(&files).Error; err != nil {
+		return nil, utils.DetermineSQLError(err, "search files")
+	}
+
+	return &types.FileSearchResult{
+		Files:  files,
+		Total:  total,
+		Limit:  query.Limit,
+		Offset: query.Offset,
+	}, nil
+}
+
+// SetMetadata records extracted metadata, unless the file got new content in
+// the meantime.
+func (r *fileRepository) SetMetadata(ctx context.Context, id int, physicalName string, metadata *types.FileMetadata) error {
+	if err := r.db.WithContext(ctx).
+		Model(&types.File{}).
+		Where("id = ? AND physical_name = ?", id, physicalName).
+		UpdateColumn("file_metadata", metadata).Error; err != nil {
+		return utils.DetermineSQLError(err, "set file metadata")
+	}
+	return nil
+}
+
+// ListWithoutMetadata returns live files after afterID that have not been
+// through the metadata extractor yet and match one of the content type prefixes.
+func (r *fileRepository) ListWithoutMetadata(ctx context.Context, contentTypes []string, afterID, limit int) ([]types.File, error) {
+	if len(contentTypes) == 0 {
+		return nil, nil
+	}
+	conds := make([]string, len(contentTypes))
+	args := make([]interface{}, len(contentTypes))
+	for i, prefix := range contentTypes {
+		conds[i] = "content_type LIKE ?"
+		args[i] = likeEscaper.Replace(prefix) + "%"
+	}
+
+	var files []types.File
+	if err := r.db.WithContext(ctx).
+		Where("id > ? AND file_metadata IS NULL AND deleted_at IS NULL", afterID).
+		Where("("+strings.Join(conds, " OR ")+")", args...).
+		Order("id").
+		Limit(limit).
+		Find(&files).Error; err != nil {
+		return nil, utils.DetermineSQLError(err, "list files without metadata")
+	}
+	return files, nil
+}
+
+// whereContentType matches a media type such as "image/png", ignoring its
+// parameters, or a whole top-level type such as "image/*".
+func whereContentType(db *gorm.DB, contentType string) *gorm.DB {
+	contentType = strings.ToLower(contentType)
+	if prefix, ok := strings.CutSuffix(contentType, "/*"); ok {
+		return db.Where("content_type LIKE ?", likeEscaper.Replace(prefix)+"/%")
+	}
+	return db.Where("(content_type = ? OR content_type LIKE ?)", contentType, likeEscaper.Replace(contentType)+";%")
+}
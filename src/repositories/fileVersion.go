@@ -0,0 +1,202 @@
+package repositories
+
+import (
+	"context"
+	"time"
+
+	"github.com/CustomCloudStorage/types"
+	"github.com/CustomCloudStorage/utils"
+	"github.com/google/uuid"
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+type FileVersionRepository interface {
+	AddVersion(ctx context.Context, fileID int, content types.FileVersion, reservation *uuid.UUID) (*types.File, error)
+	GetVersion(ctx context.Context, fileID, version int) (*types.FileVersion, error)
+	ListByFile(ctx context.Context, fileID int) ([]types.FileVersion, error)
+	ListExpired(ctx context.Context, fileID, keepLast int, before time.Time) ([]types.FileVersion, error)
+	Delete(ctx context.Context, id int) (bool, error)
+	ListPhysicalNames(ctx context.Context) ([]string, error)
+	ListByPhysicalNames(ctx context.Context, names []string) ([]types.FileVersion, error)
+}
+
+type fileVersionRepository struct {
+	db *gorm.DB
+}
+
+func NewFileVersionRepository(db *gorm.DB) FileVersionRepository {
+	return &fileVersionRepository{
+		db: db,
+	}
+}
+
+// AddVersion makes the given content the file's current version. Only the
+// content fields of the version are used. A non-nil reservation is settled for
+// its size in the same transaction.
+func (r *fileVersionRepository) AddVersion(ctx context.Context, fileID int, content types.FileVersion, reservation *uuid.UUID) (*types.File, error) {
+	var file types.File
+	err := r.db.WithContext(ctx).Transaction(func(tx *gorm.DB) error {
+		if err := tx.
+			Clauses(clause.Locking{Strength: "UPDATE"}).
+			First(&file, "id = ?", fileID).Error; err != nil {
+			return utils.DetermineSQLError(err, "locking file for new version")
+		}
+
+		version := &types.FileVersion{
+			FileID:       file.ID,
+			Version:      file.Version + 1,
+			Size:         content.Size,
+			PhysicalName: content.PhysicalName,
+			SHA256:       content.SHA256,
+			ContentType:  content.ContentType,
+		}
+		if err := tx.Create(version).Error; err != nil {
+			return utils.DetermineSQLError(err, "create file version")
+		}
+
+		file.Version = version.Version
+		file.Size = version.Size
+		file.PhysicalName = version.PhysicalName
+		file.SHA256 = version.SHA256
+		file.ContentType = version.ContentType
+		file.Metadata = nil
+		file.UpdatedAt = time.Now()
+		if err := tx.Save(&file).Error; err != nil {
+			return utils.DetermineSQLError(err, "update file to new version")
+		}
+		if reservation != nil {
+			return settleReservation(tx, *reservation, version.Size)
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return &file, nil
+}
+
+func (r *fileVersionRepository) GetVersion(ctx context.Context, fileID, version int) (*types.FileVersion, error) {
+	var out types.FileVersion
+	if err := r.db.WithContext(ctx).
+		Where("file_id = ? AND version = ?", fileID, version).
+		First(&out).Error; err != nil {
+		return nil, utils.DetermineSQLError(err, "get file version")
+	}
+	return &out, nil
+}
+
+func (r *fileVersionRepository) ListByFile(ctx context.Context, fileID int) ([]types.FileVersion, error) {
+	var out []types.FileVersion
+	if err := r.db.WithContext(ctx).
+		Where("file_id = ?", fileID).
+		Order("version DESC").
+		Find(&out).Error; err != nil {
+		return nil, utils.DetermineSQLError(err, "list file versions")
+	}
+	return out, nil
+}
+
+func (r *fileVersionRepository) ListExpired(ctx context.Context, fileID, keepLast int, before time.Time) ([]types.FileVersion, error) {
+	const sql = `
+SELECT id, file_id, version, size, physical_name, sha256, created_at, user_id
+FROM (
+    SELECT v.*, f.user_id, f.version AS current_version,
+        ROW_NUMBER() OVER (PARTITION BY v.file_id ORDER BY v.version DESC) AS rn
+	FROM file_versions v
+	JOIN files f ON f.id = v.file_id
+	WHERE @file = 0 OR v.file_id = @file
+) ranked
+WHERE version <> current_version
+	AND (@keep > 0 OR @by_age)
+	AND (@keep <= 0 OR rn > @keep)
+	AND (NOT @by_age OR created_at < @before);
+`
+	var out []types.FileVersion
+	if err := r.db.WithContext(ctx).
+		Raw(sql, map[string]interface{}{
+			"file":   fileID,
+			"keep":   keepLast,
+			"by_age": !before.IsZero(),
+			"before": before,
+		}).
+		Scan(&out).Error; err != nil {
+		return nil, utils.DetermineSQLError(err, "list expired file versions")
+	}
+	return out, nil
+}
+
+// Delete removes a version row, refunds its size to the file owner and drops
+// its blob reference in the same transaction. It reports whether that was the
+// blob's last reference, leaving the stored content for the caller to delete.
+func (r *fileVersionRepository) Delete(ctx context.Context, id int) (bool, error) {
+	orphaned := false
+	err := r.db.WithContext(ctx).Transaction(func(tx *gorm.DB) error {
+		var owner struct {
+			UserID int
+			Size   int64
+			Hash   *string
+		}
+		if err := tx.Raw(`
+SELECT f.user_id, v.size, b.hash
+	FROM file_versions v
+	JOIN files f ON f.id = v.file_id
+	LEFT JOIN blobs b ON b.hash = v.physical_name
+	WHERE v.id = ?`, id).
+			Scan(&owner).Error; err != nil {
+			return utils.DetermineSQLError(err, "get file version owner")
+		}
+
+		res := tx.Where("id = ?", id).Delete(&types.FileVersion{})
+		if res.Error != nil {
+			return utils.DetermineSQLError(res.Error, "delete file version")
+		}
+		if res.RowsAffected == 0 {
+			return nil
+		}
+		if err := adjustUsedStorage(tx, owner.UserID, -owner.Size); err != nil {
+			return err
+		}
+		if owner.Hash == nil {
+			return nil
+		}
+		return releaseBlobRef(tx, *owner.Hash, func() error {
+			orphaned = true
+			return nil
+		})
+	})
+	if err != nil {
+		return false, err
+	}
+	return orphaned, nil
+}
+
+// ListPhysicalNames returns every blob key referenced by a file version.
+func (r *fileVersionRepository) ListPhysicalNames(ctx context.Context) ([]string, error) {
+	var out []string
+	if err := r.db.WithContext(ctx).
+		Model(&types.FileVersion{}).
+		Distinct("physical_name").
+		Order("physical_name").
+		Pluck("physical_name", &out).Error; err != nil {
+		return nil, utils.DetermineSQLError(err, "list version physical names")
+	}
+	return out, nil
+}
+
+func (r *fileVersionRepository) ListByPhysicalNames(ctx context.Context, names []string) ([]types.FileVersion, error) {
+	var out []types.FileVersion
+	if len(names) == 0 {
+		return out, nil
+	}
+	if err := r.db.WithContext(ctx).
+		Table("file_versions v").
+		Select("v.*, f.user_id").
+		Joins("JOIN files f ON f.id = v.file_id").
+		Where("v.physical_name IN ?", names).
+		Order("v.file_id, v.version").
+		Scan(&out).Error; err != nil {
+		return nil, utils.DetermineSQLError(err, "list versions by physical name")
+	}
+	return out, nil
+}
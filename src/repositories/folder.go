@@ -2,19 +2,47 @@ package repositories
 
 import (
 	"context"
+	"fmt"
+	"time"
 
 	"github.com/CustomCloudStorage/types"
 	"github.com/CustomCloudStorage/utils"
+	"github.com/google/uuid"
 	"gorm.io/gorm"
 )
 
 type FolderRepository interface {
-	Create(ctx context.Context, file *types.Folder) error
+	Create(ctx context.Context, folder *types.Folder, replace *int) error
 	GetByID(ctx context.Context, id int, userID int) (*types.Folder, error)
-	Update(ctx context.Context, folder *types.Folder) error
-	ListByUserID(ctx context.Context, userID int) ([]types.Folder, error)
+	Update(ctx context.Context, folder *types.Folder, replace *int) error
+	ListByUserID(ctx context.Context, userID int, page *types.PageQuery) ([]types.Folder, string, error)
+	ListChildren(ctx context.Context, userID int, parentID *int) ([]types.Folder, error)
+	IsInSubtree(ctx context.Context, userID, rootID, folderID int) (bool, error)
+	ListChildrenWithStats(ctx context.Context, userID int, parentID *int) ([]types.FolderEntry, error)
+	ListAncestors(ctx context.Context, userID, folderID int) ([]types.Breadcrumb, error)
+	GetByName(ctx context.Context, userID int, parentID *int, name string) (*types.Folder, error)
+	TreeSize(ctx context.Context, userID, folderID int) (int64, error)
+	CopyTree(ctx context.Context, userID, folderID int, parentID *int, name string, reservation uuid.UUID, replace *int) (*types.Folder, error)
 }
 
+var folderSortKeys = map[string]sortKey[types.Folder]{
+	"id":         {"id", "integer", func(f *types.Folder) string { return cursorInt(int64(f.ID)) }},
+	"name":       {"name", "text", func(f *types.Folder) string { return f.Name }},
+	"created_at": {"created_at", "timestamptz", func(f *types.Folder) string { return cursorTime(f.CreatedAt) }},
+	"updated_at": {"updated_at", "timestamptz", func(f *types.Folder) string { return cursorTime(f.UpdatedAt) }},
+}
+
+func trashReplacedFolder(tx *gorm.DB, userID int, replace *int) error {
+	if replace == nil {
+		return nil
+	}
+	now := time.Now()
+	return setFolderTreeDeletedAt(tx, userID, *replace, &now, "trash replaced folder")
+}
+
+// maxFolderDepth bounds upward walks so a corrupted parent chain cannot loop forever.
+const maxFolderDepth = 1000
+
 type folderRepository struct {
 	db *gorm.DB
 }
@@ -25,36 +53,299 @@ func NewFolderRepository(db *gorm.DB) FolderRepository {
 	}
 }
 
-func (r *folderRepository) Create(ctx context.Context, folder *types.Folder) error {
-	if err := r.db.WithContext(ctx).Create(folder).Error; err != nil {
-		return utils.DetermineSQLError(err, "create folder")
-	}
-	return nil
+// Create records a new folder. A non-nil replace is the live folder whose
+// name is taken over; it goes to the trash, with its contents, in the same
+// transaction.
+func (r *folderRepository) Create(ctx context.Context, folder *types.Folder, replace *int) error {
+	return r.db.WithContext(ctx).Transaction(func(tx *gorm.DB) error {
+		if err := trashReplacedFolder(tx, folder.UserID, replace); err != nil {
+			return err
+		}
+		if err := tx.Create(folder).Error; err != nil {
+			return utils.DetermineSQLError(err, "create folder")
+		}
+		return nil
+	})
 }
 
 func (r *folderRepository) GetByID(ctx context.Context, id int, userID int) (*types.Folder, error) {
 	var folder types.Folder
 	if err := r.db.WithContext(ctx).
-		Where("id = ? AND user_id = ?", id, userID).
+		Where("id = @id AND deleted_at IS NULL AND (user_id = @user OR id IN "+grantedFolderIDs+")",
+			map[string]interface{}{"id": id, "user": userID},
+		).
 		First(&folder).Error; err != nil {
 		return nil, utils.DetermineSQLError(err, "get folder")
 	}
 	return &folder, nil
 }
 
-func (r *folderRepository) Update(ctx context.Context, folder *types.Folder) error {
-	if err := r.db.Save(folder).Error; err != nil {
-		return utils.DetermineSQLError(err, "update folder")
-	}
-	return nil
+func (r *folderRepository) Update(ctx context.Context, folder *types.Folder, replace *int) error {
+	return r.db.WithContext(ctx).Transaction(func(tx *gorm.DB) error {
+		if err := trashReplacedFolder(tx, folder.UserID, replace); err != nil {
+			return err
+		}
+		if err := tx.Save(folder).Error; err != nil {
+			return utils.DetermineSQLError(err, "update folder")
+		}
+		return nil
+	})
+}
+
+func (r *folderRepository) ListByUserID(ctx context.Context, userID int, page *types.PageQuery) ([]types.Folder, string, error) {
+	db := r.db.WithContext(ctx).
+		Where("user_id = ? AND deleted_at IS NULL", userID)
+	return findPage(db, page, "id", folderSortKeys, func(f *types.Folder) int { return f.ID }, "list folders")
 }
 
-func (r *folderRepository) ListByUserID(ctx context.Context, userID int) ([]types.Folder, error) {
+func (r *folderRepository) ListChildren(ctx context.Context, userID int, parentID *int) ([]types.Folder, error) {
+	query := r.db.WithContext(ctx).
+		Where("user_id = ? AND deleted_at IS NULL", userID)
+	if parentID == nil {
+		query = query.Where("parent_id IS NULL")
+	} else {
+		query = query.Where("parent_id = ?", *parentID)
+	}
+
 	var folders []types.Folder
-	if err := r.db.WithContext(ctx).
-		Where("user_id = ?", userID).
-		Find(&folders).Error; err != nil {
-		return nil, utils.DetermineSQLError(err, "list folders")
+	if err := query.Order("name").Find(&folders).Error; err != nil {
+		return nil, utils.DetermineSQLError(err, "list child folders")
 	}
 	return folders, nil
 }
+
+func (r *folderRepository) IsInSubtree(ctx context.Context, userID, rootID, folderID int) (bool, error) {
+	const sql = `
+WITH RECURSIVE cte AS (
+    SELECT id FROM folders WHERE user_id = @user AND id = @root
+	UNION
+    SELECT f.id FROM folders f JOIN cte ON f.parent_id = cte.id WHERE f.user_id = @user
+)
+SELECT EXISTS (SELECT 1 FROM cte WHERE id = @target);
+`
+	var found bool
+	if err := r.db.WithContext(ctx).
+		Raw(sql, map[string]interface{}{"user": userID, "root": rootID, "target": folderID}).
+		Scan(&found).Error; err != nil {
+		return false, utils.DetermineSQLError(err, "check folder subtree")
+	}
+	return found, nil
+}
+
+func (r *folderRepository) ListChildrenWithStats(ctx context.Context, userID int, parentID *int) ([]types.FolderEntry, error) {
+	parent := "parent_id IS NULL"
+	if parentID != nil {
+		parent = "parent_id = @parent"
+	}
+	sql := fmt.Sprintf(`
+WITH RECURSIVE tree AS (
+    SELECT id AS root_id, id
+	FROM folders
+	WHERE user_id = @user AND %s AND deleted_at IS NULL
+
+UNION
+
+    SELECT tree.root_id, f.id
+	FROM folders f
+	JOIN tree ON f.parent_id = tree.id
+	WHERE f.user_id = @user AND f.deleted_at IS NULL
+),
+file_stats AS (
+    SELECT tree.root_id, COUNT(fi.id) AS files, SUM(fi.size) AS size
+	FROM tree
+	JOIN files fi ON fi.folder_id = tree.id
+	WHERE fi.user_id = @user AND fi.deleted_at IS NULL
+	GROUP BY tree.root_id
+),
+folder_stats AS (
+    SELECT root_id, COUNT(*) - 1 AS folders
+	FROM tree
+	GROUP BY root_id
+)
+SELECT
+    c.*,
+    COALESCE(fs.size, 0) AS size,
+    COALESCE(fs.files, 0) + COALESCE(ds.folders, 0) AS item_count
+	FROM folders c
+	LEFT JOIN file_stats fs ON fs.root_id = c.id
+	LEFT JOIN folder_stats ds ON ds.root_id = c.id
+	WHERE c.user_id = @user AND c.%s AND c.deleted_at IS NULL
+	ORDER BY c.name, c.id;
+`, parent, parent)
+
+	out := []types.FolderEntry{}
+	if err := r.db.WithContext(ctx).
+		Raw(sql, map[string]interface{}{"user": userID, "parent": parentID}).
+		Scan(&out).Error; err != nil {
+		return nil, utils.DetermineSQLError(err, "list child folders with stats")
+	}
+	return out, nil
+}
+
+func (r *folderRepository) ListAncestors(ctx context.Context, userID, folderID int) ([]types.Breadcrumb, error) {
+	const sql = `
+WITH RECURSIVE ancestors AS (
+    SELECT id, name, parent_id, 0 AS depth
+	FROM folders
+	WHERE id = @folder
+
+UNION ALL
+
+    SELECT p.id, p.name, p.parent_id, a.depth + 1
+	FROM folders p
+	JOIN ancestors a ON p.id = a.parent_id
+	WHERE a.depth < @maxDepth
+	AND (p.user_id = @user OR p.id IN ` + grantedFolderIDs + `)
+)
+SELECT id, name FROM ancestors ORDER BY depth DESC;
+`
+	out := []types.Breadcrumb{}
+	if err := r.db.WithContext(ctx).
+		Raw(sql, map[string]interface{}{"user": userID, "folder": folderID, "maxDepth": maxFolderDepth}).
+		Scan(&out).Error; err != nil {
+		return nil, utils.DetermineSQLError(err, "list folder ancestors")
+	}
+	return out, nil
+}
+
+func (r *folderRepository) GetByName(ctx context.Context, userID int, parentID *int, name string) (*types.Folder, error) {
+	query := r.db.WithContext(ctx).
+		Where("user_id = ? AND name = ? AND deleted_at IS NULL", userID, name)
+	if parentID == nil {
+		query = query.Where("parent_id IS NULL")
+	} else {
+		query = query.Where("parent_id = ?", *parentID)
+	}
+
+	var folder types.Folder
+	if err := query.First(&folder).Error; err != nil {
+		return nil, utils.DetermineSQLError(err, "get folder by name")
+	}
+	return &folder, nil
+}
+
+// TreeSize sums the size of the live files in a folder subtree.
+func (r *folderRepository) TreeSize(ctx context.Context, userID, folderID int) (int64, error) {
+	const sql = `
+WITH RECURSIVE tree AS (
+    SELECT id, 0 AS depth FROM folders WHERE user_id = @user AND id = @root AND deleted_at IS NULL
+	UNION ALL
+    SELECT f.id, tree.depth + 1
+	FROM folders f
+	JOIN tree ON f.parent_id = tree.id
+	WHERE f.user_id = @user AND f.deleted_at IS NULL AND tree.depth < @maxDepth
+)
+SELECT COALESCE(SUM(fi.size), 0)
+	FROM files fi
+	JOIN tree ON fi.folder_id = tree.id
+	WHERE fi.user_id = @user AND fi.deleted_at IS NULL;
+`
+	var size int64
+	if err := r.db.WithContext(ctx).
+		Raw(sql, map[string]interface{}{"user": userID, "root": folderID, "maxDepth": maxFolderDepth}).
+		Row().
+		Scan(&size); err != nil {
+		return 0, utils.DetermineSQLError(err, "sum folder tree")
+	}
+	return size, nil
+}
+
+// CopyTree duplicates the live part of a folder subtree under parentID in a
+// single transaction. Copied files share blobs with the originals, so only the
+// blob reference counts grow. The reservation is settled for the size of the
+// copied files and replace, when set, goes to the trash in the same
+// transaction.
+func (r *folderRepository) CopyTree(ctx context.Context, userID, folderID int, parentID *int, name string, reservation uuid.UUID, replace *int) (*types.Folder, error) {
+	const sql = `
+WITH RECURSIVE tree AS (
+    SELECT id, 0 AS depth FROM folders WHERE user_id = @user AND id = @root AND deleted_at IS NULL
+	UNION ALL
+    SELECT f.id, tree.depth + 1
+	FROM folders f
+	JOIN tree ON f.parent_id = tree.id
+	WHERE f.user_id = @user AND f.deleted_at IS NULL AND tree.depth < @maxDepth
+)
+SELECT folders.*
+	FROM folders
+	JOIN tree ON tree.id = folders.id
+	ORDER BY tree.depth, folders.id;
+`
+	var root types.Folder
+	err := r.db.WithContext(ctx).Transaction(func(tx *gorm.DB) error {
+		var folders []types.Folder
+		if err := tx.Raw(sql, map[string]interface{}{"user": userID, "root": folderID, "maxDepth": maxFolderDepth}).
+			Scan(&folders).Error; err != nil {
+			return utils.DetermineSQLError(err, "list folder tree")
+		}
+		if len(folders) == 0 {
+			return utils.ErrNotFound.New("folder %d not found", folderID)
+		}
+
+		ids := make([]int, len(folders))
+		for i, f := range folders {
+			ids[i] = f.ID
+		}
+		var files []types.File
+		if err := tx.
+			Where("user_id = ? AND deleted_at IS NULL AND folder_id IN ?", userID, ids).
+			Find(&files).Error; err != nil {
+			return utils.DetermineSQLError(err, "list folder tree files")
+		}
+
+		var size int64
+		for _, f := range files {
+			size += f.Size
+		}
+		if err := settleReservation(tx, reservation, size); err != nil {
+			return err
+		}
+		if err := trashReplacedFolder(tx, userID, replace); err != nil {
+			return err
+		}
+
+		mapping := make(map[int]int, len(folders))
+		for i, f := range folders {
+			clone := types.Folder{UserID: userID, Name: f.Name}
+			if i == 0 {
+				clone.Name = name
+				clone.ParentID = parentID
+			} else {
+				parent := mapping[*f.ParentID]
+				clone.ParentID = &parent
+			}
+			if err := tx.Create(&clone).Error; err != nil {
+				return utils.DetermineSQLError(err, "copy folder")
+			}
+			mapping[f.ID] = clone.ID
+			if i == 0 {
+				root = clone
+			}
+		}
+
+		for _, f := range files {
+			folder := mapping[*f.FolderID]
+			clone := &types.File{
+				UserID:       userID,
+				FolderID:     &folder,
+				Name:         f.Name,
+				Extension:    f.Extension,
+				Size:         f.Size,
+				PhysicalName: f.PhysicalName,
+				SHA256:       f.SHA256,
+				ContentType:  f.ContentType,
+				Metadata:     f.Metadata,
+			}
+			if err := createFile(tx, clone); err != nil {
+				return err
+			}
+			if err := tx.Exec("UPDATE blobs SET ref_count = ref_count + 1 WHERE hash = ?", f.PhysicalName).Error; err != nil {
+				return utils.DetermineSQLError(err, "acquire blob")
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return &root, nil
+}
@@ -0,0 +1,246 @@
+package repositories
+
+import (
+	"context"
+	"time"
+
+	"github.com/CustomCloudStorage/types"
+	"github.com/CustomCloudStorage/utils"
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+// JobRepository stores the background job queue. Jobs are claimed with
+// SELECT ... FOR UPDATE SKIP LOCKED, so each job runs on one replica at a time.
+type JobRepository interface {
+	Enqueue(ctx context.Context, job *types.Job) (bool, error)
+	Claim(ctx context.Context, kinds []string, lease time.Duration) (*types.Job, error)
+	Extend(ctx context.Context, id int, lease time.Duration) error
+	Complete(ctx context.Context, id int) error
+	Fail(ctx context.Context, id int, message string, retryAt *time.Time) error
+	RequeueExpired(ctx context.Context) (int64, error)
+	DeleteFinished(ctx context.Context, before time.Time) (int64, error)
+	GetByID(ctx context.Context, id int) (*types.Job, error)
+	List(ctx context.Context, status, kind string, page *types.PageQuery) ([]types.Job, string, error)
+	Count(ctx context.Context) ([]types.JobCount, error)
+	Retry(ctx context.Context, id int) error
+	Cancel(ctx context.Context, id int) error
+	HasLive(ctx context.Context, kind string, match types.RawJSON) (bool, error)
+}
+
+var jobSortKeys = map[string]sortKey[types.Job]{
+	"id":         {"id", "bigint", func(j *types.Job) string { return cursorInt(int64(j.ID)) }},
+	"run_at":     {"run_at", "timestamptz", func(j *types.Job) string { return cursorTime(j.RunAt) }},
+	"updated_at": {"updated_at", "timestamptz", func(j *types.Job) string { return cursorTime(j.UpdatedAt) }},
+}
+
+type jobRepository struct {
+	db *gorm.DB
+}
+
+func NewJobRepository(db *gorm.DB) JobRepository {
+	return &jobRepository{
+		db: db,
+	}
+}
+
+// Enqueue inserts the job. A job whose unique key is already taken is not
+// inserted, and Enqueue reports false.
+func (r *jobRepository) Enqueue(ctx context.Context, job *types.Job) (bool, error) {
+	res := r.db.WithContext(ctx).
+		Clauses(clause.OnConflict{DoNothing: true}).
+		Create(job)
+	if res.Error != nil {
+		return false, utils.DetermineSQLError(res.Error, "enqueue job")
+	}
+	return res.RowsAffected > 0, nil
+}
+
+// Claim takes the oldest due job of one of the given kinds and marks it
+// running until now+lease. It returns nil when no job is due.
+func (r *jobRepository) Claim(ctx context.Context, kinds []string, lease time.Duration) (*types.Job, error) {
+	var jobs []types.Job
+	if err := r.db.WithContext(ctx).Raw(`
+UPDATE jobs
+	SET status = ?, attempts = attempts + 1, locked_until = now() + make_interval(secs => ?), updated_at = now()
+	WHERE id = (
+		SELECT id FROM jobs
+			WHERE status = ? AND run_at <= now() AND kind IN ?
+			ORDER BY run_at, id
+			LIMIT 1
+			FOR UPDATE SKIP LOCKED
+	)
+	RETURNING *`,
+		types.JobRunning, lease.Seconds(), types.JobQueued, kinds,
+	).Scan(&jobs).Error; err != nil {
+		return nil, utils.DetermineSQLError(err, "claim job")
+	}
+	if len(jobs) == 0 {
+		return nil, nil
+	}
+	return &jobs[0], nil
+}
+
+// Extend pushes the lease of a running job forward, so that a long job is not
+// taken for one whose worker died.
+func (r *jobRepository) Extend(ctx context.Context, id int, lease time.Duration) error {
+	if err := r.db.WithContext(ctx).Exec(
+		"UPDATE jobs SET locked_until = now() + make_interval(secs => ?) WHERE id = ? AND status = ?",
+		lease.Seconds(), id, types.JobRunning,
+	).Error; err != nil {
+		return utils.DetermineSQLError(err, "extend job lease")
+	}
+	return nil
+}
+
+func (r *jobRepository) Complete(ctx context.Context, id int) error {
+	if err := r.db.WithContext(ctx).
+		Model(&types.Job{}).
+		Where("id = ? AND status = ?", id, types.JobRunning).
+		Updates(map[string]interface{}{
+			"status":       types.JobSucceeded,
+			"last_error":   "",
+			"locked_until": nil,
+			"finished_at":  gorm.Expr("now()"),
+		}).Error; err != nil {
+		return utils.DetermineSQLError(err, "complete job")
+	}
+	return nil
+}
+
+// Fail records a failed attempt. The job runs again at retryAt, or is dead
+// when retryAt is nil.
+func (r *jobRepository) Fail(ctx context.Context, id int, message string, retryAt *time.Time) error {
+	updates := map[string]interface{}{
+		"status":       types.JobDead,
+		"last_error":   message,
+		"locked_until": nil,
+		"finished_at":  gorm.Expr("now()"),
+	}
+	if retryAt != nil {
+		updates["status"] = types.JobQueued
+		updates["run_at"] = *retryAt
+		updates["finished_at"] = nil
+	}
+	if err := r.db.WithContext(ctx).
+		Model(&types.Job{}).
+		Where("id = ? AND status = ?", id, types.JobRunning).
+		Updates(updates).Error; err != nil {
+		return utils.DetermineSQLError(err, "fail job")
+	}
+	return nil
+}
+
+// RequeueExpired returns running jobs whose lease ran out, because their
+// worker died, to the queue. Jobs without attempts left are dead.
+func (r *jobRepository) RequeueExpired(ctx context.Context) (int64, error) {
+	res := r.db.WithContext(ctx).Exec(`
+UPDATE jobs
+	SET status = CASE WHEN attempts < max_attempts THEN ? ELSE ? END,
+		finished_at = CASE WHEN attempts < max_attempts THEN NULL ELSE now() END,
+		last_error = 'lease expired',
+		locked_until = NULL,
+		run_at = now(),
+		updated_at = now()
+	WHERE status = ? AND locked_until < now()`,
+		types.JobQueued, types.JobDead, types.JobRunning,
+	)
+	if res.Error != nil {
+		return 0, utils.DetermineSQLError(res.Error, "requeue expired jobs")
+	}
+	return res.RowsAffected, nil
+}
+
+// DeleteFinished drops succeeded and cancelled jobs that finished before the
+// given time. Dead jobs are kept for inspection.
+func (r *jobRepository) DeleteFinished(ctx context.Context, before time.Time) (int64, error) {
+	res := r.db.WithContext(ctx).
+		Where("status IN ? AND finished_at < ?", []string{types.JobSucceeded, types.JobCancelled}, before).
+		Delete(&types.Job{})
+	if res.Error != nil {
+		return 0, utils.DetermineSQLError(res.Error, "delete finished jobs")
+	}
+	return res.RowsAffected, nil
+}
+
+func (r *jobRepository) GetByID(ctx context.Context, id int) (*types.Job, error) {
+	var job types.Job
+	if err := r.db.WithContext(ctx).First(&job, "id = ?", id).Error; err != nil {
+		return nil, utils.DetermineSQLError(err, "get job")
+	}
+	return &job, nil
+}
+
+func (r *jobRepository) List(ctx context.Context, status, kind string, page *types.PageQuery) ([]types.Job, string, error) {
+	db := r.db.WithContext(ctx).Model(&types.Job{})
+	if status != "" {
+		db = db.Where("status = ?", status)
+	}
+	if kind != "" {
+		db = db.Where("kind = ?", kind)
+	}
+	return findPage(db, page, "id", jobSortKeys, func(j *types.Job) int { return j.ID }, "list jobs")
+}
+
+func (r *jobRepository) Count(ctx context.Context) ([]types.JobCount, error) {
+	counts := []types.JobCount{}
+	if err := r.db.WithContext(ctx).
+		Model(&types.Job{}).
+		Select("kind, status, count(*) AS count").
+		Group("kind, status").
+		Order("kind, status").
+		Scan(&counts).Error; err != nil {
+		return nil, utils.DetermineSQLError(err, "count jobs")
+	}
+	return counts, nil
+}
+
+// Retry puts a dead or cancelled job back in the queue with a fresh set of
+// attempts.
+func (r *jobRepository) Retry(ctx context.Context, id int) error {
+	return r.transition(ctx, id, []string{types.JobDead, types.JobCancelled}, map[string]interface{}{
+		"status":      types.JobQueued,
+		"attempts":    0,
+		"run_at":      gorm.Expr("now()"),
+		"finished_at": nil,
+	})
+}
+
+// Cancel stops a queued job from running. Running jobs cannot be cancelled.
+func (r *jobRepository) Cancel(ctx context.Context, id int) error {
+	return r.transition(ctx, id, []string{types.JobQueued}, map[string]interface{}{
+		"status":      types.JobCancelled,
+		"finished_at": gorm.Expr("now()"),
+	})
+}
+
+func (r *jobRepository) transition(ctx context.Context, id int, from []string, updates map[string]interface{}) error {
+	res := r.db.WithContext(ctx).
+		Model(&types.Job{}).
+		Where("id = ? AND status IN ?", id, from).
+		Updates(updates)
+	if res.Error != nil {
+		return utils.DetermineSQLError(res.Error, "update job")
+	}
+	if res.RowsAffected > 0 {
+		return nil
+	}
+	job, err := r.GetByID(ctx, id)
+	if err != nil {
+		return err
+	}
+	return utils.ErrConflict.New("job %d is %s", id, job.Status)
+}
+
+// HasLive reports whether a queued or running job of the kind has a payload
+// containing match.
+func (r *jobRepository) HasLive(ctx context.Context, kind string, match types.RawJSON) (bool, error) {
+	var n int64
+	if err := r.db.WithContext(ctx).
+		Model(&types.Job{}).
+		Where("kind = ? AND status IN ? AND payload @> ?", kind, []string{types.JobQueued, types.JobRunning}, match).
+		Count(&n).Error; err != nil {
+		return false, utils.DetermineSQLError(err, "find live jobs")
+	}
+	return n > 0, nil
+}
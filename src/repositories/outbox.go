@@ -0,0 +1,262 @@
+package repositories
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"math"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/CustomCloudStorage/types"
+	"github.com/CustomCloudStorage/utils"
+	"github.com/go-redis/redis"
+)
+
+const (
+	outboxGroup   = "workers"
+	outboxPayload = "payload"
+)
+
+// Outbox is a Redis stream read through a consumer group. A message stays
+// pending until it is acknowledged and is reclaimed once idle long enough;
+// messages that keep failing are buried in a dead-letter list.
+type Outbox interface {
+	Add(ctx context.Context, value interface{}) error
+	Read(ctx context.Context, consumer string, block time.Duration) (*types.OutboxMessage, error)
+	Reclaim(ctx context.Context, consumer string, minIdle func(deliveries int64) time.Duration, limit int64) ([]types.OutboxMessage, error)
+	Ack(ctx context.Context, id string) error
+	Bury(ctx context.Context, msg *types.OutboxMessage, reason string) error
+	Stats(ctx context.Context) (*types.OutboxStats, error)
+	ImportList(ctx context.Context, list string) (int, error)
+}
+
+type redisOutbox struct {
+	client *redis.Client
+	stream string
+	dead   string
+	sent   string
+}
+
+// NewRedisOutbox returns the outbox kept in the stream named name, creating
+// its consumer group when needed. The dead letters live in name:dead and the
+// count of acknowledged messages in name:sent.
+func NewRedisOutbox(client *redis.Client, name string) (Outbox, error) {
+	err := client.XGroupCreateMkStream(name, outboxGroup, "0").Err()
+	if err != nil && !strings.HasPrefix(err.Error(), "BUSYGROUP") {
+		return nil, utils.ErrInternal.Wrap(err, "redis XGROUP CREATE %s", name)
+	}
+	return &redisOutbox{
+		client: client,
+		stream: name,
+		dead:   name + ":dead",
+		sent:   name + ":sent",
+	}, nil
+}
+
+func (o *redisOutbox) Add(ctx context.Context, value interface{}) error {
+	data, err := json.Marshal(value)
+	if err != nil {
+		return utils.ErrInternal.Wrap(err, "marshal outbox message")
+	}
+	if err := o.client.WithContext(ctx).XAdd(&redis.XAddArgs{
+		Stream: o.stream,
+		Values: map[string]interface{}{outboxPayload: data},
+	}).Err(); err != nil {
+		return utils.ErrInternal.Wrap(err, fmt.Sprintf("redis XADD %s failed", o.stream))
+	}
+	return nil
+}
+
+// Read takes the next new message for consumer, waiting up to block for one.
+// It returns nil when none arrived.
+func (o *redisOutbox) Read(ctx context.Context, consumer string, block time.Duration) (*types.OutboxMessage, error) {
+	streams, err := o.client.WithContext(ctx).XReadGroup(&redis.XReadGroupArgs{
+		Group:    outboxGroup,
+		Consumer: consumer,
+		Streams:  []string{o.stream, ">"},
+		Count:    1,
+		Block:    block,
+	}).Result()
+	if err == redis.Nil {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, utils.ErrInternal.Wrap(err, fmt.Sprintf("redis XREADGROUP %s failed", o.stream))
+	}
+	for _, s := range streams {
+		for _, m := range s.Messages {
+			return outboxMessage(m, 1), nil
+		}
+	}
+	return nil, nil
+}
+
+// Reclaim hands consumer up to limit pending messages that have been idle for
+// at least minIdle of their delivery count. Claiming resets a message's idle
+// time, so of several replicas reclaiming at once only one gets it.
+func (o *redisOutbox) Reclaim(ctx context.Context, consumer string, minIdle func(deliveries int64) time.Duration, limit int64) ([]types.OutboxMessage, error) {
+	client := o.client.WithContext(ctx)
+	var claimed []types.OutboxMessage
+	for start := "-"; int64(len(claimed)) < limit; {
+		pending, err := client.XPendingExt(&redis.XPendingExtArgs{
+			Stream: o.stream,
+			Group:  outboxGroup,
+			Start:  start,
+			End:    "+",
+			Count:  limit,
+		}).Result()
+		if err != nil {
+			return claimed, utils.ErrInternal.Wrap(err, fmt.Sprintf("redis XPENDING %s failed", o.stream))
+		}
+
+		for _, p := range pending {
+			idle := minIdle(p.RetryCount)
+			if p.Idle < idle {
+				continue
+			}
+			msgs, err := client.XClaim(&redis.XClaimArgs{
+				Stream:   o.stream,
+				Group:    outboxGroup,
+				Consumer: consumer,
+				MinIdle:  idle,
+				Messages: []string{p.Id},
+			}).Result()
+			if err != nil {
+				return claimed, utils.ErrInternal.Wrap(err, fmt.Sprintf("redis XCLAIM %s failed", o.stream))
+			}
+			for _, m := range msgs {
+				claimed = append(claimed, *outboxMessage(m, p.RetryCount+1))
+			}
+			if int64(len(claimed)) == limit {
+				break
+			}
+		}
+		if int64(len(pending)) < limit {
+			break
+		}
+		start, err = nextStreamID(pending[len(pending)-1].Id)
+		if err != nil {
+			return claimed, err
+		}
+	}
+	return claimed, nil
+}
+
+// Ack removes a delivered message and counts it as sent.
+func (o *redisOutbox) Ack(ctx context.Context, id string) error {
+	_, err := o.client.WithContext(ctx).TxPipelined(func(pipe redis.Pipeliner) error {
+		pipe.XAck(o.stream, outboxGroup, id)
+		pipe.XDel(o.stream, id)
+		pipe.Incr(o.sent)
+		return nil
+	})
+	if err != nil {
+		return utils.ErrInternal.Wrap(err, fmt.Sprintf("redis ack %s in %s failed", id, o.stream))
+	}
+	return nil
+}
+
+// Bury moves a message that will not be retried to the dead-letter list.
+func (o *redisOutbox) Bury(ctx context.Context, msg *types.OutboxMessage, reason string) error {
+	data, err := json.Marshal(types.DeadLetter{
+		Payload:    json.RawMessage(msg.Payload),
+		Error:      reason,
+		Deliveries: msg.Deliveries,
+		FailedAt:   time.Now(),
+	})
+	if err != nil {
+		return utils.ErrInternal.Wrap(err, "marshal dead letter")
+	}
+	_, err = o.client.WithContext(ctx).TxPipelined(func(pipe redis.Pipeliner) error {
+		pipe.LPush(o.dead, data)
+		pipe.XAck(o.stream, outboxGroup, msg.ID)
+		pipe.XDel(o.stream, msg.ID)
+		return nil
+	})
+	if err != nil {
+		return utils.ErrInternal.Wrap(err, fmt.Sprintf("redis bury %s in %s failed", msg.ID, o.dead))
+	}
+	return nil
+}
+
+func (o *redisOutbox) Stats(ctx context.Context) (*types.OutboxStats, error) {
+	client := o.client.WithContext(ctx)
+	queued, err := client.XLen(o.stream).Result()
+	if err != nil {
+		return nil, utils.ErrInternal.Wrap(err, fmt.Sprintf("redis XLEN %s failed", o.stream))
+	}
+	pending, err := client.XPending(o.stream, outboxGroup).Result()
+	if err != nil {
+		return nil, utils.ErrInternal.Wrap(err, fmt.Sprintf("redis XPENDING %s failed", o.stream))
+	}
+	failed, err := client.LLen(o.dead).Result()
+	if err != nil {
+		return nil, utils.ErrInternal.Wrap(err, fmt.Sprintf("redis LLEN %s failed", o.dead))
+	}
+	sent, err := client.Get(o.sent).Int64()
+	if err != nil && err != redis.Nil {
+		return nil, utils.ErrInternal.Wrap(err, fmt.Sprintf("redis GET %s failed", o.sent))
+	}
+	return &types.OutboxStats{
+		Queued:   max(queued-pending.Count, 0),
+		InFlight: pending.Count,
+		Failed:   failed,
+		Sent:     sent,
+	}, nil
+}
+
+// The entry is only popped once XADD succeeded, and the script runs
+// atomically, so an entry is never lost or imported twice.
+var importScript = redis.NewScript(`
+local data = redis.call('LINDEX', KEYS[1], -1)
+if not data then
+	return 0
+end
+redis.call('XADD', KEYS[2], '*', ARGV[1], data)
+redis.call('RPOP', KEYS[1])
+return 1
+`)
+
+// ImportList moves the messages of a plain list queue, oldest first, into the
+// outbox.
+func (o *redisOutbox) ImportList(ctx context.Context, list string) (int, error) {
+	client := o.client.WithContext(ctx)
+	n := 0
+	for {
+		moved, err := importScript.Run(client, []string{list, o.stream}, outboxPayload).Int64()
+		if err != nil {
+			return n, utils.ErrInternal.Wrap(err, fmt.Sprintf("redis import %s into %s failed", list, o.stream))
+		}
+		if moved == 0 {
+			return n, nil
+		}
+		n++
+	}
+}
+
+func nextStreamID(id string) (string, error) {
+	ms, seq, ok := strings.Cut(id, "-")
+	n, err := strconv.ParseUint(seq, 10, 64)
+	if !ok || err != nil {
+		return "", utils.ErrInternal.New("malformed stream ID %q", id)
+	}
+	if n == math.MaxUint64 {
+		t, err := strconv.ParseUint(ms, 10, 64)
+		if err != nil {
+			return "", utils.ErrInternal.New("malformed stream ID %q", id)
+		}
+		return fmt.Sprintf("%d-0", t+1), nil
+	}
+	return fmt.Sprintf("%s-%d", ms, n+1), nil
+}
+
+func outboxMessage(m redis.XMessage, deliveries int64) *types.OutboxMessage {
+	payload, _ := m.Values[outboxPayload].(string)
+	return &types.OutboxMessage{
+		ID:         m.ID,
+		Payload:    []byte(payload),
+		Deliveries: deliveries,
+	}
+}
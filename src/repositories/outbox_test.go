@@ -0,0 +1,23 @@
+package repositories
+
+import "testing"
+
+func TestNextStreamID(t *testing.T) {
+	tests := []struct {
+		id   string
+		want string
+	}{
+		{"1700000000000-0", "1700000000000-1"},
+		{"1700000000000-41", "1700000000000-42"},
+		{"1700000000000-18446744073709551615", "1700000000001-0"},
+	}
+	for _, tt := range tests {
+		got, err := nextStreamID(tt.id)
+		if err != nil || got != tt.want {
+			t.Errorf("nextStreamID(%q) = %q, %v, want %q", tt.id, got, err, tt.want)
+		}
+	}
+	if _, err := nextStreamID("garbage"); err == nil {
+		t.Error("nextStreamID accepted a malformed ID")
+	}
+}
@@ -0,0 +1,127 @@
+package repositories
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/CustomCloudStorage/types"
+	"github.com/CustomCloudStorage/utils"
+	"gorm.io/gorm"
+)
+
+// sortKey describes a column a list can be ordered by. The cursor keeps the
+// value of the last row as text, so cast is the SQL type it is compared as.
+type sortKey[T any] struct {
+	column string
+	cast   string
+	value  func(*T) string
+}
+
+// pageCursor records the ordering it was issued for, so that it cannot be
+// replayed against a different one.
+type pageCursor struct {
+	Sort  string `json:"s"`
+	Order string `json:"o"`
+	Value string `json:"v"`
+	ID    int    `json:"id"`
+}
+
+// findPage runs a keyset-paginated query ordered by the requested sort key and
+// the id column as a tie breaker. It returns the cursor of the next page, or an
+// empty string when there is none.
+func findPage[T any](db *gorm.DB, page *types.PageQuery, idColumn string, keys map[string]sortKey[T], id func(*T) int, context string) ([]T, string, error) {
+	key, ok := keys[page.Sort]
+	if !ok {
+		return nil, "", utils.ErrBadRequest.New("invalid sort %q", page.Sort)
+	}
+	order, op, dir := "asc", ">", "ASC"
+	if strings.EqualFold(page.Order, "desc") {
+		order, op, dir = "desc", "<", "DESC"
+	}
+
+	if page.Cursor != "" {
+		cursor, err := decodeCursor(page.Cursor, page.Sort, order, key.cast)
+		if err != nil {
+			return nil, "", err
+		}
+		db = db.Where(
+			fmt.Sprintf("(%s, %s) %s (CAST(? AS %s), ?)", key.column, idColumn, op, key.cast),
+			cursor.Value, cursor.ID,
+		)
+	}
+
+	items := []T{}
+	if err := db.
+		Order(key.column + " " + dir).
+		Order(idColumn + " " + dir).
+		Limit(page.Limit + 1).
+		Find(&items).Error; err != nil {
+		return nil, "", utils.DetermineSQLError(err, context)
+	}
+
+	if len(items) <= page.Limit {
+		return items, "", nil
+	}
+	items = items[:page.Limit]
+	last := &items[len(items)-1]
+	return items, encodeCursor(pageCursor{Sort: page.Sort, Order: order, Value: key.value(last), ID: id(last)}), nil
+}
+
+func encodeCursor(c pageCursor) string {
+	raw, _ := json.Marshal(c)
+	return base64.RawURLEncoding.EncodeToString(raw)
+}
+
+// decodeCursor parses a cursor issued for the given sort and order and checks
+// that its value can be cast to the sort column's type.
+func decodeCursor(s, sort, order, cast string) (*pageCursor, error) {
+	raw, err := base64.RawURLEncoding.DecodeString(s)
+	if err != nil {
+		return nil, utils.ErrBadRequest.Wrap(err, "invalid cursor")
+	}
+	var c pageCursor
+	if err := json.Unmarshal(raw, &c); err != nil {
+		return nil, utils.ErrBadRequest.Wrap(err, "invalid cursor")
+	}
+	if c.Sort != sort || c.Order != order {
+		return nil, utils.ErrBadRequest.New("cursor was issued for sort %q %s", c.Sort, c.Order)
+	}
+	switch cast {
+	case "integer", "bigint":
+		_, err = strconv.ParseInt(c.Value, 10, 64)
+	case "timestamptz":
+		_, err = time.Parse(time.RFC3339Nano, c.Value)
+	}
+	if err != nil {
+		return nil, utils.ErrBadRequest.Wrap(err, "invalid cursor")
+	}
+	return &c, nil
+}
+
+func cursorInt(v int64) string {
+	return strconv.FormatInt(v, 10)
+}
+
+func cursorTime(t time.Time) string {
+	return t.UTC().Format(time.RFC3339Nano)
+}
+
+func cursorNullableTime(t *time.Time) string {
+	if t == nil {
+		return cursorTime(time.Time{})
+	}
+	return cursorTime(*t)
+}
+
+func withSortKey[T any](keys map[string]sortKey[T], name string, key sortKey[T]) map[string]sortKey[T] {
+	out := make(map[string]sortKey[T], len(keys)+1)
+	for k, v := range keys {
+		out[k] = v
+	}
+	out[name] = key
+	return out
+}
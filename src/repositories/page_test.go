@@ -0,0 +1,59 @@
+package repositories
+
+import (
+	"testing"
+	"time"
+
+	"github.com/CustomCloudStorage/utils"
+	"github.com/joomcode/errorx"
+)
+
+func TestCursorRoundTrip(t *testing.T) {
+	ts := time.Date(2024, 5, 6, 7, 8, 9, 123456789, time.FixedZone("CEST", 2*60*60))
+	want := pageCursor{Sort: "created_at", Order: "desc", Value: cursorTime(ts), ID: 42}
+
+	got, err := decodeCursor(encodeCursor(want), "created_at", "desc", "timestamptz")
+	if err != nil {
+		t.Fatalf("decodeCursor: %v", err)
+	}
+	if *got != want {
+		t.Fatalf("decodeCursor = %+v, want %+v", *got, want)
+	}
+	parsed, err := time.Parse(time.RFC3339Nano, got.Value)
+	if err != nil || !parsed.Equal(ts) {
+		t.Fatalf("cursor time %q does not round trip to %s", got.Value, ts)
+	}
+}
+
+func TestCursorRejected(t *testing.T) {
+	byName := encodeCursor(pageCursor{Sort: "name", Order: "asc", Value: "report", ID: 7})
+	bySize := encodeCursor(pageCursor{Sort: "size", Order: "asc", Value: "not a number", ID: 7})
+
+	tests := []struct {
+		name   string
+		cursor string
+		sort   string
+		order  string
+		cast   string
+	}{
+		{"other sort", byName, "size", "asc", "bigint"},
+		{"other order", byName, "name", "desc", "text"},
+		{"bad value", bySize, "size", "asc", "bigint"},
+		{"not base64", "!!!", "name", "asc", "text"},
+		{"not json", "bm90IGpzb24", "name", "asc", "text"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := decodeCursor(tt.cursor, tt.sort, tt.order, tt.cast)
+			if !errorx.IsOfType(err, utils.ErrBadRequest) {
+				t.Fatalf("decodeCursor = %v, want a bad request", err)
+			}
+		})
+	}
+}
+
+func TestCursorNullableTime(t *testing.T) {
+	if got, want := cursorNullableTime(nil), cursorTime(time.Time{}); got != want {
+		t.Fatalf("cursorNullableTime(nil) = %q, want %q", got, want)
+	}
+}
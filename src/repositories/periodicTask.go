@@ -0,0 +1,113 @@
+package repositories
+
+import (
+	"context"
+	"time"
+
+	"github.com/CustomCloudStorage/types"
+	"github.com/CustomCloudStorage/utils"
+	"gorm.io/gorm"
+)
+
+// PeriodicTaskRepository holds the leases of periodic tasks and the record of
+// their latest runs.
+type PeriodicTaskRepository interface {
+	WithLease(ctx context.Context, name string, fn func() error) (bool, error)
+	Register(ctx context.Context, name string, interval time.Duration) error
+	Started(ctx context.Context, name, replica string) error
+	Finished(ctx context.Context, name, status, message string, duration time.Duration) error
+	List(ctx context.Context) ([]types.PeriodicTask, error)
+}
+
+type periodicTaskRepository struct {
+	db *gorm.DB
+}
+
+func NewPeriodicTaskRepository(db *gorm.DB) PeriodicTaskRepository {
+	return &periodicTaskRepository{
+		db: db,
+	}
+}
+
+// WithLease runs fn while holding a session-level advisory lock named after
+// the task, and reports false without running it when another replica holds
+// the lock. The lock lives on one pooled connection, so it is released when
+// fn returns or, should the process die, when the connection drops.
+func (r *periodicTaskRepository) WithLease(ctx context.Context, name string, fn func() error) (bool, error) {
+	key := "periodic_task:" + name
+	ran := false
+	err := r.db.WithContext(ctx).Connection(func(conn *gorm.DB) (err error) {
+		var locked bool
+		if err := conn.Raw("SELECT pg_try_advisory_lock(hashtext(?))", key).Scan(&locked).Error; err != nil {
+			return utils.DetermineSQLError(err, "take task lease")
+		}
+		if !locked {
+			return nil
+		}
+		defer func() {
+			// fn may have outlived ctx; the lock must not go back to the pool.
+			unlockErr := conn.WithContext(context.Background()).Exec("SELECT pg_advisory_unlock(hashtext(?))", key).Error
+			if unlockErr != nil && err == nil {
+				err = utils.DetermineSQLError(unlockErr, "release task lease")
+			}
+		}()
+		ran = true
+		return fn()
+	})
+	return ran, err
+}
+
+// Register records the task, keeping the history of an existing one.
+func (r *periodicTaskRepository) Register(ctx context.Context, name string, interval time.Duration) error {
+	if err := r.db.WithContext(ctx).Exec(`
+INSERT INTO periodic_tasks (name, interval_seconds) VALUES (?, ?)
+	ON CONFLICT (name) DO UPDATE SET interval_seconds = EXCLUDED.interval_seconds`,
+		name, int64(interval/time.Second),
+	).Error; err != nil {
+		return utils.DetermineSQLError(err, "register periodic task")
+	}
+	return nil
+}
+
+func (r *periodicTaskRepository) Started(ctx context.Context, name, replica string) error {
+	if err := r.db.WithContext(ctx).
+		Model(&types.PeriodicTask{}).
+		Where("name = ?", name).
+		Updates(map[string]interface{}{
+			"last_status":     types.TaskRunning,
+			"last_error":      "",
+			"last_replica":    replica,
+			"last_started_at": gorm.Expr("now()"),
+		}).Error; err != nil {
+		return utils.DetermineSQLError(err, "record periodic task start")
+	}
+	return nil
+}
+
+func (r *periodicTaskRepository) Finished(ctx context.Context, name, status, message string, duration time.Duration) error {
+	updates := map[string]interface{}{
+		"last_status":      status,
+		"last_error":       message,
+		"last_finished_at": gorm.Expr("now()"),
+		"last_duration_ms": duration.Milliseconds(),
+		"runs":             gorm.Expr("runs + 1"),
+	}
+	if status == types.TaskFailed {
+		updates["failures"] = gorm.Expr("failures + 1")
+	}
+	if err := r.db.WithContext(ctx).
+		Model(&types.PeriodicTask{}).
+		Where("name = ?", name).
+		Updates(updates).Error; err != nil {
+		return utils.DetermineSQLError(err, "record periodic task outcome")
+	}
+	return nil
+}
+
+func (r *periodicTaskRepository) List(ctx context.Context) ([]types.PeriodicTask, error) {
+	tasks := []types.PeriodicTask{}
+	if err := r.db.WithContext(ctx).Order("name").Find(&tasks).Error; err != nil {
+		return nil, utils.DetermineSQLError(err, "list periodic tasks")
+	}
+	return tasks, nil
+}
@@ -0,0 +1,84 @@
+package repositories
+
+import (
+	"context"
+	"time"
+
+	"github.com/CustomCloudStorage/types"
+	"github.com/CustomCloudStorage/utils"
+	"gorm.io/gorm"
+)
+
+type ShareLinkRepository interface {
+	Create(ctx context.Context, link *types.ShareLink) error
+	GetByToken(ctx context.Context, token string) (*types.ShareLink, error)
+	ListByUserID(ctx context.Context, userID int) ([]types.ShareLink, error)
+	Revoke(ctx context.Context, id, userID int, ts time.Time) error
+	IncrementDownloads(ctx context.Context, id int) error
+}
+
+type shareLinkRepository struct {
+	db *gorm.DB
+}
+
+func NewShareLinkRepository(db *gorm.DB) ShareLinkRepository {
+	return &shareLinkRepository{
+		db: db,
+	}
+}
+
+func (r *shareLinkRepository) Create(ctx context.Context, link *types.ShareLink) error {
+	if err := r.db.WithContext(ctx).Create(link).Error; err != nil {
+		return utils.DetermineSQLError(err, "create share link")
+	}
+	return nil
+}
+
+func (r *shareLinkRepository) GetByToken(ctx context.Context, token string) (*types.ShareLink, error) {
+	var link types.ShareLink
+	if err := r.db.WithContext(ctx).
+		Where("token = ?", token).
+		First(&link).Error; err != nil {
+		return nil, utils.DetermineSQLError(err, "get share link")
+	}
+	return &link, nil
+}
+
+func (r *shareLinkRepository) ListByUserID(ctx context.Context, userID int) ([]types.ShareLink, error) {
+	var links []types.ShareLink
+	if err := r.db.WithContext(ctx).
+		Where("user_id = ?", userID).
+		Order("created_at DESC").
+		Find(&links).Error; err != nil {
+		return nil, utils.DetermineSQLError(err, "list share links")
+	}
+	return links, nil
+}
+
+func (r *shareLinkRepository) Revoke(ctx context.Context, id, userID int, ts time.Time) error {
+	result := r.db.WithContext(ctx).
+		Model(&types.ShareLink{}).
+		Where("id = ? AND user_id = ? AND revoked_at IS NULL", id, userID).
+		Update("revoked_at", ts)
+	if err := result.Error; err != nil {
+		return utils.DetermineSQLError(err, "revoke share link")
+	}
+	if result.RowsAffected == 0 {
+		return utils.ErrNotFound.New("share link %d not found", id)
+	}
+	return nil
+}
+
+func (r *shareLinkRepository) IncrementDownloads(ctx context.Context, id int) error {
+	result := r.db.WithContext(ctx).
+		Model(&types.ShareLink{}).
+		Where("id = ? AND (max_downloads IS NULL OR download_count < max_downloads)", id).
+		UpdateColumn("download_count", gorm.Expr("download_count + 1"))
+	if err := result.Error; err != nil {
+		return utils.DetermineSQLError(err, "increment share downloads")
+	}
+	if result.RowsAffected == 0 {
+		return utils.ErrForbidden.New("share link download limit reached")
+	}
+	return nil
+}
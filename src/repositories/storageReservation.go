@@ -0,0 +1,222 @@
+package repositories
+
+import (
+	"context"
+	"time"
+
+	"github.com/CustomCloudStorage/types"
+	"github.com/CustomCloudStorage/utils"
+	"github.com/google/uuid"
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+// StorageReservationRepository keeps the ledger of quota held by uploads that
+// have not produced a file yet.
+type StorageReservationRepository interface {
+	Reserve(ctx context.Context, reservation *types.StorageReservation) error
+	Release(ctx context.Context, id uuid.UUID) error
+	Available(ctx context.Context, userID int) (int64, error)
+	ExpireDetached(ctx context.Context, before time.Time) (int64, error)
+	Reconcile(ctx context.Context, dryRun bool) ([]types.StorageUsage, error)
+}
+
+type storageReservationRepository struct {
+	db *gorm.DB
+}
+
+func NewStorageReservationRepository(db *gorm.DB) StorageReservationRepository {
+	return &storageReservationRepository{
+		db: db,
+	}
+}
+
+func (r *storageReservationRepository) Reserve(ctx context.Context, reservation *types.StorageReservation) error {
+	return r.db.WithContext(ctx).Transaction(func(tx *gorm.DB) error {
+		if err := checkQuota(tx, reservation.UserID, reservation.Size); err != nil {
+			return err
+		}
+		if err := tx.Create(reservation).Error; err != nil {
+			return utils.DetermineSQLError(err, "create storage reservation")
+		}
+		return nil
+	})
+}
+
+// Release drops a reservation without charging it. Releasing a reservation
+// that was already settled or released is not an error.
+func (r *storageReservationRepository) Release(ctx context.Context, id uuid.UUID) error {
+	if err := r.db.WithContext(ctx).
+		Where("id = ?", id).
+		Delete(&types.StorageReservation{}).
+		Error; err != nil {
+		return utils.DetermineSQLError(err, "release storage reservation")
+	}
+	return nil
+}
+
+// Available returns how many bytes the user can still reserve.
+func (r *storageReservationRepository) Available(ctx context.Context, userID int) (int64, error) {
+	var acct types.Account
+	if err := r.db.WithContext(ctx).
+		First(&acct, "user_id = ?", userID).Error; err != nil {
+		return 0, utils.DetermineSQLError(err, "get account")
+	}
+	reserved, err := reservedStorage(r.db.WithContext(ctx), userID)
+	if err != nil {
+		return 0, err
+	}
+	return max(acct.StorageLimit-acct.UsedStorage-reserved, 0), nil
+}
+
+// ExpireDetached drops reservations created before the given time that are not
+// tied to an upload session.
+func (r *storageReservationRepository) ExpireDetached(ctx context.Context, before time.Time) (int64, error) {
+	res := r.db.WithContext(ctx).
+		Where("upload_session_id IS NULL AND created_at < ?", before).
+		Delete(&types.StorageReservation{})
+	if res.Error != nil {
+		return 0, utils.DetermineSQLError(res.Error, "expire storage reservations")
+	}
+	return res.RowsAffected, nil
+}
+
+// Reconcile recomputes every account's used_storage from its file versions and
+// returns the accounts whose recorded value was wrong. A dry run only reports
+// them.
+func (r *storageReservationRepository) Reconcile(ctx context.Context, dryRun bool) ([]types.StorageUsage, error) {
+	var userIDs []int
+	if err := r.db.WithContext(ctx).
+		Model(&types.Account{}).
+		Order("user_id").
+		Pluck("user_id", &userIDs).Error; err != nil {
+		return nil, utils.DetermineSQLError(err, "list accounts")
+	}
+
+	var out []types.StorageUsage
+	for _, id := range userIDs {
+		usage, err := r.reconcileAccount(ctx, id, dryRun)
+		if err != nil {
+			return out, err
+		}
+		if usage.Recorded != usage.Actual {
+			out = append(out, *usage)
+		}
+	}
+	return out, nil
+}
+
+func (r *storageReservationRepository) reconcileAccount(ctx context.Context, userID int, dryRun bool) (*types.StorageUsage, error) {
+	usage := &types.StorageUsage{UserID: userID}
+	err := r.db.WithContext(ctx).Transaction(func(tx *gorm.DB) error {
+		acct, err := lockAccount(tx, userID)
+		if err != nil {
+			return err
+		}
+		usage.Recorded = acct.UsedStorage
+
+		if err := tx.Raw(`
+SELECT COALESCE(SUM(v.size), 0)
+	FROM file_versions v
+	JOIN files f ON f.id = v.file_id
+	WHERE f.user_id = ?`, userID).
+			Row().
+			Scan(&usage.Actual); err != nil {
+			return utils.DetermineSQLError(err, "sum file versions")
+		}
+		if usage.Reserved, err = reservedStorage(tx, userID); err != nil {
+			return err
+		}
+
+		if dryRun || usage.Actual == usage.Recorded {
+			return nil
+		}
+		if err := tx.Model(&types.Account{}).
+			Where("user_id = ?", userID).
+			UpdateColumn("used_storage", usage.Actual).Error; err != nil {
+			return utils.DetermineSQLError(err, "reconcile used storage")
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return usage, nil
+}
+
+func settleReservation(tx *gorm.DB, id uuid.UUID, size int64) error {
+	var reservation types.StorageReservation
+	if err := tx.First(&reservation, "id = ?", id).Error; err != nil {
+		return utils.DetermineSQLError(err, "get storage reservation")
+	}
+	if size > reservation.Size {
+		// The reservation itself is still counted, so only the excess is new.
+		if err := checkQuota(tx, reservation.UserID, size-reservation.Size); err != nil {
+			return err
+		}
+	} else if _, err := lockAccount(tx, reservation.UserID); err != nil {
+		return err
+	}
+
+	res := tx.Where("id = ?", id).Delete(&types.StorageReservation{})
+	if res.Error != nil {
+		return utils.DetermineSQLError(res.Error, "settle storage reservation")
+	}
+	if res.RowsAffected == 0 {
+		return utils.ErrConflict.New("storage reservation %s was already settled", id)
+	}
+	return adjustUsedStorage(tx, reservation.UserID, size)
+}
+
+func lockAccount(tx *gorm.DB, userID int) (*types.Account, error) {
+	var acct types.Account
+	if err := tx.
+		Clauses(clause.Locking{Strength: "UPDATE"}).
+		First(&acct, "user_id = ?", userID).Error; err != nil {
+		return nil, utils.DetermineSQLError(err, "lock account")
+	}
+	return &acct, nil
+}
+
+func checkQuota(tx *gorm.DB, userID int, size int64) error {
+	acct, err := lockAccount(tx, userID)
+	if err != nil {
+		return err
+	}
+	reserved, err := reservedStorage(tx, userID)
+	if err != nil {
+		return err
+	}
+	if acct.UsedStorage+reserved+size > acct.StorageLimit {
+		return utils.ErrConflict.New(
+			"quota exceeded: used=%d, reserved=%d, limit=%d",
+			acct.UsedStorage, reserved, acct.StorageLimit,
+		)
+	}
+	return nil
+}
+
+func reservedStorage(tx *gorm.DB, userID int) (int64, error) {
+	var reserved int64
+	if err := tx.Model(&types.StorageReservation{}).
+		Select("COALESCE(SUM(size), 0)").
+		Where("user_id = ?", userID).
+		Row().
+		Scan(&reserved); err != nil {
+		return 0, utils.DetermineSQLError(err, "sum storage reservations")
+	}
+	return reserved, nil
+}
+
+func adjustUsedStorage(tx *gorm.DB, userID int, delta int64) error {
+	if delta == 0 {
+		return nil
+	}
+	if err := tx.Model(&types.Account{}).
+		Where("user_id = ?", userID).
+		UpdateColumn("used_storage", gorm.Expr("GREATEST(used_storage + ?, 0)", delta)).
+		Error; err != nil {
+		return utils.DetermineSQLError(err, "update used storage")
+	}
+	return nil
+}
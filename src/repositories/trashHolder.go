@@ -12,19 +12,28 @@ import (
 type TrashRepository interface {
 	SoftDeleteFile(ctx context.Context, userID, fileID int, ts time.Time) error
 	RestoreFile(ctx context.Context, userID, fileID int) error
-	ListTrashedFiles(ctx context.Context, userID int) ([]*types.File, error)
+	ListTrashedFiles(ctx context.Context, userID int, page *types.PageQuery) ([]types.File, string, error)
 	ListFilesToPurge(ctx context.Context, before time.Time) ([]*types.File, error)
-	HardDeleteFileByID(ctx context.Context, fileID int) error
-	PermanentDeleteFile(ctx context.Context, userID, fileID int) error
+	HardDeleteFileByID(ctx context.Context, fileID int, onLast func(hash string) error) error
+	GetTrashedFile(ctx context.Context, userID, fileID int) (*types.File, error)
 
 	SoftDeleteFolderCascade(ctx context.Context, userID, folderID int, ts time.Time) error
 	RestoreFolderCascade(ctx context.Context, userID, folderID int) error
-	ListTrashedFolders(ctx context.Context, userID int) ([]*types.Folder, error)
+	ListTrashedFolders(ctx context.Context, userID int, page *types.PageQuery) ([]types.Folder, string, error)
 	ListFoldersToPurge(ctx context.Context, before time.Time) ([]*types.Folder, error)
 	HardDeleteFolderByID(ctx context.Context, folderID int) error
-	PermanentDeleteFolder(ctx context.Context, userID, folderID int) ([]int, error)
+	ListFolderTreeFiles(ctx context.Context, userID, folderID int) ([]*types.File, error)
+	HardDeleteFolderCascade(ctx context.Context, userID, folderID int) error
 }
 
+var trashFileSortKeys = withSortKey(fileSortKeys, "deleted_at", sortKey[types.File]{
+	"deleted_at", "timestamptz", func(f *types.File) string { return cursorNullableTime(f.DeletedAt) },
+})
+
+var trashFolderSortKeys = withSortKey(folderSortKeys, "deleted_at", sortKey[types.Folder]{
+	"deleted_at", "timestamptz", func(f *types.Folder) string { return cursorNullableTime(f.DeletedAt) },
+})
+
 type trashRepository struct {
 	db *gorm.DB
 }
@@ -36,9 +45,13 @@ func NewTrashRepository(db *gorm.DB) TrashRepository {
 }
 
 func (r *trashRepository) SoftDeleteFile(ctx context.Context, userID, fileID int, ts time.Time) error {
-	if err := r.db.WithContext(ctx).
+	return softDeleteFile(r.db.WithContext(ctx), userID, fileID, ts)
+}
+
+func softDeleteFile(tx *gorm.DB, userID, fileID int, ts time.Time) error {
+	if err := tx.
 		Model(&types.File{}).
-		Where("id = & AND user_id = ?", fileID, userID).
+		Where("id = ? AND user_id = ?", fileID, userID).
 		Update("deleted_at", ts).
 		Error; err != nil {
 		return utils.DetermineSQLError(err, "soft delete file")
@@ -57,15 +70,10 @@ func (r *trashRepository) RestoreFile(ctx context.Context, userID, fileID int) e
 	return nil
 }
 
-func (r *trashRepository) ListTrashedFiles(ctx context.Context, userID int) ([]*types.File, error) {
-	var out []*types.File
-	err := r.db.WithContext(ctx).
-		Where("user_id = ? AND deleted_at IS NOT NULL", userID).
-		Find(&out).Error
-	if err != nil {
-		return nil, utils.DetermineSQLError(err, "list trashed files")
-	}
-	return out, nil
+func (r *trashRepository) ListTrashedFiles(ctx context.Context, userID int, page *types.PageQuery) ([]types.File, string, error) {
+	db := r.db.WithContext(ctx).
+		Where("user_id = ? AND deleted_at IS NOT NULL", userID)
+	return findPage(db, page, "id", trashFileSortKeys, func(f *types.File) int { return f.ID }, "list trashed files")
 }
 
 func (r *trashRepository) ListFilesToPurge(ctx context.Context, before time.Time) ([]*types.File, error) {
@@ -79,91 +87,123 @@ func (r *trashRepository) ListFilesToPurge(ctx context.Context, before time.Time
 	return out, err
 }
 
-func (r *trashRepository) HardDeleteFileByID(ctx context.Context, fileID int) error {
-	if err := r.db.WithContext(ctx).
-		Unscoped().
-		Where("id = ?", fileID).
-		Delete(&types.File{}).
-		Error; err != nil {
-		return utils.DetermineSQLError(err, "hard delete file")
-	}
-	return nil
+// HardDeleteFileByID deletes the file row with its versions, refunds their
+// size to the owner and drops their blob references, all in one transaction.
+// onLast removes the content of a blob that lost its last reference.
+func (r *trashRepository) HardDeleteFileByID(ctx context.Context, fileID int, onLast func(hash string) error) error {
+	return r.db.WithContext(ctx).Transaction(func(tx *gorm.DB) error {
+		var file types.File
+		res := tx.Unscoped().Where("id = ?", fileID).Limit(1).Find(&file)
+		if res.Error != nil {
+			return utils.DetermineSQLError(res.Error, "get file to hard delete")
+		}
+		if res.RowsAffected == 0 {
+			return nil
+		}
+		var size int64
+		if err := tx.Model(&types.FileVersion{}).
+			Select("COALESCE(SUM(size), 0)").
+			Where("file_id = ?", fileID).
+			Row().
+			Scan(&size); err != nil {
+			return utils.DetermineSQLError(err, "sum file versions")
+		}
+		if size == 0 {
+			// Files from before versioning have no version rows.
+			size = file.Size
+		}
+		var hashes []string
+		if err := tx.Raw(`
+SELECT b.hash
+	FROM (
+		SELECT physical_name FROM file_versions WHERE file_id = @file
+		UNION ALL
+		SELECT @name WHERE NOT EXISTS (SELECT 1 FROM file_versions WHERE file_id = @file)
+	) v
+	JOIN blobs b ON b.hash = v.physical_name`,
+			map[string]interface{}{"file": fileID, "name": file.PhysicalName}).
+			Scan(&hashes).Error; err != nil {
+			return utils.DetermineSQLError(err, "list file blobs")
+		}
+
+		if err := tx.
+			Unscoped().
+			Where("id = ?", fileID).
+			Delete(&types.File{}).
+			Error; err != nil {
+			return utils.DetermineSQLError(err, "hard delete file")
+		}
+		if err := adjustUsedStorage(tx, file.UserID, -size); err != nil {
+			return err
+		}
+		for _, hash := range hashes {
+			if err := releaseBlobRef(tx, hash, func() error { return onLast(hash) }); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
 }
 
-func (r *trashRepository) PermanentDeleteFile(ctx context.Context, userID, fileID int) error {
+func (r *trashRepository) GetTrashedFile(ctx context.Context, userID, fileID int) (*types.File, error) {
 	var file types.File
 	if err := r.db.WithContext(ctx).
 		Unscoped().
 		Where("id = ? AND user_id = ? AND deleted_at IS NOT NULL", fileID, userID).
 		First(&file).Error; err != nil {
-		return utils.DetermineSQLError(err, "get trashed file")
-	}
-	if err := r.db.WithContext(ctx).
-		Unscoped().
-		Where("id = ?", fileID).
-		Delete(&types.File{}).Error; err != nil {
-		return utils.DetermineSQLError(err, "hard delete trashed file")
+		return nil, utils.DetermineSQLError(err, "get trashed file")
 	}
-	return nil
+	return &file, nil
 }
 
 func (r *trashRepository) SoftDeleteFolderCascade(ctx context.Context, userID, folderID int, ts time.Time) error {
-	const sql = `
+	return r.db.WithContext(ctx).Transaction(func(tx *gorm.DB) error {
+		return setFolderTreeDeletedAt(tx, userID, folderID, &ts, "soft delete folder cascade")
+	})
+}
+
+func (r *trashRepository) RestoreFolderCascade(ctx context.Context, userID, folderID int) error {
+	return r.db.WithContext(ctx).Transaction(func(tx *gorm.DB) error {
+		return setFolderTreeDeletedAt(tx, userID, folderID, nil, "restore folder cascade")
+	})
+}
+
+func setFolderTreeDeletedAt(tx *gorm.DB, userID, folderID int, ts *time.Time, context string) error {
+	const sqlFolders = `
 WITH RECURSIVE cte AS (
     SELECT id FROM folders WHERE user_id = @user AND id = @root
-	UNION ALL
+	UNION
     SELECT f.id FROM folders f JOIN cte ON f.parent_id = cte.id WHERE f.user_id = @user
 )
 UPDATE folders
 	SET deleted_at = @ts
 	WHERE id IN (SELECT id FROM cte);
-
-UPDATE files
-	SET deleted_at = @ts
-	WHERE user_id = @user
-	AND folder_id IN (SELECT id FROM cte);
 `
-	if err := r.db.WithContext(ctx).
-		Raw(sql, map[string]interface{}{"user": userID, "root": folderID, "ts": ts}).
-		Error; err != nil {
-		return utils.DetermineSQLError(err, "soft delete folder cascade")
-	}
-	return nil
-}
-
-func (r *trashRepository) RestoreFolderCascade(ctx context.Context, userID, folderID int) error {
-	const sql = `
+	const sqlFiles = `
 WITH RECURSIVE cte AS (
     SELECT id FROM folders WHERE user_id = @user AND id = @root
-	UNION ALL
+	UNION
     SELECT f.id FROM folders f JOIN cte ON f.parent_id = cte.id WHERE f.user_id = @user
 )
-UPDATE folders
-	SET deleted_at = NULL
-	WHERE id IN (SELECT id FROM cte);
-
 UPDATE files
-	SET deleted_at = NULL
+	SET deleted_at = @ts
 	WHERE user_id = @user
 	AND folder_id IN (SELECT id FROM cte);
 `
-	if err := r.db.WithContext(ctx).
-		Raw(sql, map[string]interface{}{"user": userID, "root": folderID}).
-		Error; err != nil {
-		return utils.DetermineSQLError(err, "restore folder cascade")
+	args := map[string]interface{}{"user": userID, "root": folderID, "ts": ts}
+	if err := tx.Exec(sqlFiles, args).Error; err != nil {
+		return utils.DetermineSQLError(err, context)
+	}
+	if err := tx.Exec(sqlFolders, args).Error; err != nil {
+		return utils.DetermineSQLError(err, context)
 	}
 	return nil
 }
 
-func (r *trashRepository) ListTrashedFolders(ctx context.Context, userID int) ([]*types.Folder, error) {
-	var out []*types.Folder
-	err := r.db.WithContext(ctx).
-		Where("user_id = ? AND deleted_at IS NOT NULL", userID).
-		Find(&out).Error
-	if err != nil {
-		return nil, utils.DetermineSQLError(err, "list trashed folders")
-	}
-	return out, nil
+func (r *trashRepository) ListTrashedFolders(ctx context.Context, userID int, page *types.PageQuery) ([]types.Folder, string, error) {
+	db := r.db.WithContext(ctx).
+		Where("user_id = ? AND deleted_at IS NOT NULL", userID)
+	return findPage(db, page, "id", trashFolderSortKeys, func(f *types.Folder) int { return f.ID }, "list trashed folders")
 }
 
 func (r *trashRepository) ListFoldersToPurge(ctx context.Context, before time.Time) ([]*types.Folder, error) {
@@ -187,46 +227,75 @@ func (r *trashRepository) HardDeleteFolderByID(ctx context.Context, folderID int
 	return nil
 }
 
-func (r *trashRepository) PermanentDeleteFolder(ctx context.Context, userID, folderID int) ([]int, error) {
-	const sqlGetFiles = `
+func (r *trashRepository) ListFolderTreeFiles(ctx context.Context, userID, folderID int) ([]*types.File, error) {
+	const sql = `
 WITH RECURSIVE cte AS (
     SELECT id FROM folders WHERE user_id = @user AND id = @root
 	UNION ALL
     SELECT f.id FROM folders f JOIN cte ON f.parent_id = cte.id WHERE f.user_id = @user
 )
-SELECT fi.id
+SELECT fi.*
 	FROM files fi
 	WHERE fi.user_id = @user AND fi.folder_id IN (SELECT id FROM cte);
 `
-	rows, err := r.db.WithContext(ctx).
-		Raw(sqlGetFiles, map[string]interface{}{"user": userID, "root": folderID}).
-		Rows()
-	if err != nil {
-		return nil, utils.DetermineSQLError(err, "list files for permanent delete")
-	}
-	defer rows.Close()
-
-	var filesID []int
-	for rows.Next() {
-		var id int
-		rows.Scan(&id)
-		filesID = append(filesID, id)
+	var files []*types.File
+	if err := r.db.WithContext(ctx).
+		Raw(sql, map[string]interface{}{"user": userID, "root": folderID}).
+		Scan(&files).Error; err != nil {
+		return nil, utils.DetermineSQLError(err, "list folder tree files")
 	}
+	return files, nil
+}
 
-	const sqlDel = `
+func (r *trashRepository) HardDeleteFolderCascade(ctx context.Context, userID, folderID int) error {
+	const sqlDelFiles = `
 WITH RECURSIVE cte AS (
     SELECT id FROM folders WHERE user_id = @user AND id = @root
 	UNION ALL
     SELECT f.id FROM folders f JOIN cte ON f.parent_id = cte.id WHERE f.user_id = @user
 )
 DELETE FROM files USING cte WHERE files.folder_id = cte.id;
+`
+	const sqlTreeUsage = `
+WITH RECURSIVE cte AS (
+    SELECT id FROM folders WHERE user_id = @user AND id = @root
+	UNION ALL
+    SELECT f.id FROM folders f JOIN cte ON f.parent_id = cte.id WHERE f.user_id = @user
+)
+SELECT fi.user_id, COALESCE(SUM(v.size), 0) AS size
+	FROM files fi
+	JOIN cte ON fi.folder_id = cte.id
+	JOIN file_versions v ON v.file_id = fi.id
+	GROUP BY fi.user_id;
+`
+	const sqlDelFolders = `
+WITH RECURSIVE cte AS (
+    SELECT id FROM folders WHERE user_id = @user AND id = @root
+	UNION ALL
+    SELECT f.id FROM folders f JOIN cte ON f.parent_id = cte.id WHERE f.user_id = @user
+)
 DELETE FROM folders USING cte WHERE folders.id = cte.id;
 `
-	if err := r.db.WithContext(ctx).
-		Raw(sqlDel, map[string]interface{}{"user": userID, "root": folderID}).
-		Error; err != nil {
-		return filesID, utils.DetermineSQLError(err, "hard delete folder cascade")
-	}
-
-	return filesID, nil
+	args := map[string]interface{}{"user": userID, "root": folderID}
+	return r.db.WithContext(ctx).Transaction(func(tx *gorm.DB) error {
+		var usage []struct {
+			UserID int
+			Size   int64
+		}
+		if err := tx.Raw(sqlTreeUsage, args).Scan(&usage).Error; err != nil {
+			return utils.DetermineSQLError(err, "sum folder tree versions")
+		}
+		if err := tx.Exec(sqlDelFiles, args).Error; err != nil {
+			return utils.DetermineSQLError(err, "hard delete folder files")
+		}
+		for _, u := range usage {
+			if err := adjustUsedStorage(tx, u.UserID, -u.Size); err != nil {
+				return err
+			}
+		}
+		if err := tx.Exec(sqlDelFolders, args).Error; err != nil {
+			return utils.DetermineSQLError(err, "hard delete folder cascade")
+		}
+		return nil
+	})
 }
@@ -7,11 +7,13 @@ import (
 	"github.com/CustomCloudStorage/utils"
 	"github.com/google/uuid"
 	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
 )
 
 type UploadPartRepository interface {
 	Create(ctx context.Context, part *types.UploadPart) error
 	ListBySession(ctx context.Context, sessionID uuid.UUID) ([]types.UploadPart, error)
+	ListBySessions(ctx context.Context, sessionIDs []uuid.UUID) ([]types.UploadPart, error)
 	DeleteBySession(ctx context.Context, sessionID uuid.UUID) error
 }
 
@@ -25,8 +27,13 @@ func NewUploadPartRepository(db *gorm.DB) UploadPartRepository {
 	}
 }
 
+// Create records a part; uploading the same part number again replaces it.
 func (r *uploadPartRepository) Create(ctx context.Context, part *types.UploadPart) error {
 	if err := r.db.WithContext(ctx).
+		Clauses(clause.OnConflict{
+			Columns:   []clause.Column{{Name: "session_id"}, {Name: "part_number"}},
+			DoUpdates: clause.AssignmentColumns([]string{"size", "uploaded_at"}),
+		}).
 		Create(part).
 		Error; err != nil {
 		return utils.DetermineSQLError(err, "create upload part")
@@ -44,6 +51,21 @@ func (r *uploadPartRepository) ListBySession(ctx context.Context, sessionID uuid
 	return parts, nil
 }
 
+func (r *uploadPartRepository) ListBySessions(ctx context.Context, sessionIDs []uuid.UUID) ([]types.UploadPart, error) {
+	var parts []types.UploadPart
+	if len(sessionIDs) == 0 {
+		return parts, nil
+	}
+	if err := r.db.WithContext(ctx).
+		Where("session_id IN ?", sessionIDs).
+		Order("session_id, part_number").
+		Find(&parts).
+		Error; err != nil {
+		return nil, utils.DetermineSQLError(err, "list upload parts")
+	}
+	return parts, nil
+}
+
 func (r *uploadPartRepository) DeleteBySession(ctx context.Context, sessionID uuid.UUID) error {
 	if err := r.db.WithContext(ctx).
 		Where("session_id = ?", sessionID).
@@ -13,8 +13,14 @@ import (
 type UploadSessionRepository interface {
 	Create(ctx context.Context, session *types.UploadSession) error
 	GetByID(ctx context.Context, id uuid.UUID) (*types.UploadSession, error)
+	GetForUser(ctx context.Context, id uuid.UUID, userID int) (*types.UploadSession, error)
+	ListByUserID(ctx context.Context, userID int) ([]types.UploadSession, error)
 	Delete(ctx context.Context, id uuid.UUID) error
 	ListOlderThan(ctx context.Context, olderThan time.Duration) ([]types.UploadSession, error)
+	AppendPart(ctx context.Context, part *types.UploadPart) error
+	RemoveLastPart(ctx context.Context, id uuid.UUID, partNumber int) error
+	Transition(ctx context.Context, id uuid.UUID, from []string, to string) error
+	Finish(ctx context.Context, id uuid.UUID, status string, fileID *int, message string) error
 }
 
 type uploadSessionRepository struct {
@@ -46,6 +52,28 @@ func (r *uploadSessionRepository) GetByID(ctx context.Context, id uuid.UUID) (*t
 	return &session, nil
 }
 
+func (r *uploadSessionRepository) GetForUser(ctx context.Context, id uuid.UUID, userID int) (*types.UploadSession, error) {
+	var session types.UploadSession
+	if err := r.db.WithContext(ctx).
+		First(&session, "id = ? AND user_id = ?", id, userID).
+		Error; err != nil {
+		return nil, utils.DetermineSQLError(err, "get upload session")
+	}
+	return &session, nil
+}
+
+func (r *uploadSessionRepository) ListByUserID(ctx context.Context, userID int) ([]types.UploadSession, error) {
+	var sessions []types.UploadSession
+	if err := r.db.WithContext(ctx).
+		Where("user_id = ? AND status <> ?", userID, types.UploadSessionCompleted).
+		Order("created_at DESC").
+		Find(&sessions).
+		Error; err != nil {
+		return nil, utils.DetermineSQLError(err, "list upload sessions")
+	}
+	return sessions, nil
+}
+
 func (r *uploadSessionRepository) Delete(ctx context.Context, id uuid.UUID) error {
 	if err := r.db.WithContext(ctx).
 		Unscoped().
@@ -68,3 +96,72 @@ func (r *uploadSessionRepository) ListOlderThan(ctx context.Context, olderThan t
 	}
 	return sessions, nil
 }
+
+// AppendPart records part as the next part of a session whose part count grows
+// as data arrives. The session's total_parts must be exactly one less than the
+// part number, so concurrent appends at the same offset fail with a conflict.
+func (r *uploadSessionRepository) AppendPart(ctx context.Context, part *types.UploadPart) error {
+	return r.db.WithContext(ctx).Transaction(func(tx *gorm.DB) error {
+		res := tx.Model(&types.UploadSession{}).
+			Where("id = ? AND total_parts = ?", part.SessionID, part.PartNumber-1).
+			Update("total_parts", part.PartNumber)
+		if res.Error != nil {
+			return utils.DetermineSQLError(res.Error, "advance upload session")
+		}
+		if res.RowsAffected == 0 {
+			return utils.ErrConflict.New("upload session %s moved past part %d", part.SessionID, part.PartNumber-1)
+		}
+		if err := tx.Create(part).Error; err != nil {
+			return utils.DetermineSQLError(err, "create upload part")
+		}
+		return nil
+	})
+}
+
+// RemoveLastPart undoes AppendPart for a part whose data could not be kept.
+func (r *uploadSessionRepository) RemoveLastPart(ctx context.Context, id uuid.UUID, partNumber int) error {
+	return r.db.WithContext(ctx).Transaction(func(tx *gorm.DB) error {
+		res := tx.Model(&types.UploadSession{}).
+			Where("id = ? AND total_parts = ?", id, partNumber).
+			Update("total_parts", partNumber-1)
+		if res.Error != nil {
+			return utils.DetermineSQLError(res.Error, "rewind upload session")
+		}
+		if res.RowsAffected == 0 {
+			return utils.ErrConflict.New("upload session %s moved past part %d", id, partNumber)
+		}
+		if err := tx.
+			Where("session_id = ? AND part_number = ?", id, partNumber).
+			Delete(&types.UploadPart{}).Error; err != nil {
+			return utils.DetermineSQLError(err, "delete upload part")
+		}
+		return nil
+	})
+}
+
+// Transition moves a session to status to, provided it is currently in one of
+// the from states.
+func (r *uploadSessionRepository) Transition(ctx context.Context, id uuid.UUID, from []string, to string) error {
+	res := r.db.WithContext(ctx).
+		Model(&types.UploadSession{}).
+		Where("id = ? AND status IN ?", id, from).
+		Updates(map[string]interface{}{"status": to, "error": ""})
+	if res.Error != nil {
+		return utils.DetermineSQLError(res.Error, "update upload session status")
+	}
+	if res.RowsAffected == 0 {
+		return utils.ErrConflict.New("upload session %s cannot become %s", id, to)
+	}
+	return nil
+}
+
+func (r *uploadSessionRepository) Finish(ctx context.Context, id uuid.UUID, status string, fileID *int, message string) error {
+	if err := r.db.WithContext(ctx).
+		Model(&types.UploadSession{}).
+		Where("id = ?", id).
+		Updates(map[string]interface{}{"status": status, "file_id": fileID, "error": message}).
+		Error; err != nil {
+		return utils.DetermineSQLError(err, "finish upload session")
+	}
+	return nil
+}
@@ -7,23 +7,26 @@ import (
 	"github.com/CustomCloudStorage/types"
 	"github.com/CustomCloudStorage/utils"
 	"gorm.io/gorm"
-	"gorm.io/gorm/clause"
 )
 
 type UserRepository interface {
 	GetByID(context.Context, int) (*types.User, error)
+	GetByEmail(ctx context.Context, email string) (*types.User, error)
 	Create(context.Context, *types.User) error
 	UpdateProfile(context.Context, *types.Profile, int) error
 	UpdateAccount(context.Context, *types.Account, int) error
 	UpdateCredentials(context.Context, *types.Credentials, int) error
 	Delete(context.Context, int) error
-	List(context.Context) ([]types.User, error)
+	List(ctx context.Context, page *types.PageQuery) ([]types.User, string, error)
 	UpdateUsedStorage(ctx context.Context, id int, newUsedStorage int64) error
-	ReserveStorage(ctx context.Context, userID int, size int64) error
-	ReleaseStorage(ctx context.Context, userID int, size int64) error
 	SumActiveStorageLimit(ctx context.Context) (int64, error)
 }
 
+var userSortKeys = map[string]sortKey[types.User]{
+	"id":         {"users.id", "integer", func(u *types.User) string { return cursorInt(int64(u.Id)) }},
+	"created_at": {"users.created_at", "timestamptz", func(u *types.User) string { return cursorTime(u.CreatedAt) }},
+}
+
 type userRepository struct {
 	db *gorm.DB
 }
@@ -47,6 +50,18 @@ func (r *userRepository) GetByID(ctx context.Context, id int) (*types.User, erro
 	return &user, nil
 }
 
+func (r *userRepository) GetByEmail(ctx context.Context, email string) (*types.User, error) {
+	var user types.User
+	if err := r.db.WithContext(ctx).
+		Preload("Profile").
+		Joins("JOIN profiles ON profiles.user_id = users.id").
+		Where("profiles.email = ?", email).
+		First(&user).Error; err != nil {
+		return nil, utils.DetermineSQLError(err, "get user by email")
+	}
+	return &user, nil
+}
+
 func (r *userRepository) Create(ctx context.Context, user *types.User) error {
 	if err := r.db.WithContext(ctx).
 		Create(user).Error; err != nil {
@@ -93,16 +108,11 @@ func (u *userRepository) Delete(ctx context.Context, id int) error {
 	return nil
 }
 
-func (r *userRepository) List(ctx context.Context) ([]types.User, error) {
-	var users []types.User
-	if err := r.db.WithContext(ctx).
+func (r *userRepository) List(ctx context.Context, page *types.PageQuery) ([]types.User, string, error) {
+	db := r.db.WithContext(ctx).
 		Preload("Profile").
-		Preload("Account").
-		Preload("Credentials").
-		Find(&users).Error; err != nil {
-		return nil, utils.DetermineSQLError(err, "list users")
-	}
-	return users, nil
+		Preload("Account")
+	return findPage(db, page, "users.id", userSortKeys, func(u *types.User) int { return u.Id }, "list users")
 }
 
 func (r *userRepository) UpdateUsedStorage(ctx context.Context, id int, newUsedStorage int64) error {
@@ -115,64 +125,6 @@ func (r *userRepository) UpdateUsedStorage(ctx context.Context, id int, newUsedS
 	return nil
 }
 
-func (r *userRepository) ReserveStorage(ctx context.Context, userID int, size int64) error {
-	err := r.db.WithContext(ctx).Transaction(func(tx *gorm.DB) error {
-		var user types.User
-		if err := tx.
-			Clauses(clause.Locking{Strength: "UPDATE"}).
-			Preload("Account").
-			First(&user, "id = ?", userID).Error; err != nil {
-			return utils.DetermineSQLError(err, "locking user for reserve storage")
-		}
-		acct := user.Account
-		if acct.UsedStorage+size > acct.StorageLimit {
-			return utils.ErrConflict.New(
-				"quota exceeded: used=%d, limit=%d",
-				acct.UsedStorage, acct.StorageLimit,
-			)
-		}
-		if err := tx.Model(&types.Account{}).
-			Where("user_id = ?", userID).
-			UpdateColumn("used_storage", gorm.Expr("used_storage + ?", size)).
-			Error; err != nil {
-			return utils.DetermineSQLError(err, "reserve storage update")
-		}
-		return nil
-	})
-	if err != nil {
-		return err
-	}
-	return nil
-}
-
-func (r *userRepository) ReleaseStorage(ctx context.Context, userID int, size int64) error {
-	err := r.db.WithContext(ctx).Transaction(func(tx *gorm.DB) error {
-		var user types.User
-		if err := tx.
-			Clauses(clause.Locking{Strength: "UPDATE"}).
-			Preload("Account").
-			First(&user, "id = ?", userID).Error; err != nil {
-			return utils.DetermineSQLError(err, "locking user for release storage")
-		}
-		acct := user.Account
-		newUsed := acct.UsedStorage - size
-		if newUsed < 0 {
-			newUsed = 0
-		}
-		if err := tx.Model(&types.Account{}).
-			Where("user_id = ?", userID).
-			UpdateColumn("used_storage", newUsed).
-			Error; err != nil {
-			return utils.DetermineSQLError(err, "release storage update")
-		}
-		return nil
-	})
-	if err != nil {
-		return err
-	}
-	return nil
-}
-
 func (r *userRepository) SumActiveStorageLimit(ctx context.Context) (int64, error) {
 	var total sql.NullInt64
 	err := r.db.WithContext(ctx).
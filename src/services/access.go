@@ -0,0 +1,125 @@
+package services
+
+import (
+	"context"
+
+	"github.com/CustomCloudStorage/repositories"
+	"github.com/CustomCloudStorage/types"
+	"github.com/CustomCloudStorage/utils"
+)
+
+type AccessService interface {
+	Grant(ctx context.Context, ownerID int, email string, grant *types.AccessGrant) (*types.AccessGrant, error)
+	Revoke(ctx context.Context, ownerID, grantID int) error
+	ListGrants(ctx context.Context, ownerID int) ([]types.AccessGrant, error)
+	SharedWithMe(ctx context.Context, userID int) ([]types.AccessGrant, error)
+	RequireFileEditor(ctx context.Context, userID int, file *types.File) error
+	RequireFolderEditor(ctx context.Context, userID int, folder *types.Folder) error
+}
+
+type accessService struct {
+	accessGrantRepository repositories.AccessGrantRepository
+	userRepository        repositories.UserRepository
+	fileRepository        repositories.FileRepository
+	folderRepository      repositories.FolderRepository
+}
+
+func NewAccessService(accessGrantRepo repositories.AccessGrantRepository, userRepo repositories.UserRepository, fileRepo repositories.FileRepository, folderRepo repositories.FolderRepository) AccessService {
+	return &accessService{
+		accessGrantRepository: accessGrantRepo,
+		userRepository:        userRepo,
+		fileRepository:        fileRepo,
+		folderRepository:      folderRepo,
+	}
+}
+
+func (s *accessService) Grant(ctx context.Context, ownerID int, email string, grant *types.AccessGrant) (*types.AccessGrant, error) {
+	if grant.Permission != types.PermissionViewer && grant.Permission != types.PermissionEditor {
+		return nil, utils.ErrBadRequest.New("permission must be %q or %q", types.PermissionViewer, types.PermissionEditor)
+	}
+
+	switch {
+	case grant.FileID != nil && grant.FolderID != nil, grant.FileID == nil && grant.FolderID == nil:
+		return nil, utils.ErrBadRequest.New("exactly one of file_id or folder_id must be set")
+	case grant.FileID != nil:
+		file, err := s.fileRepository.GetByID(ctx, *grant.FileID, ownerID)
+		if err != nil {
+			return nil, err
+		}
+		if file.UserID != ownerID {
+			return nil, utils.ErrForbidden.New("only the owner can share file %d", file.ID)
+		}
+	default:
+		folder, err := s.folderRepository.GetByID(ctx, *grant.FolderID, ownerID)
+		if err != nil {
+			return nil, err
+		}
+		if folder.UserID != ownerID {
+			return nil, utils.ErrForbidden.New("only the owner can share folder %d", folder.ID)
+		}
+	}
+
+	grantee, err := s.userRepository.GetByEmail(ctx, email)
+	if err != nil {
+		return nil, err
+	}
+	if grantee.Id == ownerID {
+		return nil, utils.ErrBadRequest.New("cannot grant access to yourself")
+	}
+
+	grant.OwnerID = ownerID
+	grant.GranteeID = grantee.Id
+	if err := s.accessGrantRepository.Create(ctx, grant); err != nil {
+		return nil, err
+	}
+	return grant, nil
+}
+
+func (s *accessService) Revoke(ctx context.Context, ownerID, grantID int) error {
+	return s.accessGrantRepository.Delete(ctx, grantID, ownerID)
+}
+
+func (s *accessService) ListGrants(ctx context.Context, ownerID int) ([]types.AccessGrant, error) {
+	return s.accessGrantRepository.ListByOwner(ctx, ownerID)
+}
+
+func (s *accessService) SharedWithMe(ctx context.Context, userID int) ([]types.AccessGrant, error) {
+	grants, err := s.accessGrantRepository.ListByGrantee(ctx, userID)
+	if err != nil {
+		return nil, err
+	}
+
+	visible := grants[:0]
+	for _, g := range grants {
+		if g.File != nil || g.Folder != nil {
+			visible = append(visible, g)
+		}
+	}
+	return visible, nil
+}
+
+func (s *accessService) RequireFileEditor(ctx context.Context, userID int, file *types.File) error {
+	permission, err := s.accessGrantRepository.PermissionForFile(ctx, userID, file)
+	if err != nil {
+		return err
+	}
+	if !canEdit(permission) {
+		return utils.ErrForbidden.New("no write access to file %d", file.ID)
+	}
+	return nil
+}
+
+func (s *accessService) RequireFolderEditor(ctx context.Context, userID int, folder *types.Folder) error {
+	permission, err := s.accessGrantRepository.PermissionForFolder(ctx, userID, folder)
+	if err != nil {
+		return err
+	}
+	if !canEdit(permission) {
+		return utils.ErrForbidden.New("no write access to folder %d", folder.ID)
+	}
+	return nil
+}
+
+func canEdit(permission string) bool {
+	return permission == types.PermissionOwner || permission == types.PermissionEditor
+}
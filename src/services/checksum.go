@@ -0,0 +1,82 @@
+package services
+
+import (
+	"bytes"
+	"crypto/md5"
+	"crypto/sha1"
+	"crypto/sha256"
+	"encoding/hex"
+	"hash"
+	"io"
+
+	"github.com/CustomCloudStorage/types"
+	"github.com/CustomCloudStorage/utils"
+)
+
+// ChecksumAlgorithms lists the digests accepted for uploaded chunks.
+var ChecksumAlgorithms = []string{"md5", "sha1", "sha256"}
+
+func newChecksumHash(algorithm string) (hash.Hash, error) {
+	switch algorithm {
+	case "md5":
+		return md5.New(), nil
+	case "sha1":
+		return sha1.New(), nil
+	case "sha256":
+		return sha256.New(), nil
+	default:
+		return nil, utils.ErrBadRequest.New("unsupported checksum algorithm %q", algorithm)
+	}
+}
+
+// checksumVerifier hashes data as it is written and compares the result with
+// the digests the client sent along with it.
+type checksumVerifier struct {
+	checksums []types.Checksum
+	hashes    []hash.Hash
+}
+
+func newChecksumVerifier(checksums []types.Checksum) (*checksumVerifier, error) {
+	v := &checksumVerifier{checksums: checksums}
+	for _, c := range checksums {
+		h, err := newChecksumHash(c.Algorithm)
+		if err != nil {
+			return nil, err
+		}
+		v.hashes = append(v.hashes, h)
+	}
+	return v, nil
+}
+
+// Writer returns w extended to feed every pending digest.
+func (v *checksumVerifier) Writer(w io.Writer) io.Writer {
+	if len(v.hashes) == 0 {
+		return w
+	}
+	writers := []io.Writer{w}
+	for _, h := range v.hashes {
+		writers = append(writers, h)
+	}
+	return io.MultiWriter(writers...)
+}
+
+// Verify reports an ErrChecksumMismatch naming what for the first digest that
+// does not match.
+func (v *checksumVerifier) Verify(what string) error {
+	for i, c := range v.checksums {
+		if sum := v.hashes[i].Sum(nil); !bytes.Equal(sum, c.Sum) {
+			return utils.ErrChecksumMismatch.New("%s %s mismatch: got %s, expected %s",
+				what, c.Algorithm, hex.EncodeToString(sum), hex.EncodeToString(c.Sum))
+		}
+	}
+	return nil
+}
+
+// parseSHA256 validates and normalizes a hex SHA-256 digest.
+func parseSHA256(raw string) (string, error) {
+	sum, err := hex.DecodeString(raw)
+	if err != nil || len(sum) != sha256.Size {
+		return "", utils.ErrBadRequest.New("invalid sha256 digest %q", raw)
+	}
+	return hex.EncodeToString(sum), nil
+}
@@ -7,6 +7,12 @@ type ServiceConfig struct {
 	Host           string
 	TotalStorageGB int
 	UserAllocGB    int
+
+	VersionsKeepLast int
+	VersionsKeepDays int
+
+	ThumbnailWebPEncoder string
+	MediaProbe           string
 }
 
 func (c ServiceConfig) TotalStorageBytes() int64 {
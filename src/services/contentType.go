@@ -0,0 +1,29 @@
+package services
+
+import (
+	"io"
+	"mime"
+	"strings"
+
+	"github.com/CustomCloudStorage/utils"
+	"github.com/gabriel-vasile/mimetype"
+)
+
+const defaultContentType = "application/octet-stream"
+
+// detectContentType types uploaded content by its magic bytes. The extension
+// is consulted only when the bytes are not conclusive, that is for plain text
+// and unknown binary data: a .csv and a .txt look the same, a PNG does not
+// become a PDF by being renamed.
+func detectContentType(extension string, r io.Reader) (string, error) {
+	detected, err := mimetype.DetectReader(r)
+	if err != nil {
+		return "", utils.ErrInternal.Wrap(err, "detect content type")
+	}
+	if detected.Is("text/plain") || detected.Is(defaultContentType) {
+		if byExtension := mime.TypeByExtension(strings.ToLower(extension)); byExtension != "" {
+			return byExtension, nil
+		}
+	}
+	return detected.String(), nil
+}
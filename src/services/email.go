@@ -3,38 +3,72 @@ package services
 import (
 	"bytes"
 	"context"
+	"encoding/json"
 	"fmt"
+	"os"
+	"runtime/debug"
 	"text/template"
+	"time"
 
 	"github.com/CustomCloudStorage/infrastructure/email"
 	"github.com/CustomCloudStorage/repositories"
+	"github.com/CustomCloudStorage/types"
 	"github.com/CustomCloudStorage/utils"
 )
 
+const (
+	// legacyEmailQueue is the list emails were queued in before the outbox.
+	legacyEmailQueue = "email_queue"
+
+	emailMaxAttempts     = 5
+	emailRetryBase       = time.Minute
+	emailRetryMax        = time.Hour
+	emailReadBlock       = 5 * time.Second
+	emailReclaimInterval = 30 * time.Second
+	emailReclaimBatch    = 100
+)
+
 type EmailService interface {
 	EnqueueEmail(ctx context.Context, tplName, to, subject string, data map[string]interface{}) error
-	ProcessEmailQueue(ctx context.Context) error
+	Stats(ctx context.Context) (*types.OutboxStats, error)
+}
+
+type emailMessage struct {
+	Template string                 `json:"template"`
+	To       string                 `json:"to"`
+	Subject  string                 `json:"subject"`
+	Data     map[string]interface{} `json:"data"`
 }
 
 type emailService struct {
-	redis     repositories.RedisCache
+	outbox    repositories.Outbox
 	mailer    *email.SMTPMailer
 	templates *template.Template
+	consumer  string
 }
 
-func NewEmailService(redis repositories.RedisCache, mailer *email.SMTPMailer, templates *template.Template) EmailService {
+// NewEmailService starts the delivery workers. A failed delivery is retried
+// with backoff before the email goes to the dead-letter list.
+func NewEmailService(outbox repositories.Outbox, mailer *email.SMTPMailer, templates *template.Template) EmailService {
+	host, err := os.Hostname()
+	if err != nil {
+		host = "unknown"
+	}
 	svc := &emailService{
-		redis:     redis,
+		outbox:    outbox,
 		mailer:    mailer,
 		templates: templates,
+		consumer:  fmt.Sprintf("%s:%d", host, os.Getpid()),
 	}
 
-	go func() {
-		if err := svc.ProcessEmailQueue(context.Background()); err != nil {
-			panic(err)
-		}
-	}()
+	if n, err := outbox.ImportList(context.Background(), legacyEmailQueue); err != nil {
+		fmt.Printf("email: import %s: %v\n", legacyEmailQueue, err)
+	} else if n > 0 {
+		fmt.Printf("email: %d queued emails moved to the outbox\n", n)
+	}
 
+	go svc.deliverLoop()
+	go svc.reclaimLoop()
 	return svc
 }
 
@@ -43,47 +77,102 @@ func (s *emailService) EnqueueEmail(ctx context.Context, tplName, to, subject st
 		return utils.ErrBadRequest.Wrap(nil, "template name and recipient must be provided")
 	}
 
-	job := struct {
-		Template string                 `json:"template"`
-		To       string                 `json:"to"`
-		Subject  string                 `json:"subject"`
-		Data     map[string]interface{} `json:"data"`
-	}{
+	msg := emailMessage{
 		Template: tplName,
 		To:       to,
 		Subject:  subject,
 		Data:     data,
 	}
-
-	err := s.redis.Enqueue(ctx, "email_queue", job)
-	if err != nil {
+	if err := s.outbox.Add(ctx, msg); err != nil {
 		return utils.ErrInternal.Wrap(err, fmt.Sprintf("enqueue email to %s failed", to))
 	}
 	return nil
 }
 
-func (s *emailService) ProcessEmailQueue(ctx context.Context) error {
+func (s *emailService) Stats(ctx context.Context) (*types.OutboxStats, error) {
+	return s.outbox.Stats(ctx)
+}
+
+func (s *emailService) deliverLoop() {
 	for {
-		var job struct {
-			Template string                 `json:"template"`
-			To       string                 `json:"to"`
-			Subject  string                 `json:"subject"`
-			Data     map[string]interface{} `json:"data"`
-		}
-		err := s.redis.Dequeue(ctx, "email_queue", &job)
+		msg, err := s.outbox.Read(context.Background(), s.consumer, emailReadBlock)
 		if err != nil {
-			return utils.ErrInternal.Wrap(err, fmt.Sprintf("dequeue from %s failed", "email_queue"))
+			fmt.Printf("email: read outbox: %v\n", err)
+			time.Sleep(emailReadBlock)
+			continue
 		}
+		if msg != nil {
+			s.deliver(msg)
+		}
+	}
+}
 
-		var buf bytes.Buffer
-		err = s.templates.ExecuteTemplate(&buf, job.Template, job.Data)
+func (s *emailService) reclaimLoop() {
+	ticker := time.NewTicker(emailReclaimInterval)
+	defer ticker.Stop()
+	for range ticker.C {
+		msgs, err := s.outbox.Reclaim(context.Background(), s.consumer, emailBackoff, emailReclaimBatch)
 		if err != nil {
-			return utils.ErrInternal.Wrap(err, fmt.Sprintf("render template %q failed", job.Template))
+			fmt.Printf("email: reclaim: %v\n", err)
+		}
+		for i := range msgs {
+			s.deliver(&msgs[i])
 		}
+	}
+}
 
-		err = s.mailer.Send(job.To, job.Subject, buf.String())
-		if err != nil {
-			return utils.ErrInternal.Wrap(err, fmt.Sprintf("send email to %s failed", job.To))
+// deliver sends msg and acknowledges it. A failed email stays pending for
+// reclaimLoop to retry, unless it cannot succeed or has no attempts left.
+func (s *emailService) deliver(msg *types.OutboxMessage) {
+	ctx := context.Background()
+	retry, err := s.send(msg)
+	if err == nil {
+		if err := s.outbox.Ack(ctx, msg.ID); err != nil {
+			fmt.Printf("email %s: ack: %v\n", msg.ID, err)
+		}
+		return
+	}
+
+	if retry && msg.Deliveries < emailMaxAttempts {
+		fmt.Printf("email %s: attempt %d failed, retrying in %s: %v\n", msg.ID, msg.Deliveries, emailBackoff(msg.Deliveries), err)
+		return
+	}
+	fmt.Printf("email %s: giving up after %d attempts: %v\n", msg.ID, msg.Deliveries, err)
+	if err := s.outbox.Bury(ctx, msg, err.Error()); err != nil {
+		fmt.Printf("email %s: bury: %v\n", msg.ID, err)
+	}
+}
+
+func (s *emailService) send(msg *types.OutboxMessage) (retry bool, err error) {
+	defer func() {
+		if r := recover(); r != nil {
+			retry, err = true, fmt.Errorf("panic: %v\n%s", r, debug.Stack())
 		}
+	}()
+
+	if msg.Deliveries > emailMaxAttempts {
+		return false, fmt.Errorf("worker lost the email on its last attempt")
+	}
+	var m emailMessage
+	if err := json.Unmarshal(msg.Payload, &m); err != nil {
+		return false, utils.ErrInternal.Wrap(err, "unmarshal email")
+	}
+	var buf bytes.Buffer
+	if err := s.templates.ExecuteTemplate(&buf, m.Template, m.Data); err != nil {
+		return false, utils.ErrInternal.Wrap(err, fmt.Sprintf("render template %q failed", m.Template))
+	}
+	if err := s.mailer.Send(m.To, m.Subject, buf.String()); err != nil {
+		return true, utils.ErrInternal.Wrap(err, fmt.Sprintf("send email to %s failed", m.To))
+	}
+	return false, nil
+}
+
+func emailBackoff(deliveries int64) time.Duration {
+	if deliveries < 1 {
+		return emailRetryBase
+	}
+	if deliveries >= 7 {
+		return emailRetryMax
 	}
+	return min(emailRetryBase<<(deliveries-1), emailRetryMax)
 }
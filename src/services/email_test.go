@@ -0,0 +1,26 @@
+package services
+
+import (
+	"testing"
+	"time"
+)
+
+func TestEmailBackoff(t *testing.T) {
+	tests := []struct {
+		deliveries int64
+		want       time.Duration
+	}{
+		{0, time.Minute},
+		{1, time.Minute},
+		{2, 2 * time.Minute},
+		{3, 4 * time.Minute},
+		{6, 32 * time.Minute},
+		{7, time.Hour},
+		{100, time.Hour},
+	}
+	for _, tt := range tests {
+		if got := emailBackoff(tt.deliveries); got != tt.want {
+			t.Errorf("emailBackoff(%d) = %s, want %s", tt.deliveries, got, tt.want)
+		}
+	}
+}
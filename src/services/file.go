@@ -6,19 +6,16 @@ import (
 	"crypto/sha256"
 	"encoding/base64"
 	"fmt"
-	"io"
-	"net/http"
 	"net/url"
-	"os"
-	"path/filepath"
 	"strconv"
 	"strings"
 	"time"
 
+	"github.com/CustomCloudStorage/infrastructure/storage"
 	"github.com/CustomCloudStorage/repositories"
 	"github.com/CustomCloudStorage/types"
 	"github.com/CustomCloudStorage/utils"
-	"github.com/disintegration/imaging"
+	"github.com/joomcode/errorx"
 )
 
 type FileService interface {
@@ -26,24 +23,34 @@ type FileService interface {
 	ValidateDownloadToken(token string) (userID, fileID int, err error)
 	DownloadFile(ctx context.Context, userID int, fileID int) (*types.DownloadedFile, error)
 	DeleteFile(ctx context.Context, id int, userID int) error
-	PreviewFile(ctx context.Context, userID, fileID int, w io.Writer) (time.Time, error)
+	RenameFile(ctx context.Context, userID, fileID int, name string, policy types.ConflictPolicy) (*types.File, error)
+	ReleaseFile(ctx context.Context, file *types.File) error
+	PreviewFile(ctx context.Context, userID, fileID, size int, formats []string) (*types.DownloadedFile, error)
 }
 
 type fileService struct {
 	userRepository   repositories.UserRepository
 	fileRepository   repositories.FileRepository
 	folderRepository repositories.FolderRepository
-	storageDir       string
+	trashRepository  repositories.TrashRepository
+	blobStore        storage.BlobStore
+	accessService    AccessService
+	thumbnailService ThumbnailService
+	names            *nameResolver
 	secret           string
 	host             string
 }
 
-func NewFileService(userRepo repositories.UserRepository, fileRepo repositories.FileRepository, folderRepo repositories.FolderRepository, cfg ServiceConfig) FileService {
+func NewFileService(userRepo repositories.UserRepository, fileRepo repositories.FileRepository, folderRepo repositories.FolderRepository, trashRepo repositories.TrashRepository, blobStore storage.BlobStore, accessService AccessService, thumbnailService ThumbnailService, cfg ServiceConfig) FileService {
 	return &fileService{
 		userRepository:   userRepo,
 		fileRepository:   fileRepo,
 		folderRepository: folderRepo,
-		storageDir:       cfg.StorageDir,
+		trashRepository:  trashRepo,
+		blobStore:        blobStore,
+		accessService:    accessService,
+		thumbnailService: thumbnailService,
+		names:            newNameResolver(fileRepo, folderRepo),
 		secret:           cfg.Secret,
 		host:             cfg.Host,
 	}
@@ -117,94 +124,94 @@ func (s *fileService) DownloadFile(ctx context.Context, userID int, fileID int)
 		return nil, err
 	}
 
-	path := filepath.Join(s.storageDir, fileMeta.PhysicalName)
-	f, err := os.Open(path)
+	f, err := s.blobStore.Get(ctx, fileMeta.PhysicalName)
 	if err != nil {
-		return nil, utils.DetermineFSError(err, "open file for download")
-	}
-
-	ext := strings.ToLower(fileMeta.Extension)
-	var ctype string
-	switch ext {
-	case ".jpg", ".jpeg":
-		ctype = "image/jpeg"
-	case ".png":
-		ctype = "image/png"
-	case ".gif":
-		ctype = "image/gif"
-	case ".mp4":
-		ctype = "video/mp4"
-	case ".webm":
-		ctype = "video/webm"
-	case ".pdf":
-		ctype = "application/pdf"
-	default:
-		buf := make([]byte, 512)
-		n, err := f.Read(buf)
-		if err != nil && err != io.EOF {
-			_ = f.Close()
-			return nil, utils.ErrInternal.Wrap(err, "detect content type")
-		}
-		ctype = http.DetectContentType(buf[:n])
-		f.Seek(0, io.SeekStart)
+		return nil, err
 	}
 
 	return &types.DownloadedFile{
 		Reader:      f,
 		FileName:    fileMeta.Name + fileMeta.Extension,
-		ContentType: ctype,
+		ContentType: fileMeta.ContentType,
 		FileSize:    fileMeta.Size,
 		ModTime:     fileMeta.UpdatedAt,
+		ETag:        contentETag(fileMeta.SHA256, fileMeta.ID, fileMeta.Version),
 	}, nil
 }
 
 func (s *fileService) DeleteFile(ctx context.Context, id int, userID int) error {
 	file, err := s.fileRepository.GetByID(ctx, id, userID)
+	if errorx.IsOfType(err, utils.ErrNotFound) {
+		file, err = s.trashRepository.GetTrashedFile(ctx, userID, id)
+	}
 	if err != nil {
 		return err
 	}
+	return s.ReleaseFile(ctx, file)
+}
 
-	filePath := filepath.Join(s.storageDir, file.PhysicalName)
-	if err := os.Remove(filePath); err != nil {
-		return utils.DetermineFSError(err, "remove file")
+func (s *fileService) RenameFile(ctx context.Context, userID, fileID int, name string, policy types.ConflictPolicy) (*types.File, error) {
+	if name == "" {
+		return nil, utils.ErrBadRequest.New("file name must not be empty")
 	}
-
-	user, err := s.userRepository.GetByID(ctx, userID)
+	file, err := s.fileRepository.GetByID(ctx, fileID, userID)
 	if err != nil {
-		return err
+		return nil, err
+	}
+	if err := s.accessService.RequireFileEditor(ctx, userID, file); err != nil {
+		return nil, err
 	}
-	newUsed := user.Account.UsedStorage - file.Size
-	if newUsed < 0 {
-		newUsed = 0
+
+	name, replace, err := s.names.fileName(ctx, file.UserID, file.FolderID, name, file.Extension, file.ID, policy)
+	if err != nil {
+		return nil, err
 	}
-	if err := s.userRepository.UpdateUsedStorage(ctx, userID, newUsed); err != nil {
-		return err
+	if err := s.fileRepository.UpdateName(ctx, file.ID, file.UserID, name, replace); err != nil {
+		return nil, err
 	}
+	file.Name = name
+	return file, nil
+}
 
-	return nil
+// ReleaseFile deletes the file for good, refunding the size of its versions
+// and dropping their blob references in one transaction.
+func (s *fileService) ReleaseFile(ctx context.Context, file *types.File) error {
+	return s.trashRepository.HardDeleteFileByID(ctx, file.ID, func(hash string) error {
+		return deleteBlob(ctx, s.blobStore, hash)
+	})
 }
 
-func (s *fileService) PreviewFile(ctx context.Context, userID, fileID int, w io.Writer) (time.Time, error) {
+func (s *fileService) PreviewFile(ctx context.Context, userID, fileID, size int, formats []string) (*types.DownloadedFile, error) {
 	meta, err := s.fileRepository.GetByID(ctx, fileID, userID)
 	if err != nil {
-		return time.Time{}, err
+		return nil, err
 	}
-
-	srcPath := filepath.Join(s.storageDir, meta.PhysicalName)
-	info, err := os.Stat(srcPath)
-	if err != nil {
-		return time.Time{}, utils.DetermineFSError(err, "stat file for preview")
+	if !strings.HasPrefix(meta.ContentType, "image/") {
+		return nil, utils.ErrBadRequest.New("no preview available for %s files", meta.ContentType)
 	}
 
-	img, err := imaging.Open(srcPath)
-	if err != nil {
-		return info.ModTime(), utils.ErrInternal.Wrap(err, "open image for preview")
-	}
-	preview := imaging.Thumbnail(img, 200, 200, imaging.Lanczos)
+	return s.thumbnailService.Get(ctx, meta.PhysicalName, size, formats)
+}
 
-	if err := imaging.Encode(w, preview, imaging.JPEG); err != nil {
-		return info.ModTime(), utils.ErrInternal.Wrap(err, "encode thumbnail")
+func releaseBlob(ctx context.Context, blobRepo repositories.BlobRepository, blobStore storage.BlobStore, hash string) error {
+	return blobRepo.Release(ctx, hash, func() error {
+		return deleteBlob(ctx, blobStore, hash)
+	})
+}
+
+func deleteBlob(ctx context.Context, blobStore storage.BlobStore, hash string) error {
+	if err := blobStore.Delete(ctx, hash); err != nil && !errorx.IsOfType(err, utils.ErrNotFound) {
+		return err
 	}
+	deleteThumbnails(ctx, blobStore, hash)
+	return nil
+}
 
-	return info.ModTime(), nil
+// contentETag derives a strong ETag from the content hash, falling back to the
+// file version for content stored before hashes were recorded.
+func contentETag(sha256 string, fileID, version int) string {
+	if sha256 != "" {
+		return `"` + sha256 + `"`
+	}
+	return fmt.Sprintf(`"%d-v%d"`, fileID, version)
 }
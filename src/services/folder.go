@@ -5,31 +5,143 @@ import (
 	"context"
 	"fmt"
 	"io"
-	"os"
-	"path/filepath"
 
+	"github.com/CustomCloudStorage/infrastructure/storage"
 	"github.com/CustomCloudStorage/repositories"
+	"github.com/CustomCloudStorage/types"
 	"github.com/CustomCloudStorage/utils"
 )
 
 type FolderService interface {
 	DownloadFolder(ctx context.Context, userID, folderID int) (io.ReadCloser, string, error)
+	ListChildren(ctx context.Context, userID int, folderID *int) (*types.FolderChildren, error)
+	CreateFolder(ctx context.Context, userID int, folder *types.Folder, policy types.ConflictPolicy) error
+	UpdateFolder(ctx context.Context, userID, folderID int, req *types.Folder, policy types.ConflictPolicy) (*types.Folder, error)
 }
 
 type folderService struct {
 	folderRepository repositories.FolderRepository
 	fileRepository   repositories.FileRepository
-	storageDir       string
+	blobStore        storage.BlobStore
+	accessService    AccessService
+	transferService  TransferService
+	names            *nameResolver
 }
 
-func NewFolderService(fileRepo repositories.FileRepository, folderRepo repositories.FolderRepository, cfg ServiceConfig) FolderService {
+func NewFolderService(fileRepo repositories.FileRepository, folderRepo repositories.FolderRepository, blobStore storage.BlobStore, accessService AccessService, transferService TransferService) FolderService {
 	return &folderService{
 		fileRepository:   fileRepo,
 		folderRepository: folderRepo,
-		storageDir:       cfg.StorageDir,
+		blobStore:        blobStore,
+		accessService:    accessService,
+		transferService:  transferService,
+		names:            newNameResolver(fileRepo, folderRepo),
 	}
 }
 
+func (s *folderService) CreateFolder(ctx context.Context, userID int, folder *types.Folder, policy types.ConflictPolicy) error {
+	if folder.Name == "" {
+		return utils.ErrBadRequest.New("folder name must not be empty")
+	}
+	ownerID := userID
+	if folder.ParentID != nil {
+		parent, err := s.folderRepository.GetByID(ctx, *folder.ParentID, userID)
+		if err != nil {
+			return err
+		}
+		if parent.DeletedAt != nil {
+			return utils.ErrNotFound.New("folder %d is in the trash", parent.ID)
+		}
+		if err := s.accessService.RequireFolderEditor(ctx, userID, parent); err != nil {
+			return err
+		}
+		ownerID = parent.UserID
+	}
+
+	name, replace, err := s.names.folderName(ctx, ownerID, folder.ParentID, folder.Name, 0, 0, policy)
+	if err != nil {
+		return err
+	}
+	folder.ID = 0
+	folder.UserID = ownerID
+	folder.Name = name
+	folder.DeletedAt = nil
+	return s.folderRepository.Create(ctx, folder, replace)
+}
+
+func (s *folderService) UpdateFolder(ctx context.Context, userID, folderID int, req *types.Folder, policy types.ConflictPolicy) (*types.Folder, error) {
+	if req.Name == "" {
+		return nil, utils.ErrBadRequest.New("folder name must not be empty")
+	}
+	current, err := s.folderRepository.GetByID(ctx, folderID, userID)
+	if err != nil {
+		return nil, err
+	}
+	if err := s.accessService.RequireFolderEditor(ctx, userID, current); err != nil {
+		return nil, err
+	}
+	if !req.UpdatedAt.Equal(current.UpdatedAt) {
+		return nil, utils.ErrConflict.New("the folder was modified by another process")
+	}
+	if !sameFolderID(current.ParentID, req.ParentID) {
+		if err := s.transferService.CheckFolderTarget(ctx, userID, current, req.ParentID); err != nil {
+			return nil, err
+		}
+	}
+
+	name, replace, err := s.names.folderName(ctx, current.UserID, req.ParentID, req.Name, current.ID, current.ID, policy)
+	if err != nil {
+		return nil, err
+	}
+	current.Name = name
+	current.ParentID = req.ParentID
+
+	if err := s.folderRepository.Update(ctx, current, replace); err != nil {
+		return nil, err
+	}
+	return current, nil
+}
+
+func sameFolderID(a, b *int) bool {
+	if a == nil || b == nil {
+		return a == b
+	}
+	return *a == *b
+}
+
+func (s *folderService) ListChildren(ctx context.Context, userID int, folderID *int) (*types.FolderChildren, error) {
+	out := &types.FolderChildren{Path: []types.Breadcrumb{}}
+	ownerID := userID
+	if folderID != nil {
+		folder, err := s.folderRepository.GetByID(ctx, *folderID, userID)
+		if err != nil {
+			return nil, err
+		}
+		if folder.DeletedAt != nil {
+			return nil, utils.ErrNotFound.New("folder %d is in the trash", folder.ID)
+		}
+		path, err := s.folderRepository.ListAncestors(ctx, userID, folder.ID)
+		if err != nil {
+			return nil, err
+		}
+		out.Folder = folder
+		out.Path = path
+		ownerID = folder.UserID
+	}
+
+	folders, err := s.folderRepository.ListChildrenWithStats(ctx, ownerID, folderID)
+	if err != nil {
+		return nil, err
+	}
+	files, err := s.fileRepository.ListByFolder(ctx, ownerID, folderID)
+	if err != nil {
+		return nil, err
+	}
+	out.Folders = folders
+	out.Files = files
+	return out, nil
+}
+
 func (s *folderService) DownloadFolder(ctx context.Context, userID, folderID int) (io.ReadCloser, string, error) {
 	folder, err := s.folderRepository.GetByID(ctx, folderID, userID)
 	if err != nil {
@@ -55,26 +167,21 @@ func (s *folderService) DownloadFolder(ctx context.Context, userID, folderID int
 				return
 			default:
 			}
-			path := filepath.Join(s.storageDir, f.PhysicalName)
-			in, err := os.Open(path)
+			info, err := s.blobStore.Stat(ctx, f.PhysicalName)
 			if err != nil {
-				pw.CloseWithError(utils.DetermineFSError(err, "open "+path))
+				pw.CloseWithError(err)
 				return
 			}
-			info, err := in.Stat()
+			in, err := s.blobStore.Get(ctx, f.PhysicalName)
 			if err != nil {
-				in.Close()
-				pw.CloseWithError(utils.DetermineFSError(err, "stat "+path))
+				pw.CloseWithError(err)
 				return
 			}
-			header, err := zip.FileInfoHeader(info)
-			if err != nil {
-				in.Close()
-				pw.CloseWithError(utils.ErrInternal.Wrap(err, "create zip header for %s", path))
-				return
+			header := &zip.FileHeader{
+				Name:     f.RelativePath,
+				Method:   zip.Deflate,
+				Modified: info.ModTime,
 			}
-			header.Name = f.RelativePath
-			header.Method = zip.Deflate
 			zwEntry, err := zw.CreateHeader(header)
 			if err != nil {
 				in.Close()
@@ -83,7 +190,7 @@ func (s *folderService) DownloadFolder(ctx context.Context, userID, folderID int
 			}
 			if _, err := io.Copy(zwEntry, in); err != nil {
 				in.Close()
-				pw.CloseWithError(utils.ErrInternal.Wrap(err, "write data for %s", path))
+				pw.CloseWithError(utils.ErrInternal.Wrap(err, "write data for %s", f.PhysicalName))
 				return
 			}
 			in.Close()
@@ -0,0 +1,291 @@
+package services
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/CustomCloudStorage/infrastructure/storage"
+	"github.com/CustomCloudStorage/repositories"
+	"github.com/CustomCloudStorage/types"
+	"github.com/CustomCloudStorage/utils"
+	"github.com/google/uuid"
+	"github.com/joomcode/errorx"
+)
+
+// fsckGrace keeps fsck away from data that an upload in progress may still
+// reference: blobs and temp entries younger than this are never reported.
+const fsckGrace = time.Hour
+
+// FsckService cross-checks the database, the blob store and the upload temp dir.
+type FsckService interface {
+	Check(ctx context.Context, repair bool) (*types.FsckReport, error)
+}
+
+type fsckService struct {
+	blobRepository          repositories.BlobRepository
+	versionRepository       repositories.FileVersionRepository
+	trashRepository         repositories.TrashRepository
+	uploadSessionRepository repositories.UploadSessionRepository
+	reservationRepository   repositories.StorageReservationRepository
+	blobStore               storage.BlobStore
+	temp                    string
+}
+
+func NewFsckService(blobRepo repositories.BlobRepository, versionRepo repositories.FileVersionRepository, trashRepo repositories.TrashRepository, uploadSessionRepo repositories.UploadSessionRepository, reservationRepo repositories.StorageReservationRepository, blobStore storage.BlobStore, cfg ServiceConfig) FsckService {
+	return &fsckService{
+		blobRepository:          blobRepo,
+		versionRepository:       versionRepo,
+		trashRepository:         trashRepo,
+		uploadSessionRepository: uploadSessionRepo,
+		reservationRepository:   reservationRepo,
+		blobStore:               blobStore,
+		temp:                    cfg.Temp,
+	}
+}
+
+// Check reports every inconsistency it finds and, with repair set, fixes them.
+// Repair failures are collected in the report instead of aborting.
+func (s *fsckService) Check(ctx context.Context, repair bool) (*types.FsckReport, error) {
+	report := &types.FsckReport{
+		CheckedAt:       time.Now(),
+		Repaired:        repair,
+		OrphanedBlobs:   []types.OrphanedBlob{},
+		MissingBlobs:    []types.MissingBlob{},
+		StaleTemp:       []types.StaleTempEntry{},
+		UsageMismatches: []types.StorageUsage{},
+	}
+	fail := func(format string, args ...interface{}) {
+		report.Errors = append(report.Errors, fmt.Sprintf(format, args...))
+	}
+
+	if err := s.checkBlobs(ctx, report); err != nil {
+		return nil, err
+	}
+	if err := s.checkTemp(ctx, report); err != nil {
+		return nil, err
+	}
+	if repair {
+		s.repairBlobs(ctx, report, fail)
+		for _, e := range report.StaleTemp {
+			if err := os.RemoveAll(e.Path); err != nil {
+				fail("remove %s: %v", e.Path, err)
+			}
+		}
+	}
+
+	usage, err := s.reservationRepository.Reconcile(ctx, !repair)
+	if err != nil {
+		return nil, err
+	}
+	report.UsageMismatches = append(report.UsageMismatches, usage...)
+	return report, nil
+}
+
+func (s *fsckService) checkBlobs(ctx context.Context, report *types.FsckReport) error {
+	stored, err := s.blobStore.List(ctx, "")
+	if err != nil {
+		return err
+	}
+	names, err := s.versionRepository.ListPhysicalNames(ctx)
+	if err != nil {
+		return err
+	}
+	unreferenced, err := s.blobRepository.ListUnreferenced(ctx, time.Now().Add(-fsckGrace))
+	if err != nil {
+		return err
+	}
+
+	referenced := make(map[string]bool, len(names))
+	for _, name := range names {
+		referenced[name] = true
+	}
+	tracked := make(map[string]bool, len(unreferenced))
+	for _, b := range unreferenced {
+		tracked[b.Hash] = true
+	}
+	present := make(map[string]bool, len(stored))
+	cutoff := time.Now().Add(-fsckGrace)
+	for _, b := range stored {
+		present[b.Key] = true
+		if source, ok := thumbnailSource(b.Key); ok {
+			// Thumbnails live as long as the content they were made from.
+			if !referenced[source] && !b.ModTime.After(cutoff) {
+				report.OrphanedBlobs = append(report.OrphanedBlobs, types.OrphanedBlob{
+					Key:    b.Key,
+					Size:   b.Size,
+					Stored: true,
+				})
+			}
+			continue
+		}
+		if referenced[b.Key] || b.ModTime.After(cutoff) {
+			continue
+		}
+		report.OrphanedBlobs = append(report.OrphanedBlobs, types.OrphanedBlob{
+			Key:     b.Key,
+			Size:    b.Size,
+			Stored:  true,
+			Tracked: tracked[b.Key],
+		})
+		delete(tracked, b.Key)
+	}
+	for _, b := range unreferenced {
+		if tracked[b.Hash] {
+			report.OrphanedBlobs = append(report.OrphanedBlobs, types.OrphanedBlob{
+				Key:     b.Hash,
+				Size:    b.Size,
+				Tracked: true,
+			})
+		}
+	}
+
+	var missing []string
+	for _, name := range names {
+		if !present[name] {
+			missing = append(missing, name)
+		}
+	}
+	versions, err := s.versionRepository.ListByPhysicalNames(ctx, missing)
+	if err != nil {
+		return err
+	}
+	for _, v := range versions {
+		report.MissingBlobs = append(report.MissingBlobs, types.MissingBlob{
+			Key:     v.PhysicalName,
+			FileID:  v.FileID,
+			UserID:  v.UserID,
+			Version: v.Version,
+		})
+	}
+	return s.markCurrent(ctx, report.MissingBlobs)
+}
+
+func (s *fsckService) markCurrent(ctx context.Context, missing []types.MissingBlob) error {
+	latest := make(map[int]int)
+	for _, m := range missing {
+		if _, ok := latest[m.FileID]; ok {
+			continue
+		}
+		versions, err := s.versionRepository.ListByFile(ctx, m.FileID)
+		if err != nil {
+			return err
+		}
+		if len(versions) > 0 {
+			latest[m.FileID] = versions[0].Version
+		}
+	}
+	for i := range missing {
+		missing[i].Current = missing[i].Version == latest[missing[i].FileID]
+	}
+	return nil
+}
+
+func (s *fsckService) repairBlobs(ctx context.Context, report *types.FsckReport, fail func(string, ...interface{})) {
+	for _, b := range report.OrphanedBlobs {
+		if b.Stored {
+			if err := s.blobStore.Delete(ctx, b.Key); err != nil && !errorx.IsOfType(err, utils.ErrNotFound) {
+				fail("delete orphaned blob %s: %v", b.Key, err)
+				continue
+			}
+		}
+		if b.Tracked {
+			if err := s.blobRepository.Forget(ctx, b.Key); err != nil {
+				fail("forget orphaned blob %s: %v", b.Key, err)
+			}
+		}
+	}
+
+	lost := make(map[string]bool)
+	dropped := make(map[int]bool)
+	for _, m := range report.MissingBlobs {
+		lost[m.Key] = true
+		if m.Current {
+			dropped[m.FileID] = true
+		}
+	}
+	for fileID := range dropped {
+		if err := s.dropFile(ctx, fileID, lost); err != nil {
+			fail("delete file %d with missing content: %v", fileID, err)
+		}
+	}
+	for _, m := range report.MissingBlobs {
+		if dropped[m.FileID] {
+			continue
+		}
+		versions, err := s.versionRepository.ListByFile(ctx, m.FileID)
+		if err != nil {
+			fail("list versions of file %d: %v", m.FileID, err)
+			continue
+		}
+		for _, v := range versions {
+			if v.Version != m.Version {
+				continue
+			}
+			if _, err := s.versionRepository.Delete(ctx, v.ID); err != nil {
+				fail("delete version %d of file %d: %v", v.Version, m.FileID, err)
+			}
+		}
+	}
+	for key := range lost {
+		if err := s.blobRepository.Forget(ctx, key); err != nil {
+			fail("forget missing blob %s: %v", key, err)
+		}
+	}
+}
+
+func (s *fsckService) dropFile(ctx context.Context, fileID int, lost map[string]bool) error {
+	return s.trashRepository.HardDeleteFileByID(ctx, fileID, func(hash string) error {
+		if lost[hash] {
+			return nil
+		}
+		return deleteBlob(ctx, s.blobStore, hash)
+	})
+}
+
+func (s *fsckService) checkTemp(ctx context.Context, report *types.FsckReport) error {
+	entries, err := os.ReadDir(s.temp)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil
+		}
+		return utils.DetermineFSError(err, fmt.Sprintf("read temp dir %s", s.temp))
+	}
+
+	cutoff := time.Now().Add(-fsckGrace)
+	for _, e := range entries {
+		info, err := e.Info()
+		if err != nil || info.ModTime().After(cutoff) {
+			continue
+		}
+		entry := types.StaleTempEntry{
+			Path:    filepath.Join(s.temp, e.Name()),
+			ModTime: info.ModTime(),
+		}
+
+		if !e.IsDir() {
+			if strings.HasPrefix(e.Name(), "direct-") && strings.HasSuffix(e.Name(), ".upload") {
+				report.StaleTemp = append(report.StaleTemp, entry)
+			}
+			continue
+		}
+		id, err := uuid.Parse(e.Name())
+		if err != nil {
+			continue
+		}
+		session, err := s.uploadSessionRepository.GetByID(ctx, id)
+		switch {
+		case errorx.IsOfType(err, utils.ErrNotFound):
+		case err != nil:
+			return err
+		case session.Status != types.UploadSessionCompleted:
+			continue
+		}
+		entry.SessionID = id.String()
+		report.StaleTemp = append(report.StaleTemp, entry)
+	}
+	return nil
+}
@@ -0,0 +1,313 @@
+package services
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"image"
+	"io"
+	"os"
+	"os/exec"
+	"strconv"
+	"strings"
+	"sync/atomic"
+	"time"
+
+	"github.com/CustomCloudStorage/infrastructure/storage"
+	"github.com/CustomCloudStorage/jobs"
+	"github.com/CustomCloudStorage/repositories"
+	"github.com/CustomCloudStorage/types"
+	"github.com/CustomCloudStorage/utils"
+	"github.com/ledongthuc/pdf"
+	"github.com/rwcarlsen/goexif/exif"
+)
+
+const (
+	metadataSweepInterval = 10 * time.Minute
+	metadataSweepBatch    = 100
+)
+
+var metadataJob = jobs.Kind[metadataPayload]{
+	Name:    "metadata.extract",
+	Options: jobs.Options{MaxAttempts: 3},
+}
+
+type metadataPayload struct {
+	FileID       int    `json:"file_id"`
+	PhysicalName string `json:"physical_name"`
+	ContentType  string `json:"content_type"`
+	Size         int64  `json:"size"`
+}
+
+// MediaProbe reads duration, codecs and resolution of audio and video files.
+// It is an interface so that the external tool behind it can be swapped.
+type MediaProbe interface {
+	Probe(ctx context.Context, path string) (*types.FileMetadata, error)
+}
+
+// MetadataService fills files' metadata column in the background, from the
+// upload queue and a periodic sweep of files that still have none.
+type MetadataService interface {
+	Enqueue(ctx context.Context, file *types.File) error
+}
+
+type metadataService struct {
+	fileRepository repositories.FileRepository
+	blobStore      storage.BlobStore
+	probe          MediaProbe
+	queue          *jobs.Queue
+	sweptID        atomic.Int64
+}
+
+// NewMetadataService registers the extraction job and the sweep. A nil probe
+// leaves audio and video files without metadata.
+func NewMetadataService(fileRepo repositories.FileRepository, blobStore storage.BlobStore, probe MediaProbe, queue *jobs.Queue) MetadataService {
+	svc := &metadataService{
+		fileRepository: fileRepo,
+		blobStore:      blobStore,
+		probe:          probe,
+		queue:          queue,
+	}
+	jobs.Register(queue, metadataJob, svc.run)
+	queue.Every("metadata.sweep", metadataSweepInterval, svc.sweep)
+	return svc
+}
+
+func (s *metadataService) Enqueue(ctx context.Context, file *types.File) error {
+	if !s.supports(file.ContentType) {
+		return nil
+	}
+	return jobs.Enqueue(ctx, s.queue, metadataJob, metadataPayload{
+		FileID:       file.ID,
+		PhysicalName: file.PhysicalName,
+		ContentType:  file.ContentType,
+		Size:         file.Size,
+	})
+}
+
+func (s *metadataService) contentTypes() []string {
+	prefixes := []string{"image/", "application/pdf"}
+	if s.probe != nil {
+		prefixes = append(prefixes, "video/", "audio/")
+	}
+	return prefixes
+}
+
+func (s *metadataService) supports(contentType string) bool {
+	for _, prefix := range s.contentTypes() {
+		if strings.HasPrefix(contentType, prefix) {
+			return true
+		}
+	}
+	return false
+}
+
+func (s *metadataService) run(ctx context.Context, payload metadataPayload) error {
+	meta, err := s.extract(ctx, payload)
+	if err != nil {
+		return err
+	}
+	return s.fileRepository.SetMetadata(ctx, payload.FileID, payload.PhysicalName, meta)
+}
+
+func (s *metadataService) sweep(ctx context.Context) error {
+	// Files that fail stay without metadata, so each sweep carries on after the
+	// last file it saw and starts over once it reaches the end.
+	files, err := s.fileRepository.ListWithoutMetadata(ctx, s.contentTypes(), int(s.sweptID.Load()), metadataSweepBatch)
+	if err != nil {
+		return err
+	}
+	if len(files) < metadataSweepBatch {
+		s.sweptID.Store(0)
+	} else {
+		s.sweptID.Store(int64(files[len(files)-1].ID))
+	}
+	for _, f := range files {
+		payload := metadataPayload{
+			FileID:       f.ID,
+			PhysicalName: f.PhysicalName,
+			ContentType:  f.ContentType,
+			Size:         f.Size,
+		}
+		if err := s.run(ctx, payload); err != nil {
+			fmt.Printf("metadata: file %d: %v\n", f.ID, err)
+		}
+	}
+	return nil
+}
+
+func (s *metadataService) extract(ctx context.Context, file metadataPayload) (*types.FileMetadata, error) {
+	f, cleanup, err := openLocal(ctx, s.blobStore, file.PhysicalName)
+	if err != nil {
+		return nil, err
+	}
+	defer cleanup()
+
+	meta := &types.FileMetadata{}
+	switch {
+	case strings.HasPrefix(file.ContentType, "image/"):
+		err = imageMetadata(f, meta)
+	case file.ContentType == "application/pdf":
+		err = pdfMetadata(f, file.Size, meta)
+	case s.probe != nil:
+		var probed *types.FileMetadata
+		if probed, err = s.probe.Probe(ctx, f.Name()); err == nil {
+			meta = probed
+		}
+	}
+	if err != nil {
+		fmt.Printf("metadata: file %d: %v\n", file.FileID, err)
+		return &types.FileMetadata{}, nil
+	}
+	return meta, nil
+}
+
+func imageMetadata(f *os.File, meta *types.FileMetadata) error {
+	cfg, _, err := image.DecodeConfig(f)
+	if err != nil {
+		return err
+	}
+	meta.Width, meta.Height = cfg.Width, cfg.Height
+
+	if _, err := f.Seek(0, io.SeekStart); err != nil {
+		return err
+	}
+	x, err := exif.Decode(f)
+	if err != nil {
+		// Most formats and plenty of JPEGs carry no EXIF at all.
+		return nil
+	}
+	if t, err := x.DateTime(); err == nil {
+		t = t.UTC().Truncate(time.Second)
+		meta.TakenAt = &t
+	}
+	meta.CameraMake = exifString(x, exif.Make)
+	meta.CameraModel = exifString(x, exif.Model)
+	if lat, long, err := x.LatLong(); err == nil {
+		meta.Location = &types.GeoLocation{Latitude: lat, Longitude: long}
+	}
+	// Orientations 5 to 8 are rotated by a quarter turn.
+	if tag, err := x.Get(exif.Orientation); err == nil {
+		if o, err := tag.Int(0); err == nil && o >= 5 && o <= 8 {
+			meta.Width, meta.Height = meta.Height, meta.Width
+		}
+	}
+	return nil
+}
+
+func exifString(x *exif.Exif, name exif.FieldName) string {
+	tag, err := x.Get(name)
+	if err != nil {
+		return ""
+	}
+	v, err := tag.StringVal()
+	if err != nil {
+		return ""
+	}
+	return strings.TrimSpace(strings.TrimRight(v, "\x00"))
+}
+
+// The PDF reader panics on malformed documents.
+func pdfMetadata(f *os.File, size int64, meta *types.FileMetadata) (err error) {
+	defer func() {
+		if r := recover(); r != nil {
+			err = fmt.Errorf("malformed pdf: %v", r)
+		}
+	}()
+	r, err := pdf.NewReader(f, size)
+	if err != nil {
+		return err
+	}
+	meta.PageCount = r.NumPage()
+	meta.Title = strings.TrimSpace(r.Trailer().Key("Info").Key("Title").Text())
+	return nil
+}
+
+func openLocal(ctx context.Context, blobStore storage.BlobStore, key string) (*os.File, func(), error) {
+	r, err := blobStore.Get(ctx, key)
+	if err != nil {
+		return nil, nil, err
+	}
+	if f, ok := r.(*os.File); ok {
+		return f, func() { f.Close() }, nil
+	}
+	defer r.Close()
+
+	tmp, err := os.CreateTemp("", "blob-*")
+	if err != nil {
+		return nil, nil, utils.DetermineFSError(err, "create blob spool file")
+	}
+	cleanup := func() {
+		tmp.Close()
+		os.Remove(tmp.Name())
+	}
+	if _, err := io.Copy(tmp, r); err != nil {
+		cleanup()
+		return nil, nil, utils.ErrInternal.Wrap(err, "spool blob %s", key)
+	}
+	if _, err := tmp.Seek(0, io.SeekStart); err != nil {
+		cleanup()
+		return nil, nil, utils.DetermineFSError(err, "rewind blob spool file")
+	}
+	return tmp, cleanup, nil
+}
+
+type ffprobe struct {
+	path string
+}
+
+// NewFFprobe returns a MediaProbe running the ffprobe binary at path, or nil
+// when it cannot be found.
+func NewFFprobe(path string) MediaProbe {
+	if path == "" {
+		return nil
+	}
+	resolved, err := exec.LookPath(path)
+	if err != nil {
+		fmt.Printf("metadata: video probing disabled: %v\n", err)
+		return nil
+	}
+	return &ffprobe{path: resolved}
+}
+
+type ffprobeOutput struct {
+	Streams []struct {
+		CodecType string `json:"codec_type"`
+		CodecName string `json:"codec_name"`
+		Width     int    `json:"width"`
+		Height    int    `json:"height"`
+	} `json:"streams"`
+	Format struct {
+		Duration string            `json:"duration"`
+		Tags     map[string]string `json:"tags"`
+	} `json:"format"`
+}
+
+func (p *ffprobe) Probe(ctx context.Context, path string) (*types.FileMetadata, error) {
+	out, err := exec.CommandContext(ctx, p.path,
+		"-v", "error",
+		"-print_format", "json",
+		"-show_format", "-show_streams",
+		path,
+	).Output()
+	if err != nil {
+		return nil, fmt.Errorf("ffprobe: %w", err)
+	}
+	var probed ffprobeOutput
+	if err := json.Unmarshal(out, &probed); err != nil {
+		return nil, fmt.Errorf("ffprobe output: %w", err)
+	}
+
+	meta := &types.FileMetadata{Title: probed.Format.Tags["title"]}
+	meta.DurationSeconds, _ = strconv.ParseFloat(probed.Format.Duration, 64)
+	for _, st := range probed.Streams {
+		switch {
+		case st.CodecType == "video" && meta.VideoCodec == "":
+			meta.VideoCodec = st.CodecName
+			meta.Width, meta.Height = st.Width, st.Height
+		case st.CodecType == "audio" && meta.AudioCodec == "":
+			meta.AudioCodec = st.CodecName
+		}
+	}
+	return meta, nil
+}
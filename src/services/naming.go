@@ -0,0 +1,122 @@
+package services
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/CustomCloudStorage/repositories"
+	"github.com/CustomCloudStorage/types"
+	"github.com/CustomCloudStorage/utils"
+	"github.com/joomcode/errorx"
+)
+
+// maxRenameAttempts bounds the " (n)" suffix search of the rename policy.
+const maxRenameAttempts = 1000
+
+// nameResolver applies a types.ConflictPolicy when an item is about to take a
+// name that is already used by a live sibling. With the overwrite policy it
+// returns the sibling, which the caller sends to the trash in the same
+// transaction that writes the item.
+type nameResolver struct {
+	fileRepository   repositories.FileRepository
+	folderRepository repositories.FolderRepository
+}
+
+func newNameResolver(fileRepo repositories.FileRepository, folderRepo repositories.FolderRepository) *nameResolver {
+	return &nameResolver{
+		fileRepository:   fileRepo,
+		folderRepository: folderRepo,
+	}
+}
+
+// fileName resolves a name clash in the target folder according to policy.
+// selfID is the file being moved, which never conflicts with itself.
+func (n *nameResolver) fileName(ctx context.Context, ownerID int, folderID *int, name, extension string, selfID int, policy types.ConflictPolicy) (string, *int, error) {
+	taken := func(candidate string) (*types.File, error) {
+		existing, err := n.fileRepository.GetByName(ctx, ownerID, folderID, candidate, extension)
+		if errorx.IsOfType(err, utils.ErrNotFound) {
+			return nil, nil
+		}
+		if err != nil {
+			return nil, err
+		}
+		if existing.ID == selfID {
+			return nil, nil
+		}
+		return existing, nil
+	}
+
+	existing, err := taken(name)
+	if err != nil || existing == nil {
+		return name, nil, err
+	}
+
+	switch policy {
+	case types.ConflictOverwrite:
+		return name, &existing.ID, nil
+	case types.ConflictRename:
+		for i := 1; i <= maxRenameAttempts; i++ {
+			candidate := fmt.Sprintf("%s (%d)", name, i)
+			existing, err := taken(candidate)
+			if err != nil {
+				return "", nil, err
+			}
+			if existing == nil {
+				return candidate, nil, nil
+			}
+		}
+		return "", nil, utils.ErrConflict.New("no free name for %q", name+extension)
+	default:
+		return "", nil, utils.ErrConflict.New("file %q already exists in the target folder", name+extension)
+	}
+}
+
+// folderName is the folder counterpart of fileName. sourceID is the folder
+// being moved or copied; overwriting one of its ancestors is refused since
+// that would send the source itself to the trash.
+func (n *nameResolver) folderName(ctx context.Context, ownerID int, parentID *int, name string, sourceID, selfID int, policy types.ConflictPolicy) (string, *int, error) {
+	taken := func(candidate string) (*types.Folder, error) {
+		existing, err := n.folderRepository.GetByName(ctx, ownerID, parentID, candidate)
+		if errorx.IsOfType(err, utils.ErrNotFound) {
+			return nil, nil
+		}
+		if err != nil {
+			return nil, err
+		}
+		if existing.ID == selfID {
+			return nil, nil
+		}
+		return existing, nil
+	}
+
+	existing, err := taken(name)
+	if err != nil || existing == nil {
+		return name, nil, err
+	}
+
+	switch policy {
+	case types.ConflictOverwrite:
+		inside, err := n.folderRepository.IsInSubtree(ctx, ownerID, existing.ID, sourceID)
+		if err != nil {
+			return "", nil, err
+		}
+		if inside {
+			return "", nil, utils.ErrConflict.New("cannot overwrite folder %q that contains the source", name)
+		}
+		return name, &existing.ID, nil
+	case types.ConflictRename:
+		for i := 1; i <= maxRenameAttempts; i++ {
+			candidate := fmt.Sprintf("%s (%d)", name, i)
+			existing, err := taken(candidate)
+			if err != nil {
+				return "", nil, err
+			}
+			if existing == nil {
+				return candidate, nil, nil
+			}
+		}
+		return "", nil, utils.ErrConflict.New("no free name for %q", name)
+	default:
+		return "", nil, utils.ErrConflict.New("folder %q already exists in the target folder", name)
+	}
+}
@@ -0,0 +1,134 @@
+package services
+
+import (
+	"context"
+	"testing"
+
+	"github.com/CustomCloudStorage/repositories"
+	"github.com/CustomCloudStorage/types"
+	"github.com/CustomCloudStorage/utils"
+	"github.com/joomcode/errorx"
+)
+
+// namedFiles answers GetByName from a map of taken names to file IDs.
+type namedFiles struct {
+	repositories.FileRepository
+	taken map[string]int
+}
+
+func (f *namedFiles) GetByName(ctx context.Context, userID int, folderID *int, name, extension string) (*types.File, error) {
+	id, ok := f.taken[name+extension]
+	if !ok {
+		return nil, utils.ErrNotFound.New("file %q not found", name+extension)
+	}
+	return &types.File{ID: id, UserID: userID, FolderID: folderID, Name: name, Extension: extension}, nil
+}
+
+// namedFolders answers GetByName from a map of taken names to folder IDs and
+// IsInSubtree from a map of folder IDs to their parent.
+type namedFolders struct {
+	repositories.FolderRepository
+	taken   map[string]int
+	parents map[int]int
+}
+
+func (f *namedFolders) GetByName(ctx context.Context, userID int, parentID *int, name string) (*types.Folder, error) {
+	id, ok := f.taken[name]
+	if !ok {
+		return nil, utils.ErrNotFound.New("folder %q not found", name)
+	}
+	return &types.Folder{ID: id, UserID: userID, ParentID: parentID, Name: name}, nil
+}
+
+func (f *namedFolders) IsInSubtree(ctx context.Context, userID, rootID, folderID int) (bool, error) {
+	for id := folderID; id != 0; id = f.parents[id] {
+		if id == rootID {
+			return true, nil
+		}
+	}
+	return false, nil
+}
+
+func TestFileName(t *testing.T) {
+	files := &namedFiles{taken: map[string]int{
+		"report.pdf":     1,
+		"report (1).pdf": 2,
+		"notes.txt":      3,
+	}}
+	names := newNameResolver(files, &namedFolders{})
+
+	tests := []struct {
+		name        string
+		file        string
+		ext         string
+		selfID      int
+		policy      types.ConflictPolicy
+		wantName    string
+		wantReplace int
+		wantErr     *errorx.Type
+	}{
+		{"free name", "summary", ".pdf", 0, types.ConflictFail, "summary", 0, nil},
+		{"fail", "report", ".pdf", 0, types.ConflictFail, "", 0, utils.ErrConflict},
+		{"rename skips taken suffixes", "report", ".pdf", 0, types.ConflictRename, "report (2)", 0, nil},
+		{"rename first suffix", "notes", ".txt", 0, types.ConflictRename, "notes (1)", 0, nil},
+		{"overwrite", "report", ".pdf", 0, types.ConflictOverwrite, "report", 1, nil},
+		{"own name", "report", ".pdf", 1, types.ConflictFail, "report", 0, nil},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			name, replace, err := names.fileName(context.Background(), 1, nil, tt.file, tt.ext, tt.selfID, tt.policy)
+			checkResolved(t, name, replace, err, tt.wantName, tt.wantReplace, tt.wantErr)
+		})
+	}
+}
+
+func TestFolderName(t *testing.T) {
+	folders := &namedFolders{
+		taken:   map[string]int{"photos": 10, "photos (1)": 11, "docs": 20},
+		parents: map[int]int{21: 20, 22: 21},
+	}
+	names := newNameResolver(&namedFiles{}, folders)
+
+	tests := []struct {
+		name        string
+		folder      string
+		sourceID    int
+		policy      types.ConflictPolicy
+		wantName    string
+		wantReplace int
+		wantErr     *errorx.Type
+	}{
+		{"rename", "photos", 30, types.ConflictRename, "photos (2)", 0, nil},
+		{"overwrite", "photos", 30, types.ConflictOverwrite, "photos", 10, nil},
+		{"overwrite an ancestor of the source", "docs", 22, types.ConflictOverwrite, "", 0, utils.ErrConflict},
+		{"fail", "docs", 30, types.ConflictFail, "", 0, utils.ErrConflict},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			name, replace, err := names.folderName(context.Background(), 1, nil, tt.folder, tt.sourceID, 0, tt.policy)
+			checkResolved(t, name, replace, err, tt.wantName, tt.wantReplace, tt.wantErr)
+		})
+	}
+}
+
+func checkResolved(t *testing.T, name string, replace *int, err error, wantName string, wantReplace int, wantErr *errorx.Type) {
+	t.Helper()
+	if wantErr != nil {
+		if !errorx.IsOfType(err, wantErr) {
+			t.Fatalf("err = %v, want %s", err, wantErr)
+		}
+		return
+	}
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if name != wantName {
+		t.Errorf("name = %q, want %q", name, wantName)
+	}
+	switch {
+	case wantReplace == 0 && replace != nil:
+		t.Errorf("replace = %d, want none", *replace)
+	case wantReplace != 0 && (replace == nil || *replace != wantReplace):
+		t.Errorf("replace = %v, want %d", replace, wantReplace)
+	}
+}
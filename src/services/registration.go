@@ -2,9 +2,9 @@ package services
 
 import (
 	"context"
-	"fmt"
 	"time"
 
+	"github.com/CustomCloudStorage/jobs"
 	"github.com/CustomCloudStorage/repositories"
 	"github.com/CustomCloudStorage/types"
 	"github.com/CustomCloudStorage/utils"
@@ -29,7 +29,7 @@ type registrationService struct {
 	cfg                    ServiceConfig
 }
 
-func NewRegistrationService(registrationRepo repositories.RegistrationRepository, userRepo repositories.UserRepository, emailService EmailService, cfg ServiceConfig) RegistrationService {
+func NewRegistrationService(registrationRepo repositories.RegistrationRepository, userRepo repositories.UserRepository, emailService EmailService, queue *jobs.Queue, cfg ServiceConfig) RegistrationService {
 	svc := &registrationService{
 		registrationRepository: registrationRepo,
 		userRepository:         userRepo,
@@ -37,19 +37,14 @@ func NewRegistrationService(registrationRepo repositories.RegistrationRepository
 		cfg:                    cfg,
 	}
 
-	go func() {
-		ticker := time.NewTicker(purgeInterval)
-		defer ticker.Stop()
-		for range ticker.C {
-			if err := svc.registrationRepository.DeleteExpired(context.Background(), codeTTL); err != nil {
-				fmt.Printf("[registrationService] delete expired registrations error: %v\n", err)
-			}
-		}
-	}()
-
+	queue.Every("registrations.purge", purgeInterval, svc.purge)
 	return svc
 }
 
+func (s *registrationService) purge(ctx context.Context) error {
+	return s.registrationRepository.DeleteExpired(ctx, codeTTL)
+}
+
 func (s *registrationService) Register(ctx context.Context, email, password string) error {
 	hash, err := utils.HashPassword(password)
 	if err != nil {
@@ -0,0 +1,266 @@
+package services
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/base64"
+	"fmt"
+	"io"
+	"time"
+
+	"github.com/CustomCloudStorage/repositories"
+	"github.com/CustomCloudStorage/types"
+	"github.com/CustomCloudStorage/utils"
+	"github.com/joomcode/errorx"
+	"golang.org/x/crypto/bcrypt"
+)
+
+const shareTokenBytes = 24
+
+type ShareService interface {
+	CreateShare(ctx context.Context, userID int, link *types.ShareLink, password string) (*types.ShareLink, string, error)
+	ListShares(ctx context.Context, userID int) ([]types.ShareLink, error)
+	RevokeShare(ctx context.Context, userID, shareID int) error
+	OpenShare(ctx context.Context, token, password string) (*types.ShareLink, error)
+	BrowseShare(ctx context.Context, share *types.ShareLink, folderID *int) (*types.SharedContents, error)
+	DownloadSharedFile(ctx context.Context, share *types.ShareLink, fileID *int, count func(*types.DownloadedFile) bool) (*types.DownloadedFile, error)
+	DownloadSharedFolder(ctx context.Context, share *types.ShareLink, folderID *int, head bool) (io.ReadCloser, string, error)
+}
+
+type shareService struct {
+	shareLinkRepository repositories.ShareLinkRepository
+	fileRepository      repositories.FileRepository
+	folderRepository    repositories.FolderRepository
+	fileService         FileService
+	folderService       FolderService
+	host                string
+}
+
+func NewShareService(shareLinkRepo repositories.ShareLinkRepository, fileRepo repositories.FileRepository, folderRepo repositories.FolderRepository, fileService FileService, folderService FolderService, cfg ServiceConfig) ShareService {
+	return &shareService{
+		shareLinkRepository: shareLinkRepo,
+		fileRepository:      fileRepo,
+		folderRepository:    folderRepo,
+		fileService:         fileService,
+		folderService:       folderService,
+		host:                cfg.Host,
+	}
+}
+
+func (s *shareService) CreateShare(ctx context.Context, userID int, link *types.ShareLink, password string) (*types.ShareLink, string, error) {
+	switch {
+	case link.FileID != nil && link.FolderID != nil, link.FileID == nil && link.FolderID == nil:
+		return nil, "", utils.ErrBadRequest.New("exactly one of file_id or folder_id must be set")
+	case link.FileID != nil:
+		file, err := s.fileRepository.GetByID(ctx, *link.FileID, userID)
+		if err != nil {
+			return nil, "", err
+		}
+		if file.UserID != userID {
+			return nil, "", utils.ErrForbidden.New("only the owner can share file %d", file.ID)
+		}
+	default:
+		folder, err := s.folderRepository.GetByID(ctx, *link.FolderID, userID)
+		if err != nil {
+			return nil, "", err
+		}
+		if folder.UserID != userID {
+			return nil, "", utils.ErrForbidden.New("only the owner can share folder %d", folder.ID)
+		}
+	}
+	if link.ExpiresAt != nil && !link.ExpiresAt.After(time.Now()) {
+		return nil, "", utils.ErrBadRequest.New("expires_at must be in the future")
+	}
+	if link.MaxDownloads != nil && *link.MaxDownloads <= 0 {
+		return nil, "", utils.ErrBadRequest.New("max_downloads must be positive")
+	}
+
+	if password != "" {
+		hash, err := utils.HashPassword(password)
+		if err != nil {
+			return nil, "", err
+		}
+		link.PasswordHash = hash
+	}
+
+	raw := make([]byte, shareTokenBytes)
+	if _, err := rand.Read(raw); err != nil {
+		return nil, "", utils.ErrInternal.Wrap(err, "generate share token")
+	}
+	link.Token = base64.RawURLEncoding.EncodeToString(raw)
+	link.UserID = userID
+	link.DownloadCount = 0
+	link.RevokedAt = nil
+
+	if err := s.shareLinkRepository.Create(ctx, link); err != nil {
+		return nil, "", err
+	}
+	return link, fmt.Sprintf("%s/public/shares/%s", s.host, link.Token), nil
+}
+
+func (s *shareService) ListShares(ctx context.Context, userID int) ([]types.ShareLink, error) {
+	return s.shareLinkRepository.ListByUserID(ctx, userID)
+}
+
+func (s *shareService) RevokeShare(ctx context.Context, userID, shareID int) error {
+	return s.shareLinkRepository.Revoke(ctx, shareID, userID, time.Now())
+}
+
+func (s *shareService) OpenShare(ctx context.Context, token, password string) (*types.ShareLink, error) {
+	share, err := s.shareLinkRepository.GetByToken(ctx, token)
+	if err != nil {
+		return nil, err
+	}
+	if share.RevokedAt != nil {
+		return nil, utils.ErrForbidden.New("share link revoked")
+	}
+	if share.ExpiresAt != nil && time.Now().After(*share.ExpiresAt) {
+		return nil, utils.ErrForbidden.New("share link expired")
+	}
+	if err := s.checkTarget(ctx, share); err != nil {
+		return nil, err
+	}
+	if share.PasswordHash != "" {
+		if password == "" {
+			return nil, utils.ErrUnauthorized.New("share link password required")
+		}
+		if err := bcrypt.CompareHashAndPassword([]byte(share.PasswordHash), []byte(password)); err != nil {
+			return nil, utils.ErrUnauthorized.New("invalid share link password")
+		}
+	}
+	return share, nil
+}
+
+// BrowseShare lists the shared item. The download limit is enforced here and,
+// for downloads, when one is counted, so that resuming a counted download
+// keeps working once the limit is reached.
+func (s *shareService) BrowseShare(ctx context.Context, share *types.ShareLink, folderID *int) (*types.SharedContents, error) {
+	if share.MaxDownloads != nil && share.DownloadCount >= *share.MaxDownloads {
+		return nil, utils.ErrForbidden.New("share link download limit reached")
+	}
+	if share.FileID != nil {
+		file, err := s.fileRepository.GetByID(ctx, *share.FileID, share.UserID)
+		if err != nil {
+			return nil, err
+		}
+		return &types.SharedContents{
+			Share: types.NewPublicShareLink(share),
+			File:  types.NewPublicFile(file),
+		}, nil
+	}
+
+	target, err := s.resolveFolder(ctx, share, folderID)
+	if err != nil {
+		return nil, err
+	}
+	folder, err := s.folderRepository.GetByID(ctx, target, share.UserID)
+	if err != nil {
+		return nil, err
+	}
+	folders, err := s.folderRepository.ListChildren(ctx, share.UserID, &target)
+	if err != nil {
+		return nil, err
+	}
+	files, err := s.fileRepository.ListByFolder(ctx, share.UserID, &target)
+	if err != nil {
+		return nil, err
+	}
+
+	return &types.SharedContents{
+		Share:   types.NewPublicShareLink(share),
+		Folder:  types.NewPublicFolder(folder),
+		Folders: types.NewPublicFolders(folders),
+		Files:   types.NewPublicFiles(files),
+	}, nil
+}
+
+// DownloadSharedFile opens a file of the share. The download is counted when
+// count reports that serving the opened file hands out new content.
+func (s *shareService) DownloadSharedFile(ctx context.Context, share *types.ShareLink, fileID *int, count func(*types.DownloadedFile) bool) (*types.DownloadedFile, error) {
+	var target int
+	switch {
+	case share.FileID != nil:
+		target = *share.FileID
+	case fileID == nil:
+		return nil, utils.ErrBadRequest.New("file_id is required for folder shares")
+	default:
+		file, err := s.fileRepository.GetByID(ctx, *fileID, share.UserID)
+		if err != nil {
+			return nil, err
+		}
+		if file.FolderID == nil {
+			return nil, utils.ErrNotFound.New("file %d is not in the shared folder", *fileID)
+		}
+		if _, err := s.resolveFolder(ctx, share, file.FolderID); err != nil {
+			return nil, err
+		}
+		target = file.ID
+	}
+
+	dfile, err := s.fileService.DownloadFile(ctx, share.UserID, target)
+	if err != nil {
+		return nil, err
+	}
+	if count(dfile) {
+		if err := s.shareLinkRepository.IncrementDownloads(ctx, share.ID); err != nil {
+			dfile.Reader.(io.Closer).Close()
+			return nil, err
+		}
+	}
+	return dfile, nil
+}
+
+// DownloadSharedFolder streams the folder as a zip archive and counts the
+// download. For head only the archive name is looked up.
+func (s *shareService) DownloadSharedFolder(ctx context.Context, share *types.ShareLink, folderID *int, head bool) (io.ReadCloser, string, error) {
+	if share.FolderID == nil {
+		return nil, "", utils.ErrBadRequest.New("share link does not point to a folder")
+	}
+	target, err := s.resolveFolder(ctx, share, folderID)
+	if err != nil {
+		return nil, "", err
+	}
+	if head {
+		folder, err := s.folderRepository.GetByID(ctx, target, share.UserID)
+		if err != nil {
+			return nil, "", err
+		}
+		return nil, folder.Name + ".zip", nil
+	}
+
+	if err := s.shareLinkRepository.IncrementDownloads(ctx, share.ID); err != nil {
+		return nil, "", err
+	}
+	return s.folderService.DownloadFolder(ctx, share.UserID, target)
+}
+
+// checkTarget rejects a share whose file or folder has been deleted.
+func (s *shareService) checkTarget(ctx context.Context, share *types.ShareLink) error {
+	var err error
+	if share.FileID != nil {
+		_, err = s.fileRepository.GetByID(ctx, *share.FileID, share.UserID)
+	} else if share.FolderID != nil {
+		_, err = s.folderRepository.GetByID(ctx, *share.FolderID, share.UserID)
+	}
+	if errorx.IsOfType(err, utils.ErrNotFound) {
+		return utils.ErrNotFound.New("shared item no longer exists")
+	}
+	return err
+}
+
+func (s *shareService) resolveFolder(ctx context.Context, share *types.ShareLink, folderID *int) (int, error) {
+	if share.FolderID == nil {
+		return 0, utils.ErrBadRequest.New("share link does not point to a folder")
+	}
+	if folderID == nil || *folderID == *share.FolderID {
+		return *share.FolderID, nil
+	}
+	inside, err := s.folderRepository.IsInSubtree(ctx, share.UserID, *share.FolderID, *folderID)
+	if err != nil {
+		return 0, err
+	}
+	if !inside {
+		return 0, utils.ErrNotFound.New("folder %d is not in the shared folder", *folderID)
+	}
+	return *folderID, nil
+}
@@ -0,0 +1,259 @@
+package services
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"image"
+	"image/png"
+	"os"
+	"os/exec"
+	"path"
+	"path/filepath"
+	"strconv"
+	"strings"
+
+	"github.com/CustomCloudStorage/infrastructure/storage"
+	"github.com/CustomCloudStorage/jobs"
+	"github.com/CustomCloudStorage/types"
+	"github.com/CustomCloudStorage/utils"
+	"github.com/disintegration/imaging"
+	"github.com/joomcode/errorx"
+	_ "golang.org/x/image/webp"
+)
+
+const (
+	ThumbnailJPEG = "jpeg"
+	ThumbnailWebP = "webp"
+
+	DefaultThumbnailSize = 200
+
+	// thumbnailPrefix is where thumbnails live in the blob store, under the
+	// key of the content they were made from.
+	thumbnailPrefix  = "thumbnails/"
+	thumbnailQuality = 80
+)
+
+var thumbnailJob = jobs.Kind[thumbnailPayload]{
+	Name:    "thumbnails.generate",
+	Options: jobs.Options{MaxAttempts: 3},
+}
+
+type thumbnailPayload struct {
+	Key string `json:"key"`
+}
+
+// ThumbnailSizes are the bounding boxes, in pixels, thumbnails are made in.
+var ThumbnailSizes = []int{64, DefaultThumbnailSize, 800}
+
+// ThumbnailService renders image content into thumbnails and caches them in
+// the blob store. Thumbnails are keyed by the content's blob key, so every
+// file version gets its own and identical content shares them.
+type ThumbnailService interface {
+	// Get returns the thumbnail of the content stored under key in the first
+	// of the requested formats that is supported, generating it if needed.
+	Get(ctx context.Context, key string, size int, formats []string) (*types.DownloadedFile, error)
+	// Enqueue schedules the thumbnails of key to be generated in the
+	// background.
+	Enqueue(ctx context.Context, key string) error
+}
+
+type thumbnailService struct {
+	blobStore   storage.BlobStore
+	webpEncoder string
+	queue       *jobs.Queue
+}
+
+// NewThumbnailService registers the generation job. WebP thumbnails are
+// encoded with the cwebp tool named by cfg.ThumbnailWebPEncoder; without it
+// only JPEG is offered.
+func NewThumbnailService(blobStore storage.BlobStore, queue *jobs.Queue, cfg ServiceConfig) ThumbnailService {
+	svc := &thumbnailService{
+		blobStore: blobStore,
+		queue:     queue,
+	}
+	if cfg.ThumbnailWebPEncoder != "" {
+		encoder, err := exec.LookPath(cfg.ThumbnailWebPEncoder)
+		if err != nil {
+			fmt.Printf("thumbnails: WebP disabled: %v\n", err)
+		} else {
+			svc.webpEncoder = encoder
+		}
+	}
+	jobs.Register(queue, thumbnailJob, svc.run)
+	return svc
+}
+
+func (s *thumbnailService) Get(ctx context.Context, key string, size int, formats []string) (*types.DownloadedFile, error) {
+	if !validThumbnailSize(size) {
+		return nil, utils.ErrBadRequest.New("thumbnail size must be one of %v", ThumbnailSizes)
+	}
+	format, err := s.pickFormat(formats)
+	if err != nil {
+		return nil, err
+	}
+
+	thumbKey := thumbnailKey(key, size, format)
+	info, err := s.blobStore.Stat(ctx, thumbKey)
+	if errorx.IsOfType(err, utils.ErrNotFound) {
+		if err := s.generate(ctx, key); err != nil {
+			return nil, err
+		}
+		info, err = s.blobStore.Stat(ctx, thumbKey)
+	}
+	if err != nil {
+		return nil, err
+	}
+	r, err := s.blobStore.Get(ctx, thumbKey)
+	if err != nil {
+		return nil, err
+	}
+
+	return &types.DownloadedFile{
+		Reader:      r,
+		FileName:    path.Base(thumbKey),
+		ContentType: "image/" + format,
+		FileSize:    info.Size,
+		ModTime:     info.ModTime,
+		ETag:        fmt.Sprintf(`"%s-%d-%s"`, key, size, format),
+	}, nil
+}
+
+func (s *thumbnailService) Enqueue(ctx context.Context, key string) error {
+	return jobs.Enqueue(ctx, s.queue, thumbnailJob, thumbnailPayload{Key: key})
+}
+
+func (s *thumbnailService) run(ctx context.Context, payload thumbnailPayload) error {
+	err := s.generate(ctx, payload.Key)
+	if errorx.IsOfType(err, utils.ErrBadRequest) {
+		// Not an image after all; there is nothing to retry.
+		return nil
+	}
+	return err
+}
+
+func (s *thumbnailService) formats() []string {
+	if s.webpEncoder != "" {
+		return []string{ThumbnailWebP, ThumbnailJPEG}
+	}
+	return []string{ThumbnailJPEG}
+}
+
+func (s *thumbnailService) pickFormat(requested []string) (string, error) {
+	supported := s.formats()
+	for _, want := range requested {
+		for _, have := range supported {
+			if want == have {
+				return have, nil
+			}
+		}
+	}
+	return "", utils.ErrBadRequest.New("thumbnail format must be one of %v", supported)
+}
+
+func (s *thumbnailService) generate(ctx context.Context, key string) error {
+	src, err := s.blobStore.Get(ctx, key)
+	if err != nil {
+		return err
+	}
+	img, err := imaging.Decode(src, imaging.AutoOrientation(true))
+	src.Close()
+	if errors.Is(err, image.ErrFormat) {
+		return utils.ErrBadRequest.New("no preview available for this file type")
+	}
+	if err != nil {
+		return utils.ErrInternal.Wrap(err, "decode image for thumbnail")
+	}
+
+	for _, size := range ThumbnailSizes {
+		thumb := imaging.Fit(img, size, size, imaging.Lanczos)
+		for _, format := range s.formats() {
+			data, err := s.encode(ctx, thumb, format)
+			if err != nil {
+				return err
+			}
+			if err := s.blobStore.Put(ctx, thumbnailKey(key, size, format), bytes.NewReader(data), int64(len(data))); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+func (s *thumbnailService) encode(ctx context.Context, img image.Image, format string) ([]byte, error) {
+	if format == ThumbnailWebP {
+		return s.encodeWebP(ctx, img)
+	}
+	var buf bytes.Buffer
+	if err := imaging.Encode(&buf, img, imaging.JPEG, imaging.JPEGQuality(thumbnailQuality)); err != nil {
+		return nil, utils.ErrInternal.Wrap(err, "encode jpeg thumbnail")
+	}
+	return buf.Bytes(), nil
+}
+
+// Go has no WebP encoder of its own.
+func (s *thumbnailService) encodeWebP(ctx context.Context, img image.Image) ([]byte, error) {
+	dir, err := os.MkdirTemp("", "thumbnail-*")
+	if err != nil {
+		return nil, utils.DetermineFSError(err, "create thumbnail work dir")
+	}
+	defer os.RemoveAll(dir)
+
+	in := filepath.Join(dir, "in.png")
+	out := filepath.Join(dir, "out.webp")
+	f, err := os.Create(in)
+	if err != nil {
+		return nil, utils.DetermineFSError(err, "create thumbnail input")
+	}
+	err = png.Encode(f, img)
+	if cerr := f.Close(); err == nil {
+		err = cerr
+	}
+	if err != nil {
+		return nil, utils.ErrInternal.Wrap(err, "write thumbnail input")
+	}
+
+	cmd := exec.CommandContext(ctx, s.webpEncoder, "-quiet", "-q", strconv.Itoa(thumbnailQuality), in, "-o", out)
+	if output, err := cmd.CombinedOutput(); err != nil {
+		return nil, utils.ErrInternal.Wrap(err, "cwebp: %s", strings.TrimSpace(string(output)))
+	}
+	data, err := os.ReadFile(out)
+	if err != nil {
+		return nil, utils.DetermineFSError(err, "read webp thumbnail")
+	}
+	return data, nil
+}
+
+func deleteThumbnails(ctx context.Context, blobStore storage.BlobStore, key string) {
+	thumbs, err := blobStore.List(ctx, thumbnailPrefix+key+"/")
+	if err != nil {
+		fmt.Printf("thumbnails: list %s: %v\n", key, err)
+		return
+	}
+	for _, t := range thumbs {
+		if err := blobStore.Delete(ctx, t.Key); err != nil && !errorx.IsOfType(err, utils.ErrNotFound) {
+			fmt.Printf("thumbnails: delete %s: %v\n", t.Key, err)
+		}
+	}
+}
+
+func thumbnailKey(key string, size int, format string) string {
+	return fmt.Sprintf("%s%s/%d.%s", thumbnailPrefix, key, size, format)
+}
+
+func thumbnailSource(thumbKey string) (string, bool) {
+	if !strings.HasPrefix(thumbKey, thumbnailPrefix) {
+		return "", false
+	}
+	return path.Dir(strings.TrimPrefix(thumbKey, thumbnailPrefix)), true
+}
+
+func validThumbnailSize(size int) bool {
+	for _, s := range ThumbnailSizes {
+		if s == size {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,235 @@
+package services
+
+import (
+	"context"
+
+	"github.com/CustomCloudStorage/infrastructure/storage"
+	"github.com/CustomCloudStorage/repositories"
+	"github.com/CustomCloudStorage/types"
+	"github.com/CustomCloudStorage/utils"
+	"github.com/google/uuid"
+)
+
+type TransferService interface {
+	MoveFile(ctx context.Context, userID, fileID int, folderID *int, policy types.ConflictPolicy) (*types.File, error)
+	CopyFile(ctx context.Context, userID, fileID int, folderID *int, policy types.ConflictPolicy) (*types.File, error)
+	MoveFolder(ctx context.Context, userID, folderID int, parentID *int, policy types.ConflictPolicy) (*types.Folder, error)
+	CopyFolder(ctx context.Context, userID, folderID int, parentID *int, policy types.ConflictPolicy) (*types.Folder, error)
+	CheckFolderTarget(ctx context.Context, userID int, folder *types.Folder, parentID *int) error
+}
+
+type transferService struct {
+	reservationRepository repositories.StorageReservationRepository
+	fileRepository        repositories.FileRepository
+	folderRepository      repositories.FolderRepository
+	blobRepository        repositories.BlobRepository
+	blobStore             storage.BlobStore
+	accessService         AccessService
+	names                 *nameResolver
+}
+
+func NewTransferService(reservationRepo repositories.StorageReservationRepository, fileRepo repositories.FileRepository, folderRepo repositories.FolderRepository, blobRepo repositories.BlobRepository, blobStore storage.BlobStore, accessService AccessService) TransferService {
+	return &transferService{
+		reservationRepository: reservationRepo,
+		fileRepository:        fileRepo,
+		folderRepository:      folderRepo,
+		blobRepository:        blobRepo,
+		blobStore:             blobStore,
+		accessService:         accessService,
+		names:                 newNameResolver(fileRepo, folderRepo),
+	}
+}
+
+func (s *transferService) MoveFile(ctx context.Context, userID, fileID int, folderID *int, policy types.ConflictPolicy) (*types.File, error) {
+	file, err := s.liveFile(ctx, userID, fileID)
+	if err != nil {
+		return nil, err
+	}
+	if err := s.accessService.RequireFileEditor(ctx, userID, file); err != nil {
+		return nil, err
+	}
+	if err := s.checkTarget(ctx, userID, file.UserID, folderID); err != nil {
+		return nil, err
+	}
+
+	name, replace, err := s.names.fileName(ctx, file.UserID, folderID, file.Name, file.Extension, file.ID, policy)
+	if err != nil {
+		return nil, err
+	}
+	if err := s.fileRepository.Move(ctx, file.ID, file.UserID, folderID, name, replace); err != nil {
+		return nil, err
+	}
+	file.FolderID = folderID
+	file.Name = name
+	return file, nil
+}
+
+func (s *transferService) CopyFile(ctx context.Context, userID, fileID int, folderID *int, policy types.ConflictPolicy) (*types.File, error) {
+	file, err := s.liveFile(ctx, userID, fileID)
+	if err != nil {
+		return nil, err
+	}
+	if err := s.checkTarget(ctx, userID, file.UserID, folderID); err != nil {
+		return nil, err
+	}
+
+	name, replace, err := s.names.fileName(ctx, file.UserID, folderID, file.Name, file.Extension, 0, policy)
+	if err != nil {
+		return nil, err
+	}
+
+	reservation := &types.StorageReservation{
+		ID:     uuid.New(),
+		UserID: file.UserID,
+		Size:   file.Size,
+	}
+	if err := s.reservationRepository.Reserve(ctx, reservation); err != nil {
+		return nil, err
+	}
+	if err := s.blobRepository.Acquire(ctx, file.PhysicalName, file.Size, nil); err != nil {
+		_ = s.reservationRepository.Release(ctx, reservation.ID)
+		return nil, err
+	}
+
+	clone := &types.File{
+		UserID:       file.UserID,
+		FolderID:     folderID,
+		Name:         name,
+		Extension:    file.Extension,
+		Size:         file.Size,
+		PhysicalName: file.PhysicalName,
+		SHA256:       file.SHA256,
+		ContentType:  file.ContentType,
+		Metadata:     file.Metadata,
+	}
+	if err := s.fileRepository.Create(ctx, clone, &reservation.ID, replace); err != nil {
+		_ = releaseBlob(ctx, s.blobRepository, s.blobStore, file.PhysicalName)
+		_ = s.reservationRepository.Release(ctx, reservation.ID)
+		return nil, err
+	}
+	return clone, nil
+}
+
+func (s *transferService) MoveFolder(ctx context.Context, userID, folderID int, parentID *int, policy types.ConflictPolicy) (*types.Folder, error) {
+	folder, err := s.liveFolder(ctx, userID, folderID)
+	if err != nil {
+		return nil, err
+	}
+	if err := s.accessService.RequireFolderEditor(ctx, userID, folder); err != nil {
+		return nil, err
+	}
+	if err := s.CheckFolderTarget(ctx, userID, folder, parentID); err != nil {
+		return nil, err
+	}
+
+	name, replace, err := s.names.folderName(ctx, folder.UserID, parentID, folder.Name, folder.ID, folder.ID, policy)
+	if err != nil {
+		return nil, err
+	}
+	folder.Name = name
+	folder.ParentID = parentID
+	if err := s.folderRepository.Update(ctx, folder, replace); err != nil {
+		return nil, err
+	}
+	return folder, nil
+}
+
+func (s *transferService) CopyFolder(ctx context.Context, userID, folderID int, parentID *int, policy types.ConflictPolicy) (*types.Folder, error) {
+	folder, err := s.liveFolder(ctx, userID, folderID)
+	if err != nil {
+		return nil, err
+	}
+	if err := s.CheckFolderTarget(ctx, userID, folder, parentID); err != nil {
+		return nil, err
+	}
+
+	name, replace, err := s.names.folderName(ctx, folder.UserID, parentID, folder.Name, folder.ID, 0, policy)
+	if err != nil {
+		return nil, err
+	}
+
+	size, err := s.folderRepository.TreeSize(ctx, folder.UserID, folder.ID)
+	if err != nil {
+		return nil, err
+	}
+	reservation := &types.StorageReservation{
+		ID:     uuid.New(),
+		UserID: folder.UserID,
+		Size:   size,
+	}
+	if err := s.reservationRepository.Reserve(ctx, reservation); err != nil {
+		return nil, err
+	}
+	copied, err := s.folderRepository.CopyTree(ctx, folder.UserID, folder.ID, parentID, name, reservation.ID, replace)
+	if err != nil {
+		_ = s.reservationRepository.Release(ctx, reservation.ID)
+		return nil, err
+	}
+	return copied, nil
+}
+
+// CheckFolderTarget validates that folder may be placed under parentID: the
+// target must be writable by userID, belong to the folder's owner and must not
+// be the folder itself or one of its descendants.
+func (s *transferService) CheckFolderTarget(ctx context.Context, userID int, folder *types.Folder, parentID *int) error {
+	if err := s.checkTarget(ctx, userID, folder.UserID, parentID); err != nil {
+		return err
+	}
+	if parentID == nil {
+		return nil
+	}
+	if *parentID == folder.ID {
+		return utils.ErrBadRequest.New("cannot place folder %d inside itself", folder.ID)
+	}
+	inside, err := s.folderRepository.IsInSubtree(ctx, folder.UserID, folder.ID, *parentID)
+	if err != nil {
+		return err
+	}
+	if inside {
+		return utils.ErrBadRequest.New("cannot place folder %d inside its descendant %d", folder.ID, *parentID)
+	}
+	return nil
+}
+
+func (s *transferService) checkTarget(ctx context.Context, userID, ownerID int, folderID *int) error {
+	if folderID == nil {
+		if ownerID != userID {
+			return utils.ErrForbidden.New("cannot place another user's item in your root folder")
+		}
+		return nil
+	}
+
+	target, err := s.liveFolder(ctx, userID, *folderID)
+	if err != nil {
+		return err
+	}
+	if err := s.accessService.RequireFolderEditor(ctx, userID, target); err != nil {
+		return err
+	}
+	if target.UserID != ownerID {
+		return utils.ErrForbidden.New("cannot place items into another user's folder")
+	}
+	return nil
+}
+
+func (s *transferService) liveFile(ctx context.Context, userID, fileID int) (*types.File, error) {
+	file, err := s.fileRepository.GetByID(ctx, fileID, userID)
+	if err != nil {
+		return nil, err
+	}
+	if file.DeletedAt != nil {
+		return nil, utils.ErrNotFound.New("file %d is in the trash", fileID)
+	}
+	return file, nil
+}
+
+func (s *transferService) liveFolder(ctx context.Context, userID, folderID int) (*types.Folder, error) {
+	folder, err := s.folderRepository.GetByID(ctx, folderID, userID)
+	if err != nil {
+		return nil, err
+	}
+	if folder.DeletedAt != nil {
+		return nil, utils.ErrNotFound.New("folder %d is in the trash", folderID)
+	}
+	return folder, nil
+}
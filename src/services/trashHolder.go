@@ -5,9 +5,13 @@ import (
 	"fmt"
 	"time"
 
+	"github.com/CustomCloudStorage/jobs"
 	"github.com/CustomCloudStorage/repositories"
 )
 
+// trashRetention is how long trashed items are kept before they are purged.
+const trashRetention = 30 * 24 * time.Hour
+
 type TrashService interface {
 	PermanentDeleteFile(ctx context.Context, userID, fileID int) error
 	PermanentDeleteFolder(ctx context.Context, userID, folderID int) error
@@ -18,72 +22,65 @@ type trashService struct {
 	fileService     FileService
 }
 
-func NewTrashService(trashRepo repositories.TrashRepository, fileService FileService) TrashService {
+func NewTrashService(trashRepo repositories.TrashRepository, fileService FileService, queue *jobs.Queue) TrashService {
 	svc := &trashService{
 		trashRepository: trashRepo,
 		fileService:     fileService,
 	}
-	go svc.purgeLoop()
+	queue.Every("trash.purge", time.Hour, svc.purge)
 	return svc
 }
 
 func (s *trashService) PermanentDeleteFile(ctx context.Context, userID, fileID int) error {
-	if err := s.trashRepository.PermanentDeleteFile(ctx, userID, fileID); err != nil {
-		return err
-	}
-	if err := s.fileService.DeleteFile(ctx, fileID, userID); err != nil {
+	file, err := s.trashRepository.GetTrashedFile(ctx, userID, fileID)
+	if err != nil {
 		return err
 	}
-	return nil
+	return s.fileService.ReleaseFile(ctx, file)
 }
 
 func (s *trashService) PermanentDeleteFolder(ctx context.Context, userID, folderID int) error {
-	filesID, err := s.trashRepository.PermanentDeleteFolder(ctx, userID, folderID)
+	files, err := s.trashRepository.ListFolderTreeFiles(ctx, userID, folderID)
 	if err != nil {
 		return err
 	}
 
-	for _, id := range filesID {
-		if err := s.fileService.DeleteFile(ctx, id, userID); err != nil {
+	for _, f := range files {
+		if err := s.fileService.ReleaseFile(ctx, f); err != nil {
 			return err
 		}
 	}
-	return nil
-}
-
-func (s *trashService) purgeLoop() {
-	ticker := time.NewTicker(time.Hour)
-	defer ticker.Stop()
-	for range ticker.C {
-		s.purgeOnce()
-	}
+	return s.trashRepository.HardDeleteFolderCascade(ctx, userID, folderID)
 }
 
-func (s *trashService) purgeOnce() {
-	cutoff := time.Now().Add(-30 * 24 * time.Hour)
+func (s *trashService) purge(ctx context.Context) error {
+	cutoff := time.Now().Add(-trashRetention)
 
-	files, err := s.trashRepository.ListFilesToPurge(context.Background(), cutoff)
+	files, err := s.trashRepository.ListFilesToPurge(ctx, cutoff)
 	if err != nil {
-		fmt.Printf("trash GC: failed to list files to purge: %v\n", err)
-	} else {
-		for _, f := range files {
-			if err := s.fileService.DeleteFile(context.Background(), f.ID, f.UserID); err != nil {
-				fmt.Printf("trash GC: failed to remove file: %v\n", err)
-			}
-			if err := s.trashRepository.HardDeleteFileByID(context.Background(), f.ID); err != nil {
-				fmt.Printf("trash GC: failed to hard delete file record %d: %v\n", f.ID, err)
-			}
+		return err
+	}
+	failed := 0
+	for _, f := range files {
+		if err := s.fileService.ReleaseFile(ctx, f); err != nil {
+			fmt.Printf("trash GC: failed to remove file %d: %v\n", f.ID, err)
+			failed++
 		}
 	}
 
-	folders, err := s.trashRepository.ListFoldersToPurge(context.Background(), cutoff)
+	folders, err := s.trashRepository.ListFoldersToPurge(ctx, cutoff)
 	if err != nil {
-		fmt.Printf("trash GC: failed to list folders to purge: %v\n", err)
-	} else {
-		for _, fld := range folders {
-			if err := s.trashRepository.HardDeleteFolderByID(context.Background(), fld.ID); err != nil {
-				fmt.Printf("trash GC: failed to hard delete folder record %d: %v\n", fld.ID, err)
-			}
+		return err
+	}
+	for _, fld := range folders {
+		if err := s.trashRepository.HardDeleteFolderByID(ctx, fld.ID); err != nil {
+			fmt.Printf("trash GC: failed to hard delete folder record %d: %v\n", fld.ID, err)
+			failed++
 		}
 	}
+
+	if failed > 0 {
+		return fmt.Errorf("trash GC: %d of %d items not purged", failed, len(files)+len(folders))
+	}
+	return nil
 }
@@ -2,50 +2,123 @@ package services
 
 import (
 	"context"
+	"crypto/sha256"
+	"encoding/hex"
 	"fmt"
 	"io"
 	"os"
 	"path/filepath"
+	"strings"
 	"time"
 
+	"github.com/CustomCloudStorage/infrastructure/storage"
+	"github.com/CustomCloudStorage/jobs"
 	"github.com/CustomCloudStorage/repositories"
 	"github.com/CustomCloudStorage/types"
 	"github.com/CustomCloudStorage/utils"
 	"github.com/google/uuid"
+	"github.com/joomcode/errorx"
 )
 
+var finalizeJob = jobs.Kind[finalizePayload]{
+	Name:    "uploads.finalize",
+	Options: jobs.Options{MaxAttempts: 3, Timeout: time.Hour},
+}
+
+type finalizePayload struct {
+	SessionID uuid.UUID            `json:"session_id"`
+	Recorded  int64                `json:"recorded"`
+	Policy    types.ConflictPolicy `json:"policy"`
+}
+
 type UploadService interface {
-	InitSession(ctx context.Context, session *types.UploadSession) error
-	UploadPart(ctx context.Context, sessionID uuid.UUID, partNumber int, data io.Reader) error
-	GetProgress(ctx context.Context, sessionID uuid.UUID) (int64, int, error)
-	Complete(ctx context.Context, sessionID uuid.UUID) (*types.File, error)
-	Abort(ctx context.Context, sessionID uuid.UUID) error
+	InitSession(ctx context.Context, userID int, session *types.UploadSession) error
+	UploadPart(ctx context.Context, userID int, sessionID uuid.UUID, partNumber int, data io.Reader, checksums []types.Checksum) error
+	GetSession(ctx context.Context, userID int, sessionID uuid.UUID) (*types.UploadSession, error)
+	GetProgress(ctx context.Context, userID int, sessionID uuid.UUID) (*types.UploadProgress, error)
+	AppendPart(ctx context.Context, userID int, sessionID uuid.UUID, offset int64, data io.Reader, checksums []types.Checksum) (int64, error)
+	Complete(ctx context.Context, userID int, sessionID uuid.UUID, policy types.ConflictPolicy) error
+	Abort(ctx context.Context, userID int, sessionID uuid.UUID) error
+	ListSessions(ctx context.Context, userID int) ([]types.UploadStatus, error)
+	ListStaleSessions(ctx context.Context, olderThan time.Duration) ([]types.UploadStatus, error)
+	AbortAny(ctx context.Context, sessionID uuid.UUID) error
+	UploadFile(ctx context.Context, userID int, folderID *int, name, extension string, size int64, data io.Reader, checksums []types.Checksum, policy types.ConflictPolicy) (*types.File, error)
 }
 
 type uploadService struct {
-	userRepository          repositories.UserRepository
+	reservationRepository   repositories.StorageReservationRepository
 	fileRepository          repositories.FileRepository
+	folderRepository        repositories.FolderRepository
 	uploadSessionRepository repositories.UploadSessionRepository
 	uploadPartRepository    repositories.UploadPartRepository
-	storageDir              string
+	blobRepository          repositories.BlobRepository
+	blobStore               storage.BlobStore
+	versionService          VersionService
+	thumbnailService        ThumbnailService
+	metadataService         MetadataService
+	queue                   *jobs.Queue
+	names                   *nameResolver
 	temp                    string
 }
 
-func NewUploadService(userRepo repositories.UserRepository, fileRepo repositories.FileRepository, uploadSessionRepo repositories.UploadSessionRepository, uploadPartRepo repositories.UploadPartRepository, cfg ServiceConfig) UploadService {
+func NewUploadService(reservationRepo repositories.StorageReservationRepository, fileRepo repositories.FileRepository, folderRepo repositories.FolderRepository, uploadSessionRepo repositories.UploadSessionRepository, uploadPartRepo repositories.UploadPartRepository, blobRepo repositories.BlobRepository, blobStore storage.BlobStore, versionService VersionService, thumbnailService ThumbnailService, metadataService MetadataService, queue *jobs.Queue, cfg ServiceConfig) UploadService {
 	svc := &uploadService{
-		userRepository:          userRepo,
+		reservationRepository:   reservationRepo,
 		fileRepository:          fileRepo,
+		folderRepository:        folderRepo,
 		uploadSessionRepository: uploadSessionRepo,
 		uploadPartRepository:    uploadPartRepo,
-		storageDir:              cfg.StorageDir,
+		blobRepository:          blobRepo,
+		blobStore:               blobStore,
+		versionService:          versionService,
+		thumbnailService:        thumbnailService,
+		metadataService:         metadataService,
+		queue:                   queue,
+		names:                   newNameResolver(fileRepo, folderRepo),
 		temp:                    cfg.Temp,
 	}
-	go svc.purgeLoop()
+	jobs.Register(queue, finalizeJob, svc.finalizeSession)
+	queue.Every("uploads.purge", time.Hour, svc.purge)
 	return svc
 }
 
-func (s *uploadService) InitSession(ctx context.Context, session *types.UploadSession) error {
-	if err := s.userRepository.ReserveStorage(ctx, session.UserID, session.TotalSize); err != nil {
+// InitSession opens an upload session owned by userID, whatever the payload
+// says, and reserves its declared size.
+func (s *uploadService) InitSession(ctx context.Context, userID int, session *types.UploadSession) error {
+	session.UserID = userID
+	session.Status = types.UploadSessionOpen
+	session.FileID = nil
+	session.Error = ""
+	if session.Protocol == "" {
+		session.Protocol = types.UploadProtocolParts
+	}
+	switch {
+	case session.TotalSize < 0:
+		return utils.ErrBadRequest.New("invalid total size %d", session.TotalSize)
+	case session.Protocol == types.UploadProtocolParts && session.TotalParts < 1:
+		return utils.ErrBadRequest.New("invalid total parts %d", session.TotalParts)
+	case session.Protocol == types.UploadProtocolTus:
+		// Parts are appended as data arrives.
+		session.TotalParts = 0
+	case session.Protocol != types.UploadProtocolParts:
+		return utils.ErrBadRequest.New("unknown upload protocol %q", session.Protocol)
+	}
+	if session.PartSize != 0 {
+		if session.Protocol != types.UploadProtocolParts || session.PartSize < 0 {
+			return utils.ErrBadRequest.New("invalid part size %d", session.PartSize)
+		}
+		if session.PartSize*int64(session.TotalParts-1) >= session.TotalSize || session.PartSize*int64(session.TotalParts) < session.TotalSize {
+			return utils.ErrBadRequest.New("%d parts of %d bytes do not add up to %d bytes", session.TotalParts, session.PartSize, session.TotalSize)
+		}
+	}
+	if session.ExpectedSHA256 != "" {
+		sum, err := parseSHA256(session.ExpectedSHA256)
+		if err != nil {
+			return err
+		}
+		session.ExpectedSHA256 = sum
+	}
+	if err := s.checkFolder(ctx, userID, session.FolderID); err != nil {
 		return err
 	}
 
@@ -54,34 +127,86 @@ func (s *uploadService) InitSession(ctx context.Context, session *types.UploadSe
 	if err := s.uploadSessionRepository.Create(ctx, session); err != nil {
 		return err
 	}
+	// The reservation shares the session's ID and is dropped along with it.
+	reservation := &types.StorageReservation{
+		ID:              id,
+		UserID:          userID,
+		UploadSessionID: &id,
+		Size:            session.TotalSize,
+	}
+	if err := s.reservationRepository.Reserve(ctx, reservation); err != nil {
+		_ = s.uploadSessionRepository.Delete(ctx, id)
+		return err
+	}
 
 	path := filepath.Join(s.temp, id.String())
 	if err := os.MkdirAll(path, 0o755); err != nil {
 		return utils.DetermineFSError(err, fmt.Sprintf("mkdir temp dir %s", path))
 	}
+	if assembled(session) {
+		return s.preallocate(session)
+	}
 	return nil
 }
 
-func (s *uploadService) UploadPart(ctx context.Context, sessionID uuid.UUID, partNumber int, data io.Reader) error {
-	session, err := s.uploadSessionRepository.GetByID(ctx, sessionID)
+func (s *uploadService) preallocate(session *types.UploadSession) error {
+	path := s.dataPath(session.ID)
+	f, err := os.Create(path)
+	if err != nil {
+		return utils.DetermineFSError(err, fmt.Sprintf("create data file %s", path))
+	}
+	defer f.Close()
+	if err := f.Truncate(session.TotalSize); err != nil {
+		return utils.DetermineFSError(err, fmt.Sprintf("preallocate data file %s", path))
+	}
+	return nil
+}
+
+// UploadPart stores one numbered part. When checksums are given the part is
+// only kept if every digest matches.
+func (s *uploadService) UploadPart(ctx context.Context, userID int, sessionID uuid.UUID, partNumber int, data io.Reader, checksums []types.Checksum) error {
+	session, err := s.uploadSessionRepository.GetForUser(ctx, sessionID, userID)
 	if err != nil {
 		return err
 	}
+	if session.Protocol != types.UploadProtocolParts {
+		return utils.ErrBadRequest.New("upload session %s does not accept numbered parts", sessionID)
+	}
+	if err := acceptsData(session); err != nil {
+		return err
+	}
 	if partNumber < 1 || partNumber > session.TotalParts {
 		return utils.ErrBadRequest.New("invalid part number %d", partNumber)
 	}
 
-	partPath := filepath.Join(s.temp, sessionID.String(), fmt.Sprintf("%05d.part", partNumber))
-	f, err := os.Create(partPath)
+	verifier, err := newChecksumVerifier(checksums)
+	if err != nil {
+		return err
+	}
+	if assembled(session) {
+		return s.writePartAt(ctx, session, partNumber, data, verifier)
+	}
+
+	partPath := s.partPath(sessionID, partNumber)
+	tmp, err := os.CreateTemp(filepath.Dir(partPath), filepath.Base(partPath)+"-*")
 	if err != nil {
 		return utils.DetermineFSError(err, fmt.Sprintf("create part file %s", partPath))
 	}
-	defer f.Close()
+	defer os.Remove(tmp.Name())
 
-	n, err := io.Copy(f, data)
+	n, err := io.Copy(verifier.Writer(tmp), data)
+	if cerr := tmp.Close(); err == nil && cerr != nil {
+		err = cerr
+	}
 	if err != nil {
 		return utils.ErrInternal.Wrap(err, "write part %d data", partNumber)
 	}
+	if err := verifier.Verify(fmt.Sprintf("part %d", partNumber)); err != nil {
+		return err
+	}
+	if err := os.Rename(tmp.Name(), partPath); err != nil {
+		return utils.DetermineFSError(err, fmt.Sprintf("rename part file %s", partPath))
+	}
 
 	part := &types.UploadPart{
 		SessionID:  sessionID,
@@ -89,135 +214,731 @@ func (s *uploadService) UploadPart(ctx context.Context, sessionID uuid.UUID, par
 		Size:       n,
 	}
 	if err := s.uploadPartRepository.Create(ctx, part); err != nil {
+		os.Remove(partPath)
 		return err
 	}
 	return nil
 }
 
-func (s *uploadService) GetProgress(ctx context.Context, sessionID uuid.UUID) (int64, int, error) {
-	session, err := s.uploadSessionRepository.GetByID(ctx, sessionID)
+func (s *uploadService) GetSession(ctx context.Context, userID int, sessionID uuid.UUID) (*types.UploadSession, error) {
+	return s.uploadSessionRepository.GetForUser(ctx, sessionID, userID)
+}
+
+func (s *uploadService) GetProgress(ctx context.Context, userID int, sessionID uuid.UUID) (*types.UploadProgress, error) {
+	session, err := s.uploadSessionRepository.GetForUser(ctx, sessionID, userID)
 	if err != nil {
-		return 0, 0, err
+		return nil, err
+	}
+	progress := &types.UploadProgress{
+		Total:     session.TotalParts,
+		TotalSize: session.TotalSize,
+		Status:    session.Status,
+		Error:     session.Error,
+	}
+
+	if session.Status == types.UploadSessionCompleted {
+		progress.Uploaded = session.TotalSize
+		if session.FileID != nil {
+			file, err := s.fileRepository.GetByID(ctx, *session.FileID, userID)
+			if err != nil {
+				return nil, err
+			}
+			progress.File = file
+			progress.Uploaded = file.Size
+		}
+		return progress, nil
 	}
+
+	if progress.Uploaded, err = s.receivedBytes(ctx, sessionID); err != nil {
+		return nil, err
+	}
+	return progress, nil
+}
+
+func (s *uploadService) receivedBytes(ctx context.Context, sessionID uuid.UUID) (int64, error) {
 	parts, err := s.uploadPartRepository.ListBySession(ctx, sessionID)
 	if err != nil {
-		return 0, 0, err
+		return 0, err
 	}
 	var total int64
 	for _, p := range parts {
 		total += p.Size
 	}
-	return total, session.TotalParts, nil
+	return total, nil
 }
 
-func (s *uploadService) Complete(ctx context.Context, sessionID uuid.UUID) (*types.File, error) {
-	session, err := s.uploadSessionRepository.GetByID(ctx, sessionID)
+func (s *uploadService) ListSessions(ctx context.Context, userID int) ([]types.UploadStatus, error) {
+	sessions, err := s.uploadSessionRepository.ListByUserID(ctx, userID)
 	if err != nil {
 		return nil, err
 	}
+	return s.withStatus(ctx, sessions)
+}
 
-	physical := uuid.New().String()
-	finalPath := filepath.Join(s.storageDir, physical)
-	out, err := os.Create(finalPath)
+func (s *uploadService) ListStaleSessions(ctx context.Context, olderThan time.Duration) ([]types.UploadStatus, error) {
+	sessions, err := s.uploadSessionRepository.ListOlderThan(ctx, olderThan)
 	if err != nil {
-		return nil, utils.DetermineFSError(err, fmt.Sprintf("create final file %s", finalPath))
+		return nil, err
 	}
-	defer out.Close()
+	return s.withStatus(ctx, sessions)
+}
 
-	for i := 1; i <= session.TotalParts; i++ {
-		partPath := filepath.Join(s.temp, sessionID.String(), fmt.Sprintf("%05d.part", i))
-		in, err := os.Open(partPath)
-		if err != nil {
-			return nil, utils.DetermineFSError(err, fmt.Sprintf("open part %d", i))
+func (s *uploadService) withStatus(ctx context.Context, sessions []types.UploadSession) ([]types.UploadStatus, error) {
+	ids := make([]uuid.UUID, len(sessions))
+	for i, sess := range sessions {
+		ids[i] = sess.ID
+	}
+	parts, err := s.uploadPartRepository.ListBySessions(ctx, ids)
+	if err != nil {
+		return nil, err
+	}
+	bySession := make(map[uuid.UUID][]types.UploadPart, len(sessions))
+	for _, p := range parts {
+		bySession[p.SessionID] = append(bySession[p.SessionID], p)
+	}
+
+	out := make([]types.UploadStatus, len(sessions))
+	for i, sess := range sessions {
+		status := types.UploadStatus{
+			UploadSession: sess,
+			Parts:         bySession[sess.ID],
+			MissingParts:  []int{},
+		}
+		if status.Parts == nil {
+			status.Parts = []types.UploadPart{}
 		}
-		if _, err := io.Copy(out, in); err != nil {
-			in.Close()
-			return nil, utils.ErrInternal.Wrap(err, "copy part %d", i)
+		seen := make(map[int]bool, len(status.Parts))
+		for _, p := range status.Parts {
+			seen[p.PartNumber] = true
+			status.UploadedSize += p.Size
 		}
-		in.Close()
+		for n := 1; n <= sess.TotalParts; n++ {
+			if !seen[n] {
+				status.MissingParts = append(status.MissingParts, n)
+			}
+		}
+		out[i] = status
 	}
+	return out, nil
+}
 
-	fileMeta := &types.File{
-		UserID:       session.UserID,
-		FolderID:     session.FolderID,
-		Name:         session.Name,
-		Extension:    session.Extension,
-		Size:         session.TotalSize,
-		PhysicalName: physical,
-	}
-	if err := s.fileRepository.Create(ctx, fileMeta); err != nil {
-		if err := s.userRepository.ReleaseStorage(ctx, session.UserID, session.TotalSize); err != nil {
-			return nil, err
+// AppendPart stores data as the next part of a tus session. offset must equal
+// the number of bytes already received; a chunk whose checksum does not match
+// is discarded. It returns the new offset.
+func (s *uploadService) AppendPart(ctx context.Context, userID int, sessionID uuid.UUID, offset int64, data io.Reader, checksums []types.Checksum) (int64, error) {
+	session, err := s.uploadSessionRepository.GetForUser(ctx, sessionID, userID)
+	if err != nil {
+		return 0, err
+	}
+	if session.Protocol != types.UploadProtocolTus {
+		return 0, utils.ErrBadRequest.New("upload session %s does not accept appended data", sessionID)
+	}
+	if err := acceptsData(session); err != nil {
+		return 0, err
+	}
+	uploaded, err := s.receivedBytes(ctx, sessionID)
+	if err != nil {
+		return 0, err
+	}
+	if offset != uploaded {
+		return 0, utils.ErrConflict.New("upload offset is %d, not %d", uploaded, offset)
+	}
+
+	verifier, err := newChecksumVerifier(checksums)
+	if err != nil {
+		return 0, err
+	}
+
+	partNumber := session.TotalParts + 1
+	partPath := s.partPath(sessionID, partNumber)
+	tmp, err := os.CreateTemp(filepath.Dir(partPath), filepath.Base(partPath)+"-*")
+	if err != nil {
+		return 0, utils.DetermineFSError(err, fmt.Sprintf("create part file for %s", sessionID))
+	}
+	defer os.Remove(tmp.Name())
+
+	remaining := session.TotalSize - uploaded
+	n, err := io.Copy(verifier.Writer(tmp), io.LimitReader(data, remaining+1))
+	if cerr := tmp.Close(); err == nil && cerr != nil {
+		err = cerr
+	}
+	if err != nil {
+		return 0, utils.ErrInternal.Wrap(err, "write part %d data", partNumber)
+	}
+	if n > remaining {
+		return 0, utils.ErrBadRequest.New("chunk exceeds upload length %d", session.TotalSize)
+	}
+	if err := verifier.Verify(fmt.Sprintf("part %d", partNumber)); err != nil {
+		return 0, err
+	}
+	if n == 0 {
+		return uploaded, nil
+	}
+
+	part := &types.UploadPart{
+		SessionID:  sessionID,
+		PartNumber: partNumber,
+		Size:       n,
+	}
+	if err := s.uploadSessionRepository.AppendPart(ctx, part); err != nil {
+		return 0, err
+	}
+	if err := os.Rename(tmp.Name(), partPath); err != nil {
+		if rerr := s.uploadSessionRepository.RemoveLastPart(ctx, sessionID, partNumber); rerr != nil {
+			fmt.Printf("upload %s: remove part %d: %v\n", sessionID, partNumber, rerr)
 		}
-		return nil, err
+		return 0, utils.DetermineFSError(err, fmt.Sprintf("rename part file %s", partPath))
+	}
+	return uploaded + n, nil
+}
+
+// Complete checks that every part has arrived and assembles the file in the
+// background; the outcome is reported through GetProgress.
+func (s *uploadService) Complete(ctx context.Context, userID int, sessionID uuid.UUID, policy types.ConflictPolicy) error {
+	session, err := s.uploadSessionRepository.GetForUser(ctx, sessionID, userID)
+	if err != nil {
+		return err
+	}
+	if err := acceptsData(session); err != nil {
+		return err
+	}
+	recorded, err := s.checkParts(ctx, session)
+	if err != nil {
+		return err
+	}
+	if err := s.uploadSessionRepository.Transition(ctx, sessionID, []string{types.UploadSessionOpen, types.UploadSessionFailed}, types.UploadSessionFinalizing); err != nil {
+		return err
 	}
 
-	user, err := s.userRepository.GetByID(ctx, session.UserID)
+	payload := finalizePayload{SessionID: sessionID, Recorded: recorded, Policy: policy}
+	if err := jobs.Enqueue(ctx, s.queue, finalizeJob, payload); err != nil {
+		if err := s.uploadSessionRepository.Finish(ctx, sessionID, types.UploadSessionFailed, nil, err.Error()); err != nil {
+			fmt.Printf("upload %s: mark failed: %v\n", sessionID, err)
+		}
+		return err
+	}
+	return nil
+}
+
+func (s *uploadService) finalizeSession(ctx context.Context, payload finalizePayload) error {
+	session, err := s.uploadSessionRepository.GetByID(ctx, payload.SessionID)
+	if errorx.IsOfType(err, utils.ErrNotFound) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	if session.Status != types.UploadSessionFinalizing {
+		return nil
+	}
+
+	fileMeta, err := s.assemble(ctx, session, payload.Recorded, payload.Policy)
+	if err != nil {
+		// The session stays around so the client can retry, for instance with
+		// another conflict policy; its reservation is released by Abort or GC.
+		fmt.Printf("upload %s: finalize: %v\n", session.ID, err)
+		return s.uploadSessionRepository.Finish(ctx, session.ID, types.UploadSessionFailed, nil, err.Error())
+	}
+
+	if err := s.uploadSessionRepository.Finish(ctx, session.ID, types.UploadSessionCompleted, &fileMeta.ID, ""); err != nil {
+		fmt.Printf("upload %s: mark completed: %v\n", session.ID, err)
+	}
+	if err := s.uploadPartRepository.DeleteBySession(ctx, session.ID); err != nil {
+		fmt.Printf("upload %s: delete parts: %v\n", session.ID, err)
+	}
+	tempDir := filepath.Join(s.temp, session.ID.String())
+	if err := os.RemoveAll(tempDir); err != nil {
+		fmt.Printf("upload %s: remove temp dir %s: %v\n", session.ID, tempDir, err)
+	}
+	return nil
+}
+
+func (s *uploadService) assemble(ctx context.Context, session *types.UploadSession, recorded int64, policy types.ConflictPolicy) (*types.File, error) {
+	var (
+		hash string
+		size int64
+		err  error
+	)
+	if assembled(session) {
+		hash, size, err = hashFile(s.dataPath(session.ID))
+	} else {
+		hash, size, err = s.hashParts(session.ID, session.TotalParts)
+	}
 	if err != nil {
 		return nil, err
 	}
-	if err := s.userRepository.UpdateUsedStorage(ctx, session.UserID, user.Account.UsedStorage+fileMeta.Size); err != nil {
+	if size != recorded {
+		return nil, utils.ErrInternal.New("upload %s parts hold %d bytes on disk, %d recorded", session.ID, size, recorded)
+	}
+	if session.ExpectedSHA256 != "" && hash != session.ExpectedSHA256 {
+		return nil, utils.ErrChecksumMismatch.New("upload sha256 mismatch: got %s, expected %s", hash, session.ExpectedSHA256)
+	}
+
+	contentType, err := s.detectSessionType(session)
+	if err != nil {
 		return nil, err
 	}
 
-	tempDir := filepath.Join(s.temp, sessionID.String())
-	if err := os.RemoveAll(tempDir); err != nil {
-		return nil, utils.DetermineFSError(err, fmt.Sprintf("remove temp dir %s", tempDir))
+	// The reservation was made for the declared size and is settled for the
+	// actual one.
+	target := uploadTarget{
+		userID:      session.UserID,
+		folderID:    session.FolderID,
+		name:        session.Name,
+		extension:   session.Extension,
+		size:        size,
+		contentType: contentType,
+		reservation: session.ID,
+	}
+	return s.finalize(ctx, target, hash, policy, func() error {
+		if assembled(session) {
+			return s.putFile(ctx, hash, s.dataPath(session.ID), size)
+		}
+		parts, err := s.openParts(session.ID, session.TotalParts)
+		if err != nil {
+			return err
+		}
+		defer closeParts(parts)
+		return s.blobStore.Put(ctx, hash, partsReader(parts), size)
+	})
+}
+
+func (s *uploadService) detectSessionType(session *types.UploadSession) (string, error) {
+	if assembled(session) {
+		f, err := os.Open(s.dataPath(session.ID))
+		if err != nil {
+			return "", utils.DetermineFSError(err, "open upload data")
+		}
+		defer f.Close()
+		return detectContentType(session.Extension, f)
+	}
+	parts, err := s.openParts(session.ID, session.TotalParts)
+	if err != nil {
+		return "", err
+	}
+	defer closeParts(parts)
+	return detectContentType(session.Extension, partsReader(parts))
+}
+
+func (s *uploadService) putFile(ctx context.Context, key, path string, size int64) error {
+	if importer, ok := s.blobStore.(storage.FileImporter); ok {
+		return importer.ImportFile(ctx, key, path)
+	}
+	f, err := os.Open(path)
+	if err != nil {
+		return utils.DetermineFSError(err, fmt.Sprintf("open %s", path))
+	}
+	defer f.Close()
+	return s.blobStore.Put(ctx, key, f, size)
+}
+
+type uploadTarget struct {
+	userID      int
+	folderID    *int
+	name        string
+	extension   string
+	size        int64
+	contentType string
+	reservation uuid.UUID
+}
+
+func (s *uploadService) finalize(ctx context.Context, target uploadTarget, hash string, policy types.ConflictPolicy, put func() error) (*types.File, error) {
+	if err := s.blobRepository.Acquire(ctx, hash, target.size, put); err != nil {
+		return nil, err
 	}
-	if err := s.uploadPartRepository.DeleteBySession(ctx, sessionID); err != nil {
+	fileMeta, err := s.saveFile(ctx, target, hash, policy)
+	if err != nil {
+		if err := releaseBlob(ctx, s.blobRepository, s.blobStore, hash); err != nil {
+			return nil, err
+		}
 		return nil, err
 	}
-	if err := s.uploadSessionRepository.Delete(ctx, sessionID); err != nil {
+	// Both are best effort: thumbnails are also made on first request and the
+	// metadata sweep picks up files that were not queued.
+	if strings.HasPrefix(target.contentType, "image/") {
+		if err := s.thumbnailService.Enqueue(ctx, hash); err != nil {
+			fmt.Printf("upload: queue thumbnails of %s: %v\n", hash, err)
+		}
+	}
+	if err := s.metadataService.Enqueue(ctx, fileMeta); err != nil {
+		fmt.Printf("upload: queue metadata of file %d: %v\n", fileMeta.ID, err)
+	}
+	return fileMeta, nil
+}
+
+func (s *uploadService) saveFile(ctx context.Context, target uploadTarget, hash string, policy types.ConflictPolicy) (*types.File, error) {
+	if policy == types.ConflictOverwrite {
+		existing, err := s.fileRepository.GetByName(ctx, target.userID, target.folderID, target.name, target.extension)
+		if err == nil {
+			return s.versionService.AddVersion(ctx, existing.ID, types.FileVersion{
+				Size:         target.size,
+				PhysicalName: hash,
+				SHA256:       hash,
+				ContentType:  target.contentType,
+			}, &target.reservation)
+		}
+		if !errorx.IsOfType(err, utils.ErrNotFound) {
+			return nil, err
+		}
+	}
+
+	name, replace, err := s.names.fileName(ctx, target.userID, target.folderID, target.name, target.extension, 0, policy)
+	if err != nil {
 		return nil, err
 	}
 
+	fileMeta := &types.File{
+		UserID:       target.userID,
+		FolderID:     target.folderID,
+		Name:         name,
+		Extension:    target.extension,
+		Size:         target.size,
+		PhysicalName: hash,
+		SHA256:       hash,
+		ContentType:  target.contentType,
+	}
+	if err := s.fileRepository.Create(ctx, fileMeta, &target.reservation, replace); err != nil {
+		return nil, err
+	}
 	return fileMeta, nil
 }
 
-func (s *uploadService) Abort(ctx context.Context, sessionID uuid.UUID) error {
+func (s *uploadService) checkParts(ctx context.Context, session *types.UploadSession) (int64, error) {
+	parts, err := s.uploadPartRepository.ListBySession(ctx, session.ID)
+	if err != nil {
+		return 0, err
+	}
+
+	seen := make(map[int]bool, len(parts))
+	var size int64
+	for _, p := range parts {
+		seen[p.PartNumber] = true
+		size += p.Size
+	}
+	missing := []int{}
+	for i := 1; i <= session.TotalParts; i++ {
+		if !seen[i] {
+			missing = append(missing, i)
+		}
+	}
+	if len(missing) > 0 {
+		return 0, utils.ErrIncompleteUpload.
+			New("upload %s is missing %d of %d parts", session.ID, len(missing), session.TotalParts).
+			WithProperty(utils.PropertyDetails, map[string]interface{}{
+				"missing_parts": missing,
+				"total_parts":   session.TotalParts,
+			})
+	}
+	return size, nil
+}
+
+func (s *uploadService) Abort(ctx context.Context, userID int, sessionID uuid.UUID) error {
+	session, err := s.uploadSessionRepository.GetForUser(ctx, sessionID, userID)
+	if err != nil {
+		return err
+	}
+	return s.abort(ctx, session)
+}
+
+// AbortAny aborts a session regardless of its owner; it is meant for admins.
+func (s *uploadService) AbortAny(ctx context.Context, sessionID uuid.UUID) error {
 	session, err := s.uploadSessionRepository.GetByID(ctx, sessionID)
 	if err != nil {
 		return err
 	}
-	if err := s.userRepository.ReleaseStorage(ctx, session.UserID, session.TotalSize); err != nil {
+	return s.abort(ctx, session)
+}
+
+func (s *uploadService) abort(ctx context.Context, session *types.UploadSession) error {
+	if session.Status == types.UploadSessionFinalizing {
+		finalizing, err := s.finalizing(ctx, session.ID)
+		if err != nil {
+			return err
+		}
+		if finalizing {
+			return utils.ErrConflict.New("upload session %s is being finalized", session.ID)
+		}
+	}
+	if err := s.uploadPartRepository.DeleteBySession(ctx, session.ID); err != nil {
+		return err
+	}
+	if err := s.uploadSessionRepository.Delete(ctx, session.ID); err != nil {
 		return err
 	}
 
-	tempDir := filepath.Join(s.temp, sessionID.String())
+	tempDir := filepath.Join(s.temp, session.ID.String())
 	if err := os.RemoveAll(tempDir); err != nil {
 		return utils.DetermineFSError(err, fmt.Sprintf("remove temp dir %s", tempDir))
 	}
-	if err := s.uploadPartRepository.DeleteBySession(ctx, sessionID); err != nil {
+	return nil
+}
+
+func (s *uploadService) checkFolder(ctx context.Context, userID int, folderID *int) error {
+	if folderID == nil {
+		return nil
+	}
+	folder, err := s.folderRepository.GetByID(ctx, *folderID, userID)
+	if err != nil {
 		return err
 	}
-	if err := s.uploadSessionRepository.Delete(ctx, sessionID); err != nil {
+	if folder.UserID != userID || folder.DeletedAt != nil {
+		return utils.ErrNotFound.New("folder %d not found", *folderID)
+	}
+	return nil
+}
+
+// UploadFile stores a file received in a single request. size is -1 when the
+// client did not declare one; the quota is then reserved once the body is read.
+func (s *uploadService) UploadFile(ctx context.Context, userID int, folderID *int, name, extension string, size int64, data io.Reader, checksums []types.Checksum, policy types.ConflictPolicy) (*types.File, error) {
+	if name == "" {
+		return nil, utils.ErrBadRequest.New("file name is required")
+	}
+	if err := s.checkFolder(ctx, userID, folderID); err != nil {
+		return nil, err
+	}
+
+	reservation := &types.StorageReservation{
+		ID:     uuid.New(),
+		UserID: userID,
+		Size:   size,
+	}
+	if size >= 0 {
+		if err := s.reservationRepository.Reserve(ctx, reservation); err != nil {
+			return nil, err
+		}
+	}
+
+	fileMeta, err := s.uploadFile(ctx, reservation, folderID, name, extension, data, checksums, policy)
+	if err != nil {
+		_ = s.reservationRepository.Release(ctx, reservation.ID)
+		return nil, err
+	}
+	return fileMeta, nil
+}
+
+func (s *uploadService) uploadFile(ctx context.Context, reservation *types.StorageReservation, folderID *int, name, extension string, data io.Reader, checksums []types.Checksum, policy types.ConflictPolicy) (*types.File, error) {
+	size := reservation.Size
+	verifier, err := newChecksumVerifier(checksums)
+	if err != nil {
+		return nil, err
+	}
+
+	tmp, err := os.CreateTemp(s.temp, "direct-*.upload")
+	if err != nil {
+		return nil, utils.DetermineFSError(err, "create upload spool file")
+	}
+	defer os.Remove(tmp.Name())
+	defer tmp.Close()
+
+	limit := size
+	if size < 0 {
+		if limit, err = s.reservationRepository.Available(ctx, reservation.UserID); err != nil {
+			return nil, err
+		}
+	}
+	// Read one byte past the limit so an oversized body is caught.
+	data = io.LimitReader(data, limit+1)
+	h := sha256.New()
+	written, err := io.Copy(verifier.Writer(io.MultiWriter(tmp, h)), data)
+	if err != nil {
+		return nil, utils.ErrInternal.Wrap(err, "spool upload body")
+	}
+	if size < 0 && written > limit {
+		return nil, utils.ErrConflict.New("upload exceeds the %d bytes left in the quota", limit)
+	}
+	if size >= 0 && written != size {
+		return nil, utils.ErrBadRequest.New("upload body is %d bytes, expected %d", written, size)
+	}
+	if err := verifier.Verify("upload"); err != nil {
+		return nil, err
+	}
+	hash := hex.EncodeToString(h.Sum(nil))
+
+	if size < 0 {
+		reservation.Size = written
+		if err := s.reservationRepository.Reserve(ctx, reservation); err != nil {
+			return nil, err
+		}
+	}
+
+	if _, err := tmp.Seek(0, io.SeekStart); err != nil {
+		return nil, utils.DetermineFSError(err, "rewind upload spool file")
+	}
+	contentType, err := detectContentType(extension, tmp)
+	if err != nil {
+		return nil, err
+	}
+
+	target := uploadTarget{
+		userID:      reservation.UserID,
+		folderID:    folderID,
+		name:        name,
+		extension:   extension,
+		size:        written,
+		contentType: contentType,
+		reservation: reservation.ID,
+	}
+	return s.finalize(ctx, target, hash, policy, func() error {
+		if _, err := tmp.Seek(0, io.SeekStart); err != nil {
+			return utils.DetermineFSError(err, "rewind upload spool file")
+		}
+		return s.blobStore.Put(ctx, hash, tmp, written)
+	})
+}
+
+func (s *uploadService) openParts(sessionID uuid.UUID, totalParts int) ([]*os.File, error) {
+	parts := make([]*os.File, 0, totalParts)
+	for i := 1; i <= totalParts; i++ {
+		in, err := os.Open(s.partPath(sessionID, i))
+		if err != nil {
+			closeParts(parts)
+			return nil, utils.DetermineFSError(err, fmt.Sprintf("open part %d", i))
+		}
+		parts = append(parts, in)
+	}
+	return parts, nil
+}
+
+func (s *uploadService) writePartAt(ctx context.Context, session *types.UploadSession, partNumber int, data io.Reader, verifier *checksumVerifier) error {
+	offset := session.PartSize * int64(partNumber-1)
+	expected := session.PartSize
+	if partNumber == session.TotalParts {
+		expected = session.TotalSize - offset
+	}
+
+	path := s.dataPath(session.ID)
+	f, err := os.OpenFile(path, os.O_WRONLY, 0)
+	if err != nil {
+		return utils.DetermineFSError(err, fmt.Sprintf("open data file %s", path))
+	}
+	defer f.Close()
+
+	n, err := io.Copy(verifier.Writer(io.NewOffsetWriter(f, offset)), io.LimitReader(data, expected))
+	if err != nil {
+		return utils.ErrInternal.Wrap(err, "write part %d data", partNumber)
+	}
+	if n < expected {
+		return utils.ErrBadRequest.New("part %d is %d bytes, expected %d", partNumber, n, expected)
+	}
+	if more, _ := io.CopyN(io.Discard, data, 1); more > 0 {
+		return utils.ErrBadRequest.New("part %d is longer than %d bytes", partNumber, expected)
+	}
+	if err := verifier.Verify(fmt.Sprintf("part %d", partNumber)); err != nil {
 		return err
 	}
+
+	part := &types.UploadPart{
+		SessionID:  session.ID,
+		PartNumber: partNumber,
+		Size:       n,
+	}
+	return s.uploadPartRepository.Create(ctx, part)
+}
+
+func assembled(session *types.UploadSession) bool {
+	return session.Protocol == types.UploadProtocolParts && session.PartSize > 0
+}
+
+// A failed session stays writable so a bad part can be sent again.
+func acceptsData(session *types.UploadSession) error {
+	if session.Status != types.UploadSessionOpen && session.Status != types.UploadSessionFailed {
+		return utils.ErrConflict.New("upload session %s is %s", session.ID, session.Status)
+	}
 	return nil
 }
 
-func (s *uploadService) purgeLoop() {
-	ticker := time.NewTicker(time.Hour)
-	defer ticker.Stop()
-	for range ticker.C {
-		s.purgeOnce()
+func (s *uploadService) dataPath(sessionID uuid.UUID) string {
+	return filepath.Join(s.temp, sessionID.String(), "data")
+}
+
+func (s *uploadService) partPath(sessionID uuid.UUID, partNumber int) string {
+	return filepath.Join(s.temp, sessionID.String(), fmt.Sprintf("%05d.part", partNumber))
+}
+
+func (s *uploadService) hashParts(sessionID uuid.UUID, totalParts int) (string, int64, error) {
+	parts, err := s.openParts(sessionID, totalParts)
+	if err != nil {
+		return "", 0, err
 	}
+	defer closeParts(parts)
+
+	h := sha256.New()
+	size, err := io.Copy(h, partsReader(parts))
+	if err != nil {
+		return "", 0, utils.ErrInternal.Wrap(err, "hash upload parts")
+	}
+	return hex.EncodeToString(h.Sum(nil)), size, nil
 }
 
-func (s *uploadService) purgeOnce() {
-	sessions, err := s.uploadSessionRepository.ListOlderThan(context.Background(), 7*24*time.Hour)
+func hashFile(path string) (string, int64, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return "", 0, utils.DetermineFSError(err, fmt.Sprintf("open %s", path))
+	}
+	defer f.Close()
+
+	h := sha256.New()
+	size, err := io.Copy(h, f)
 	if err != nil {
-		fmt.Printf("upload GC: cannot list stale sessions: %v\n", err)
-		return
+		return "", 0, utils.ErrInternal.Wrap(err, "hash %s", path)
 	}
+	return hex.EncodeToString(h.Sum(nil)), size, nil
+}
 
+func partsReader(parts []*os.File) io.Reader {
+	readers := make([]io.Reader, len(parts))
+	for i, p := range parts {
+		readers[i] = p
+	}
+	return io.MultiReader(readers...)
+}
+
+func closeParts(parts []*os.File) {
+	for _, p := range parts {
+		p.Close()
+	}
+}
+
+// finalizing reports whether a finalize job is still queued or running for the
+// session. Without one, a session left finalizing is as good as failed.
+func (s *uploadService) finalizing(ctx context.Context, sessionID uuid.UUID) (bool, error) {
+	return jobs.Live(ctx, s.queue, finalizeJob, struct {
+		SessionID uuid.UUID `json:"session_id"`
+	}{sessionID})
+}
+
+func (s *uploadService) purge(ctx context.Context) error {
+	sessions, err := s.uploadSessionRepository.ListOlderThan(ctx, 7*24*time.Hour)
+	if err != nil {
+		return err
+	}
+
+	failed := 0
 	for _, sess := range sessions {
+		if sess.Status == types.UploadSessionFinalizing {
+			finalizing, err := s.finalizing(ctx, sess.ID)
+			if err != nil {
+				fmt.Printf("upload GC: check session %s: %v\n", sess.ID, err)
+				failed++
+				continue
+			}
+			if finalizing {
+				continue
+			}
+		}
+		if err := s.uploadSessionRepository.Delete(ctx, sess.ID); err != nil {
+			fmt.Printf("upload GC: delete session %s: %v\n", sess.ID, err)
+			failed++
+			continue
+		}
 		dir := filepath.Join(s.temp, sess.ID.String())
 		if err := os.RemoveAll(dir); err != nil {
 			fmt.Printf("upload GC: remove tmp dir %s: %v\n", dir, err)
-		}
-		if err := s.uploadSessionRepository.Delete(context.Background(), sess.ID); err != nil {
-			fmt.Printf("upload GC: delete session %s: %v\n", sess.ID, err)
+			failed++
 		}
 	}
+	if failed > 0 {
+		return fmt.Errorf("upload GC: %d of %d sessions not purged", failed, len(sessions))
+	}
+	return nil
 }
@@ -2,25 +2,40 @@ package services
 
 import (
 	"context"
+	"fmt"
+	"time"
 
+	"github.com/CustomCloudStorage/jobs"
 	"github.com/CustomCloudStorage/repositories"
 	"github.com/CustomCloudStorage/types"
 )
 
+const (
+	reconcileInterval = 24 * time.Hour
+	// detachedReservationTTL bounds how long a single-request upload may
+	// hold its reservation before reconciliation drops it.
+	detachedReservationTTL = 24 * time.Hour
+)
+
 type UserService interface {
 	StatsStorage(ctx context.Context) (*types.StorageStats, error)
+	ReconcileStorage(ctx context.Context) ([]types.StorageUsage, error)
 }
 
 type userService struct {
-	userRepository repositories.UserRepository
-	cfg            ServiceConfig
+	userRepository        repositories.UserRepository
+	reservationRepository repositories.StorageReservationRepository
+	cfg                   ServiceConfig
 }
 
-func NewUserService(userRepo repositories.UserRepository, cfg ServiceConfig) UserService {
-	return &userService{
-		userRepository: userRepo,
-		cfg:            cfg,
+func NewUserService(userRepo repositories.UserRepository, reservationRepo repositories.StorageReservationRepository, queue *jobs.Queue, cfg ServiceConfig) UserService {
+	svc := &userService{
+		userRepository:        userRepo,
+		reservationRepository: reservationRepo,
+		cfg:                   cfg,
 	}
+	queue.Every("storage.reconcile", reconcileInterval, svc.reconcile)
+	return svc
 }
 
 func (s *userService) StatsStorage(ctx context.Context) (*types.StorageStats, error) {
@@ -37,3 +52,23 @@ func (s *userService) StatsStorage(ctx context.Context) (*types.StorageStats, er
 	}
 	return &stats, nil
 }
+
+// ReconcileStorage drops abandoned reservations and recomputes used_storage
+// from the stored file versions. It returns the accounts that were corrected.
+func (s *userService) ReconcileStorage(ctx context.Context) ([]types.StorageUsage, error) {
+	if _, err := s.reservationRepository.ExpireDetached(ctx, time.Now().Add(-detachedReservationTTL)); err != nil {
+		return nil, err
+	}
+	return s.reservationRepository.Reconcile(ctx, false)
+}
+
+func (s *userService) reconcile(ctx context.Context) error {
+	corrected, err := s.ReconcileStorage(ctx)
+	if err != nil {
+		return err
+	}
+	for _, u := range corrected {
+		fmt.Printf("storage reconcile: user %d used_storage %d -> %d\n", u.UserID, u.Recorded, u.Actual)
+	}
+	return nil
+}
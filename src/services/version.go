@@ -0,0 +1,157 @@
+package services
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/CustomCloudStorage/infrastructure/storage"
+	"github.com/CustomCloudStorage/jobs"
+	"github.com/CustomCloudStorage/repositories"
+	"github.com/CustomCloudStorage/types"
+	"github.com/google/uuid"
+)
+
+type VersionService interface {
+	ListVersions(ctx context.Context, userID, fileID int) ([]types.FileVersion, error)
+	DownloadVersion(ctx context.Context, userID, fileID, version int) (*types.DownloadedFile, error)
+	RestoreVersion(ctx context.Context, userID, fileID, version int) (*types.File, error)
+	AddVersion(ctx context.Context, fileID int, content types.FileVersion, reservation *uuid.UUID) (*types.File, error)
+	ApplyRetention(ctx context.Context, fileID int) error
+}
+
+type versionService struct {
+	reservationRepository repositories.StorageReservationRepository
+	fileRepository        repositories.FileRepository
+	versionRepository     repositories.FileVersionRepository
+	blobRepository        repositories.BlobRepository
+	blobStore             storage.BlobStore
+	accessService         AccessService
+	keepLast              int
+	keepFor               time.Duration
+}
+
+func NewVersionService(reservationRepo repositories.StorageReservationRepository, fileRepo repositories.FileRepository, versionRepo repositories.FileVersionRepository, blobRepo repositories.BlobRepository, blobStore storage.BlobStore, accessService AccessService, queue *jobs.Queue, cfg ServiceConfig) VersionService {
+	svc := &versionService{
+		reservationRepository: reservationRepo,
+		fileRepository:        fileRepo,
+		versionRepository:     versionRepo,
+		blobRepository:        blobRepo,
+		blobStore:             blobStore,
+		accessService:         accessService,
+		keepLast:              cfg.VersionsKeepLast,
+		keepFor:               time.Duration(cfg.VersionsKeepDays) * 24 * time.Hour,
+	}
+	queue.Every("versions.purge", time.Hour, svc.purge)
+	return svc
+}
+
+func (s *versionService) ListVersions(ctx context.Context, userID, fileID int) ([]types.FileVersion, error) {
+	if _, err := s.fileRepository.GetByID(ctx, fileID, userID); err != nil {
+		return nil, err
+	}
+	return s.versionRepository.ListByFile(ctx, fileID)
+}
+
+func (s *versionService) DownloadVersion(ctx context.Context, userID, fileID, version int) (*types.DownloadedFile, error) {
+	file, err := s.fileRepository.GetByID(ctx, fileID, userID)
+	if err != nil {
+		return nil, err
+	}
+	v, err := s.versionRepository.GetVersion(ctx, fileID, version)
+	if err != nil {
+		return nil, err
+	}
+
+	f, err := s.blobStore.Get(ctx, v.PhysicalName)
+	if err != nil {
+		return nil, err
+	}
+	return &types.DownloadedFile{
+		Reader:      f,
+		FileName:    file.Name + file.Extension,
+		ContentType: v.ContentType,
+		FileSize:    v.Size,
+		ModTime:     v.CreatedAt,
+		ETag:        contentETag(v.SHA256, file.ID, v.Version),
+	}, nil
+}
+
+func (s *versionService) RestoreVersion(ctx context.Context, userID, fileID, version int) (*types.File, error) {
+	file, err := s.fileRepository.GetByID(ctx, fileID, userID)
+	if err != nil {
+		return nil, err
+	}
+	if err := s.accessService.RequireFileEditor(ctx, userID, file); err != nil {
+		return nil, err
+	}
+	v, err := s.versionRepository.GetVersion(ctx, fileID, version)
+	if err != nil {
+		return nil, err
+	}
+
+	reservation := &types.StorageReservation{
+		ID:     uuid.New(),
+		UserID: file.UserID,
+		Size:   v.Size,
+	}
+	if err := s.reservationRepository.Reserve(ctx, reservation); err != nil {
+		return nil, err
+	}
+	if err := s.blobRepository.Acquire(ctx, v.PhysicalName, v.Size, nil); err != nil {
+		_ = s.reservationRepository.Release(ctx, reservation.ID)
+		return nil, err
+	}
+
+	restored, err := s.AddVersion(ctx, file.ID, *v, &reservation.ID)
+	if err != nil {
+		_ = releaseBlob(ctx, s.blobRepository, s.blobStore, v.PhysicalName)
+		_ = s.reservationRepository.Release(ctx, reservation.ID)
+		return nil, err
+	}
+	return restored, nil
+}
+
+func (s *versionService) AddVersion(ctx context.Context, fileID int, content types.FileVersion, reservation *uuid.UUID) (*types.File, error) {
+	file, err := s.versionRepository.AddVersion(ctx, fileID, content, reservation)
+	if err != nil {
+		return nil, err
+	}
+	if err := s.ApplyRetention(ctx, fileID); err != nil {
+		fmt.Printf("version retention: file %d: %v\n", fileID, err)
+	}
+	return file, nil
+}
+
+func (s *versionService) ApplyRetention(ctx context.Context, fileID int) error {
+	if s.keepLast <= 0 && s.keepFor <= 0 {
+		return nil
+	}
+	var before time.Time
+	if s.keepFor > 0 {
+		before = time.Now().Add(-s.keepFor)
+	}
+
+	expired, err := s.versionRepository.ListExpired(ctx, fileID, s.keepLast, before)
+	if err != nil {
+		return err
+	}
+	for _, v := range expired {
+		if err := s.pruneVersion(ctx, v); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (s *versionService) pruneVersion(ctx context.Context, v types.FileVersion) error {
+	orphaned, err := s.versionRepository.Delete(ctx, v.ID)
+	if err != nil || !orphaned {
+		return err
+	}
+	return deleteBlob(ctx, s.blobStore, v.PhysicalName)
+}
+
+func (s *versionService) purge(ctx context.Context) error {
+	return s.ApplyRetention(ctx, 0)
+}
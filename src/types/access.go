@@ -0,0 +1,21 @@
+package types
+
+import "time"
+
+const (
+	PermissionOwner  = "owner"
+	PermissionEditor = "editor"
+	PermissionViewer = "viewer"
+)
+
+type AccessGrant struct {
+	ID         int       `json:"id" gorm:"primaryKey;column:id"`
+	OwnerID    int       `json:"owner_id" gorm:"not null;column:owner_id"`
+	GranteeID  int       `json:"grantee_id" gorm:"not null;column:grantee_id"`
+	FileID     *int      `json:"file_id,omitempty" gorm:"column:file_id"`
+	FolderID   *int      `json:"folder_id,omitempty" gorm:"column:folder_id"`
+	Permission string    `json:"permission" gorm:"not null;column:permission"`
+	CreatedAt  time.Time `json:"created_at" gorm:"column:created_at;autoCreateTime"`
+	File       *File     `json:"file,omitempty" gorm:"foreignKey:FileID"`
+	Folder     *Folder   `json:"folder,omitempty" gorm:"foreignKey:FolderID"`
+}
@@ -0,0 +1,10 @@
+package types
+
+import "time"
+
+type Blob struct {
+	Hash      string    `json:"hash" gorm:"primaryKey;column:hash"`
+	Size      int64     `json:"size" gorm:"not null;column:size"`
+	RefCount  int       `json:"ref_count" gorm:"not null;column:ref_count"`
+	CreatedAt time.Time `json:"created_at" gorm:"column:created_at;autoCreateTime"`
+}
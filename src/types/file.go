@@ -6,24 +6,43 @@ import (
 )
 
 type File struct {
-	ID           int        `json:"id" gorm:"primaryKey;column:id"`
-	UserID       int        `json:"user_id" gorm:"not null;column:user_id"`
-	FolderID     *int       `json:"folder_id,omitempty" gorm:"column:folder_id"`
-	Name         string     `json:"name" gorm:"not null;column:name"`
-	Extension    string     `json:"extension" gorm:"not null;column:extension"`
-	Size         int64      `json:"size" gorm:"not null;column:size"`
-	PhysicalName string     `json:"physical_name" gorm:"not null;column:physical_name"`
-	CreatedAt    time.Time  `json:"created_at" gorm:"column:created_at"`
-	UpdatedAt    time.Time  `json:"updated_at" gorm:"column:updated_at"`
-	DeletedAt    *time.Time `json:"deleted_at,omitempty" gorm:"column:deleted_at"`
+	ID           int           `json:"id" gorm:"primaryKey;column:id"`
+	UserID       int           `json:"user_id" gorm:"not null;column:user_id"`
+	FolderID     *int          `json:"folder_id,omitempty" gorm:"column:folder_id"`
+	Name         string        `json:"name" gorm:"not null;column:name"`
+	Extension    string        `json:"extension" gorm:"not null;column:extension"`
+	Size         int64         `json:"size" gorm:"not null;column:size"`
+	PhysicalName string        `json:"physical_name" gorm:"not null;column:physical_name"`
+	SHA256       string        `json:"sha256,omitempty" gorm:"not null;column:sha256"`
+	ContentType  string        `json:"content_type" gorm:"not null;column:content_type"`
+	Metadata     *FileMetadata `json:"metadata,omitempty" gorm:"column:file_metadata;type:jsonb"`
+	Version      int           `json:"version" gorm:"not null;default:1;column:version"`
+	CreatedAt    time.Time     `json:"created_at" gorm:"column:created_at"`
+	UpdatedAt    time.Time     `json:"updated_at" gorm:"column:updated_at"`
+	DeletedAt    *time.Time    `json:"deleted_at,omitempty" gorm:"column:deleted_at"`
 }
 
+type FileVersion struct {
+	ID           int       `json:"id" gorm:"primaryKey;column:id"`
+	FileID       int       `json:"file_id" gorm:"not null;column:file_id"`
+	Version      int       `json:"version" gorm:"not null;column:version"`
+	Size         int64     `json:"size" gorm:"not null;column:size"`
+	PhysicalName string    `json:"physical_name" gorm:"not null;column:physical_name"`
+	SHA256       string    `json:"sha256,omitempty" gorm:"not null;column:sha256"`
+	ContentType  string    `json:"content_type" gorm:"not null;column:content_type"`
+	CreatedAt    time.Time `json:"created_at" gorm:"column:created_at;autoCreateTime"`
+	UserID       int       `json:"-" gorm:"->;column:user_id"`
+}
+
+// DownloadedFile is file content ready to be served. ETag is a quoted strong
+// entity tag identifying the content.
 type DownloadedFile struct {
 	Reader      io.ReadSeeker
 	FileName    string
 	ContentType string
 	FileSize    int64
 	ModTime     time.Time
+	ETag        string
 }
 type FileWithPath struct {
 	PhysicalName string `gorm:"column:physical_name"`
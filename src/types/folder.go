@@ -11,3 +11,21 @@ type Folder struct {
 	UpdatedAt time.Time  `json:"updated_at" gorm:"column:updated_at"`
 	DeletedAt *time.Time `json:"deleted_at,omitempty" gorm:"column:deleted_at"`
 }
+
+type FolderEntry struct {
+	Folder    `gorm:"embedded"`
+	Size      int64 `json:"size" gorm:"column:size"`
+	ItemCount int64 `json:"item_count" gorm:"column:item_count"`
+}
+
+type Breadcrumb struct {
+	ID   int    `json:"id" gorm:"column:id"`
+	Name string `json:"name" gorm:"column:name"`
+}
+
+type FolderChildren struct {
+	Folder  *Folder       `json:"folder,omitempty"`
+	Path    []Breadcrumb  `json:"path"`
+	Folders []FolderEntry `json:"folders"`
+	Files   []File        `json:"files"`
+}
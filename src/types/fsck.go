@@ -0,0 +1,46 @@
+package types
+
+import "time"
+
+// FsckReport lists the inconsistencies found between the database, the blob
+// store and the upload temp dir.
+type FsckReport struct {
+	CheckedAt       time.Time        `json:"checked_at"`
+	Repaired        bool             `json:"repaired"`
+	OrphanedBlobs   []OrphanedBlob   `json:"orphaned_blobs"`
+	MissingBlobs    []MissingBlob    `json:"missing_blobs"`
+	StaleTemp       []StaleTempEntry `json:"stale_temp"`
+	UsageMismatches []StorageUsage   `json:"usage_mismatches"`
+	Errors          []string         `json:"errors,omitempty"`
+}
+
+// Clean reports whether the check found nothing to fix.
+func (r *FsckReport) Clean() bool {
+	return len(r.OrphanedBlobs) == 0 && len(r.MissingBlobs) == 0 &&
+		len(r.StaleTemp) == 0 && len(r.UsageMismatches) == 0
+}
+
+// OrphanedBlob is content no file version refers to: an object in the blob
+// store (Stored), a row in the blobs table (Tracked), or both.
+type OrphanedBlob struct {
+	Key     string `json:"key"`
+	Size    int64  `json:"size"`
+	Stored  bool   `json:"stored"`
+	Tracked bool   `json:"tracked"`
+}
+
+// MissingBlob is a file version whose content is not in the blob store.
+type MissingBlob struct {
+	Key     string `json:"key"`
+	FileID  int    `json:"file_id"`
+	UserID  int    `json:"user_id"`
+	Version int    `json:"version"`
+	Current bool   `json:"current"`
+}
+
+// StaleTempEntry is a leftover in the upload temp dir.
+type StaleTempEntry struct {
+	Path      string    `json:"path"`
+	SessionID string    `json:"session_id,omitempty"`
+	ModTime   time.Time `json:"mod_time"`
+}
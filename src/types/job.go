@@ -0,0 +1,95 @@
+package types
+
+import (
+	"database/sql/driver"
+	"encoding/json"
+	"fmt"
+	"time"
+)
+
+const (
+	JobQueued    = "queued"
+	JobRunning   = "running"
+	JobSucceeded = "succeeded"
+	JobDead      = "dead"
+	JobCancelled = "cancelled"
+)
+
+// Job is a unit of background work. Failed attempts are retried until
+// MaxAttempts is reached, after which the job is dead: it stays in the table
+// as the dead-letter queue until an admin retries or cancels it.
+type Job struct {
+	ID          int        `json:"id" gorm:"primaryKey;column:id"`
+	Kind        string     `json:"kind" gorm:"not null;column:kind"`
+	Payload     RawJSON    `json:"payload" gorm:"type:jsonb;not null;column:payload"`
+	Status      string     `json:"status" gorm:"not null;default:queued;column:status"`
+	Attempts    int        `json:"attempts" gorm:"not null;column:attempts"`
+	MaxAttempts int        `json:"max_attempts" gorm:"not null;column:max_attempts"`
+	LastError   string     `json:"last_error,omitempty" gorm:"not null;column:last_error"`
+	UniqueKey   *string    `json:"unique_key,omitempty" gorm:"column:unique_key"`
+	RunAt       time.Time  `json:"run_at" gorm:"not null;column:run_at"`
+	LockedUntil *time.Time `json:"locked_until,omitempty" gorm:"column:locked_until"`
+	CreatedAt   time.Time  `json:"created_at" gorm:"column:created_at;autoCreateTime"`
+	UpdatedAt   time.Time  `json:"updated_at" gorm:"column:updated_at;autoUpdateTime"`
+	FinishedAt  *time.Time `json:"finished_at,omitempty" gorm:"column:finished_at"`
+}
+
+// JobCount is the number of jobs of a kind in a status.
+type JobCount struct {
+	Kind   string `json:"kind" gorm:"column:kind"`
+	Status string `json:"status" gorm:"column:status"`
+	Count  int64  `json:"count" gorm:"column:count"`
+}
+
+// RawJSON is a JSON document kept as is in a JSONB column.
+type RawJSON []byte
+
+func (j RawJSON) Value() (driver.Value, error) {
+	if len(j) == 0 {
+		return "{}", nil
+	}
+	return string(j), nil
+}
+
+func (j *RawJSON) Scan(value interface{}) error {
+	switch v := value.(type) {
+	case nil:
+		*j = nil
+	case []byte:
+		*j = append((*j)[:0], v...)
+	case string:
+		*j = RawJSON(v)
+	default:
+		return fmt.Errorf("cannot scan %T into RawJSON", value)
+	}
+	return nil
+}
+
+func (j RawJSON) MarshalJSON() ([]byte, error) {
+	if len(j) == 0 {
+		return []byte("null"), nil
+	}
+	return json.RawMessage(j).MarshalJSON()
+}
+
+const (
+	TaskRunning   = "running"
+	TaskSucceeded = "succeeded"
+	TaskFailed    = "failed"
+)
+
+// PeriodicTask describes the latest run of a periodic maintenance task. Each
+// run holds the task's lease, so LastReplica is the only replica that ran it.
+type PeriodicTask struct {
+	Name            string     `json:"name" gorm:"primaryKey;column:name"`
+	IntervalSeconds int64      `json:"interval_seconds" gorm:"not null;column:interval_seconds"`
+	LastStatus      string     `json:"last_status,omitempty" gorm:"not null;column:last_status"`
+	LastError       string     `json:"last_error,omitempty" gorm:"not null;column:last_error"`
+	LastReplica     string     `json:"last_replica,omitempty" gorm:"not null;column:last_replica"`
+	LastStartedAt   *time.Time `json:"last_started_at,omitempty" gorm:"column:last_started_at"`
+	LastFinishedAt  *time.Time `json:"last_finished_at,omitempty" gorm:"column:last_finished_at"`
+	LastDurationMs  int64      `json:"last_duration_ms" gorm:"not null;column:last_duration_ms"`
+	Runs            int64      `json:"runs" gorm:"not null;column:runs"`
+	Failures        int64      `json:"failures" gorm:"not null;column:failures"`
+	NextRunAt       time.Time  `json:"next_run_at" gorm:"-"`
+}
@@ -0,0 +1,53 @@
+package types
+
+import (
+	"database/sql/driver"
+	"encoding/json"
+	"fmt"
+	"time"
+)
+
+// FileMetadata is what the metadata extractor learned from a file's content.
+// Fields that do not apply to the file's kind are left out; a file whose
+// content yielded nothing gets an empty object.
+type FileMetadata struct {
+	Width       int          `json:"width,omitempty"`
+	Height      int          `json:"height,omitempty"`
+	TakenAt     *time.Time   `json:"taken_at,omitempty"`
+	CameraMake  string       `json:"camera_make,omitempty"`
+	CameraModel string       `json:"camera_model,omitempty"`
+	Location    *GeoLocation `json:"location,omitempty"`
+
+	DurationSeconds float64 `json:"duration_seconds,omitempty"`
+	VideoCodec      string  `json:"video_codec,omitempty"`
+	AudioCodec      string  `json:"audio_codec,omitempty"`
+
+	PageCount int    `json:"page_count,omitempty"`
+	Title     string `json:"title,omitempty"`
+}
+
+type GeoLocation struct {
+	Latitude  float64 `json:"latitude"`
+	Longitude float64 `json:"longitude"`
+}
+
+// Value stores the metadata in a JSONB column.
+func (m *FileMetadata) Value() (driver.Value, error) {
+	if m == nil {
+		return nil, nil
+	}
+	return json.Marshal(m)
+}
+
+func (m *FileMetadata) Scan(value interface{}) error {
+	switch v := value.(type) {
+	case nil:
+		return nil
+	case []byte:
+		return json.Unmarshal(v, m)
+	case string:
+		return json.Unmarshal([]byte(v), m)
+	default:
+		return fmt.Errorf("cannot scan %T into FileMetadata", value)
+	}
+}
@@ -0,0 +1,31 @@
+package types
+
+import (
+	"encoding/json"
+	"time"
+)
+
+// OutboxMessage is a message taken from an outbox. Deliveries counts the
+// times it has been handed to a worker, including this one.
+type OutboxMessage struct {
+	ID         string
+	Payload    []byte
+	Deliveries int64
+}
+
+// DeadLetter is a message that ran out of attempts, kept for inspection.
+type DeadLetter struct {
+	Payload    json.RawMessage `json:"payload"`
+	Error      string          `json:"error"`
+	Deliveries int64           `json:"deliveries"`
+	FailedAt   time.Time       `json:"failed_at"`
+}
+
+// OutboxStats counts an outbox's messages. Queued ones have not been handed to
+// a worker yet; InFlight ones are held by a worker or wait for a retry.
+type OutboxStats struct {
+	Queued   int64 `json:"queued"`
+	InFlight int64 `json:"in_flight"`
+	Failed   int64 `json:"failed"`
+	Sent     int64 `json:"sent"`
+}
@@ -0,0 +1,8 @@
+package types
+
+type PageQuery struct {
+	Limit  int
+	Cursor string
+	Sort   string
+	Order  string
+}
@@ -0,0 +1,33 @@
+package types
+
+import "time"
+
+type FileSearch struct {
+	Name           string
+	Extension      string
+	ContentType    string
+	MinSize        *int64
+	MaxSize        *int64
+	CreatedAfter   *time.Time
+	CreatedBefore  *time.Time
+	UpdatedAfter   *time.Time
+	UpdatedBefore  *time.Time
+	TakenAfter     *time.Time
+	TakenBefore    *time.Time
+	Camera         string
+	Title          string
+	HasLocation    bool
+	FolderID       *int
+	IncludeDeleted bool
+	Sort           string
+	Order          string
+	Limit          int
+	Offset         int
+}
+
+type FileSearchResult struct {
+	Files  []File `json:"files"`
+	Total  int64  `json:"total"`
+	Limit  int    `json:"limit"`
+	Offset int    `json:"offset"`
+}
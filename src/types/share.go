@@ -0,0 +1,109 @@
+package types
+
+import "time"
+
+type ShareLink struct {
+	ID            int        `json:"id" gorm:"primaryKey;column:id"`
+	Token         string     `json:"token" gorm:"not null;unique;column:token"`
+	UserID        int        `json:"user_id" gorm:"not null;column:user_id"`
+	FileID        *int       `json:"file_id,omitempty" gorm:"column:file_id"`
+	FolderID      *int       `json:"folder_id,omitempty" gorm:"column:folder_id"`
+	PasswordHash  string     `json:"-" gorm:"not null;column:password_hash"`
+	ExpiresAt     *time.Time `json:"expires_at,omitempty" gorm:"column:expires_at"`
+	MaxDownloads  *int       `json:"max_downloads,omitempty" gorm:"column:max_downloads"`
+	DownloadCount int        `json:"download_count" gorm:"not null;column:download_count"`
+	RevokedAt     *time.Time `json:"revoked_at,omitempty" gorm:"column:revoked_at"`
+	CreatedAt     time.Time  `json:"created_at" gorm:"column:created_at;autoCreateTime"`
+}
+
+type SharedContents struct {
+	Share   *PublicShareLink `json:"share"`
+	File    *PublicFile      `json:"file,omitempty"`
+	Folder  *PublicFolder    `json:"folder,omitempty"`
+	Folders []PublicFolder   `json:"folders,omitempty"`
+	Files   []PublicFile     `json:"files,omitempty"`
+}
+
+type PublicShareLink struct {
+	FileID        *int       `json:"file_id,omitempty"`
+	FolderID      *int       `json:"folder_id,omitempty"`
+	ExpiresAt     *time.Time `json:"expires_at,omitempty"`
+	MaxDownloads  *int       `json:"max_downloads,omitempty"`
+	DownloadCount int        `json:"download_count"`
+	CreatedAt     time.Time  `json:"created_at"`
+}
+
+type PublicFile struct {
+	ID          int           `json:"id"`
+	FolderID    *int          `json:"folder_id,omitempty"`
+	Name        string        `json:"name"`
+	Extension   string        `json:"extension"`
+	Size        int64         `json:"size"`
+	SHA256      string        `json:"sha256,omitempty"`
+	ContentType string        `json:"content_type"`
+	Metadata    *FileMetadata `json:"metadata,omitempty"`
+	Version     int           `json:"version"`
+	CreatedAt   time.Time     `json:"created_at"`
+	UpdatedAt   time.Time     `json:"updated_at"`
+}
+
+type PublicFolder struct {
+	ID        int       `json:"id"`
+	Name      string    `json:"name"`
+	ParentID  *int      `json:"parent_id,omitempty"`
+	CreatedAt time.Time `json:"created_at"`
+	UpdatedAt time.Time `json:"updated_at"`
+}
+
+func NewPublicShareLink(link *ShareLink) *PublicShareLink {
+	return &PublicShareLink{
+		FileID:        link.FileID,
+		FolderID:      link.FolderID,
+		ExpiresAt:     link.ExpiresAt,
+		MaxDownloads:  link.MaxDownloads,
+		DownloadCount: link.DownloadCount,
+		CreatedAt:     link.CreatedAt,
+	}
+}
+
+func NewPublicFile(file *File) *PublicFile {
+	return &PublicFile{
+		ID:          file.ID,
+		FolderID:    file.FolderID,
+		Name:        file.Name,
+		Extension:   file.Extension,
+		Size:        file.Size,
+		SHA256:      file.SHA256,
+		ContentType: file.ContentType,
+		Metadata:    file.Metadata,
+		Version:     file.Version,
+		CreatedAt:   file.CreatedAt,
+		UpdatedAt:   file.UpdatedAt,
+	}
+}
+
+func NewPublicFiles(files []File) []PublicFile {
+	publicFiles := make([]PublicFile, len(files))
+	for i, file := range files {
+		publicFiles[i] = *NewPublicFile(&file)
+	}
+	return publicFiles
+}
+
+func NewPublicFolder(folder *Folder) *PublicFolder {
+	return &PublicFolder{
+		ID:        folder.ID,
+		Name:      folder.Name,
+		ParentID:  folder.ParentID,
+		CreatedAt: folder.CreatedAt,
+		UpdatedAt: folder.UpdatedAt,
+	}
+}
+
+func NewPublicFolders(folders []Folder) []PublicFolder {
+	publicFolders := make([]PublicFolder, len(folders))
+	for i, folder := range folders {
+		publicFolders[i] = *NewPublicFolder(&folder)
+	}
+	return publicFolders
+}
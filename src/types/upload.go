@@ -6,15 +6,35 @@ import (
 	"github.com/google/uuid"
 )
 
+const (
+	UploadProtocolParts = "parts"
+	UploadProtocolTus   = "tus"
+)
+
+const (
+	UploadSessionOpen       = "open"
+	UploadSessionFinalizing = "finalizing"
+	UploadSessionCompleted  = "completed"
+	UploadSessionFailed     = "failed"
+)
+
+// UploadSession tracks an upload in progress. A non-zero PartSize means every
+// part but the last has exactly that size and is written at its offset.
 type UploadSession struct {
-	ID         uuid.UUID `json:"id" gorm:"type:uuid;primaryKey;column:id"`
-	UserID     int       `json:"user_id" gorm:"not null;column:user_id"`
-	FolderID   *int      `json:"folder_id,omitempty" gorm:"column:folder_id"`
-	Name       string    `json:"name" gorm:"not null;column:name"`
-	Extension  string    `json:"extension" gorm:"not null;column:extension"`
-	TotalParts int       `json:"total_parts" gorm:"not null;column:total_parts"`
-	TotalSize  int64     `json:"total_size" gorm:"not null;column:total_size"`
-	CreatedAt  time.Time `json:"created_at" gorm:"column:created_at;autoCreateTime"`
+	ID             uuid.UUID `json:"id" gorm:"type:uuid;primaryKey;column:id"`
+	UserID         int       `json:"user_id" gorm:"not null;column:user_id"`
+	FolderID       *int      `json:"folder_id,omitempty" gorm:"column:folder_id"`
+	Name           string    `json:"name" gorm:"not null;column:name"`
+	Extension      string    `json:"extension" gorm:"not null;column:extension"`
+	TotalParts     int       `json:"total_parts" gorm:"not null;column:total_parts"`
+	TotalSize      int64     `json:"total_size" gorm:"not null;column:total_size"`
+	PartSize       int64     `json:"part_size,omitempty" gorm:"not null;column:part_size"`
+	Protocol       string    `json:"protocol" gorm:"not null;column:protocol"`
+	ExpectedSHA256 string    `json:"sha256,omitempty" gorm:"not null;column:expected_sha256"`
+	Status         string    `json:"status" gorm:"not null;default:open;column:status"`
+	FileID         *int      `json:"file_id,omitempty" gorm:"column:file_id"`
+	Error          string    `json:"error,omitempty" gorm:"not null;column:error"`
+	CreatedAt      time.Time `json:"created_at" gorm:"column:created_at;autoCreateTime"`
 }
 
 type UploadPart struct {
@@ -23,3 +43,29 @@ type UploadPart struct {
 	Size       int64     `json:"size" gorm:"not null;column:size"`
 	UploadedAt time.Time `json:"uploaded_at" gorm:"column:uploaded_at;autoCreateTime"`
 }
+
+// UploadStatus is an open upload session together with the parts received so
+// far, enough for a client to resume it.
+type UploadStatus struct {
+	UploadSession
+	UploadedSize int64        `json:"uploaded_size"`
+	Parts        []UploadPart `json:"parts"`
+	MissingParts []int        `json:"missing_parts"`
+}
+
+// UploadProgress reports how far an upload has got. Once Status is completed
+// File holds the stored file.
+type UploadProgress struct {
+	Uploaded  int64  `json:"uploaded"`
+	Total     int    `json:"total"`
+	TotalSize int64  `json:"total_size"`
+	Status    string `json:"status"`
+	Error     string `json:"error,omitempty"`
+	File      *File  `json:"file,omitempty"`
+}
+
+// Checksum is a client-supplied digest of an uploaded chunk.
+type Checksum struct {
+	Algorithm string
+	Sum       []byte
+}
@@ -1,6 +1,10 @@
 package types
 
-import "time"
+import (
+	"time"
+
+	"github.com/google/uuid"
+)
 
 type User struct {
 	Id          int         `json:"id" gorm:"primaryKey;column:id"`
@@ -20,8 +24,8 @@ type Profile struct {
 type Account struct {
 	UserID       int       `json:"user_id" gorm:"primaryKey;column:user_id"`
 	Role         string    `json:"role" gorm:"column:role"`
-	StorageLimit int64     `json:"storage_limit" gorm:"column:storage_limit"type:bigint`
-	UsedStorage  int64     `json:"used_storage" gorm:"not null;column:used_storage"type:bigint`
+	StorageLimit int64     `json:"storage_limit" gorm:"column:storage_limit;type:bigint"`
+	UsedStorage  int64     `json:"used_storage" gorm:"not null;column:used_storage;type:bigint"`
 	UpdatedAt    time.Time `json:"updated_at" gorm:"column:updated_at;autoUpdateTime"`
 }
 
@@ -44,6 +48,23 @@ type StorageStats struct {
 	FreeBytes      int64 `json:"free_bytes"`
 }
 
+// StorageReservation holds quota for data that is still being uploaded.
+type StorageReservation struct {
+	ID              uuid.UUID  `json:"id" gorm:"type:uuid;primaryKey;column:id"`
+	UserID          int        `json:"user_id" gorm:"not null;column:user_id"`
+	UploadSessionID *uuid.UUID `json:"upload_session_id,omitempty" gorm:"type:uuid;column:upload_session_id"`
+	Size            int64      `json:"size" gorm:"not null;column:size"`
+	CreatedAt       time.Time  `json:"created_at" gorm:"column:created_at;autoCreateTime"`
+}
+
+// StorageUsage is the outcome of reconciling one account.
+type StorageUsage struct {
+	UserID   int   `json:"user_id"`
+	Recorded int64 `json:"recorded_bytes"`
+	Actual   int64 `json:"actual_bytes"`
+	Reserved int64 `json:"reserved_bytes"`
+}
+
 func NewPublicUser(user *User) *PublicUser {
 	return &PublicUser{
 		Id:        user.Id,
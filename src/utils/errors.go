@@ -20,6 +20,13 @@ var (
 	ErrUnauthorized    = Namespace.NewType("unauthorized")
 	ErrForbidden       = Namespace.NewType("forbidden")
 	ErrTooManyRequests = Namespace.NewType("too_many_requests")
+
+	ErrChecksumMismatch = ErrBadRequest.NewSubtype("checksum_mismatch")
+	ErrIncompleteUpload = ErrBadRequest.NewSubtype("incomplete_upload")
+
+	// PropertyDetails carries structured data that is returned to the client
+	// next to the error message.
+	PropertyDetails = errorx.RegisterProperty("details")
 )
 
 func DetermineSQLError(err error, context string) error {